@@ -7,9 +7,11 @@ import (
 
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
 	"google.golang.org/protobuf/types/known/emptypb"
 
 	pb "ebayclone-grpc/proto"
+	"ebayclone-grpc/src/money"
 )
 
 func main() {
@@ -24,6 +26,7 @@ func main() {
 	userClient := pb.NewUserServiceClient(conn)
 	sessionClient := pb.NewSessionServiceClient(conn)
 	listingClient := pb.NewListingServiceClient(conn)
+	categoryClient := pb.NewCategoryServiceClient(conn)
 	orderClient := pb.NewOrderServiceClient(conn)
 
 	ctx, cancel := context.WithTimeout(context.Background(), time.Second*10)
@@ -56,6 +59,9 @@ func main() {
 	}
 	log.Printf("Login successful, token: %s", loginResp.Token[:20]+"...")
 
+	// Make the remaining calls as the logged-in user
+	ctx = metadata.AppendToOutgoingContext(ctx, "authorization", "Bearer "+loginResp.Token)
+
 	// 3. Get user
 	log.Println("\n3. Getting user...")
 	retrievedUser, err := userClient.GetUser(ctx, &pb.GetUserRequest{Id: user.Id})
@@ -67,10 +73,20 @@ func main() {
 
 	// 4. Create a listing
 	log.Println("\n4. Creating listing...")
+	category, err := categoryClient.CreateCategory(ctx, &pb.CategoryCreate{
+		Slug: "electronics",
+		Name: "Electronics",
+	})
+	if err != nil {
+		log.Printf("Failed to create category: %v", err)
+		return
+	}
+	log.Printf("Created category: ID=%d, Slug=%s", category.Id, category.Slug)
+
 	listing, err := listingClient.CreateListing(ctx, &pb.ListingCreate{
 		Title:       "iPhone 13 Pro Max",
 		Description: "Brand new, still in box",
-		Price:       999.99,
+		Price:       money.New("USD", 99999), // $999.99
 		Category:    "electronics",
 		Condition:   "new",
 		Location:    "New York, NY",
@@ -79,14 +95,14 @@ func main() {
 		log.Printf("Failed to create listing: %v", err)
 		return
 	}
-	log.Printf("Created listing: ID=%d, Title=%s, Price=$%.2f", listing.Id, listing.Title, listing.Price)
+	log.Printf("Created listing: ID=%d, Title=%s, Price=%s", listing.Id, listing.Title, money.Format(listing.Price))
 
 	// 5. Get listings
 	log.Println("\n5. Getting listings...")
 	listingsResp, err := listingClient.GetListings(ctx, &pb.ListingsRequest{
 		Search:   "iPhone",
-		PriceMin: 500,
-		PriceMax: 1500,
+		PriceMin: money.New("USD", 50000),
+		PriceMax: money.New("USD", 150000),
 	})
 	if err != nil {
 		log.Printf("Failed to get listings: %v", err)
@@ -112,7 +128,7 @@ func main() {
 		log.Printf("Failed to create order: %v", err)
 		return
 	}
-	log.Printf("Created order: ID=%d, Status=%s, Total=$%.2f", order.Id, order.Status, order.TotalPrice)
+	log.Printf("Created order: ID=%d, Status=%s, Total=%s", order.Id, order.Status, money.Format(order.TotalPrice))
 
 	// 7. Get orders
 	log.Println("\n7. Getting orders...")
@@ -130,7 +146,7 @@ func main() {
 	log.Println("\n8. Updating order status...")
 	updatedOrder, err := orderClient.UpdateOrderStatus(ctx, &pb.UpdateOrderStatusRequest{
 		Id:     order.Id,
-		Status: "confirmed",
+		Status: pb.OrderStatus_ORDER_STATUS_CONFIRMED,
 	})
 	if err != nil {
 		log.Printf("Failed to update order status: %v", err)
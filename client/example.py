@@ -35,6 +35,7 @@ def main():
     user_stub = pb2_grpc.UserServiceStub(channel)
     session_stub = pb2_grpc.SessionServiceStub(channel)
     listing_stub = pb2_grpc.ListingServiceStub(channel)
+    category_stub = pb2_grpc.CategoryServiceStub(channel)
     order_stub = pb2_grpc.OrderServiceStub(channel)
 
     print("=== eBayClone gRPC Python Client Example ===")
@@ -59,22 +60,27 @@ def main():
 
         # 3. Create a listing
         print("\n3. Creating listing...")
+        try:
+            category_stub.CreateCategory(pb2.CategoryCreate(slug="electronics", name="Electronics"))
+        except grpc.RpcError as e:
+            if e.code() != grpc.StatusCode.ALREADY_EXISTS:
+                raise
         listing = listing_stub.CreateListing(pb2.ListingCreate(
             title="MacBook Pro",
             description="Excellent condition laptop",
-            price=1299.99,
+            price=pb2.Money(currency_code="USD", minor_units=129999),
             category="electronics",
             condition="like-new",
             location="San Francisco, CA"
         ))
-        print(f"Created listing: ID={listing.id}, Title={listing.title}, Price=${listing.price}")
+        print(f"Created listing: ID={listing.id}, Title={listing.title}, Price={listing.price.minor_units / 100:.2f} {listing.price.currency_code}")
 
         # 4. Search listings
         print("\n4. Searching listings...")
         listings_resp = listing_stub.GetListings(pb2.ListingsRequest(
             search="MacBook",
-            price_min=1000,
-            price_max=2000
+            price_min=pb2.Money(currency_code="USD", minor_units=100000),
+            price_max=pb2.Money(currency_code="USD", minor_units=200000)
         ))
         print(f"Found {len(listings_resp.listings)} listings")
 
@@ -92,15 +98,15 @@ def main():
             ),
             buyer_notes="Handle with care"
         ))
-        print(f"Created order: ID={order.id}, Status={order.status}, Total=${order.total_price}")
+        print(f"Created order: ID={order.id}, Status={pb2.OrderStatus.Name(order.status)}, Total={order.total_price.minor_units / 100:.2f} {order.total_price.currency_code}")
 
         # 6. Update order status
         print("\n6. Updating order status...")
         updated_order = order_stub.UpdateOrderStatus(pb2.UpdateOrderStatusRequest(
             id=order.id,
-            status="shipped"
+            status=pb2.ORDER_STATUS_CONFIRMED
         ))
-        print(f"Updated order status to: {updated_order.status}")
+        print(f"Updated order status to: {pb2.OrderStatus.Name(updated_order.status)}")
 
         print("\n=== Python client example completed successfully! ===")
 
@@ -4,6 +4,7 @@ go 1.23.0
 
 require (
 	github.com/golang-jwt/jwt/v5 v5.3.0
+	golang.org/x/image v0.24.0
 	google.golang.org/grpc v1.75.0
 	google.golang.org/protobuf v1.36.6
 )
@@ -0,0 +1,225 @@
+// Package auction implements the bidding rules of auction listings: the
+// minimum increment over the current price, who leads and whether the
+// reserve is met. It keeps no state of its own; storage applies its results
+// in the same transaction that records the bid.
+package auction
+
+import (
+	"time"
+
+	"google.golang.org/protobuf/types/known/timestamppb"
+
+	pb "ebayclone-grpc/proto"
+	"ebayclone-grpc/src/money"
+)
+
+// increments is the bid increment table. Each step applies to prices below
+// the threshold, both in major units of the listing's currency; steps are in
+// hundredths of a major unit, e.g. 5 is 0.05 USD.
+var increments = []struct {
+	below int64
+	step  int64
+}{
+	{1, 5},
+	{5, 25},
+	{25, 50},
+	{100, 100},
+	{250, 250},
+	{500, 500},
+	{1000, 1000},
+	{2500, 2500},
+	{5000, 5000},
+	{0, 10000}, // Everything above
+}
+
+// BidTooLowError is returned for a bid below the auction's minimum bid.
+type BidTooLowError struct {
+	Minimum *pb.Money
+}
+
+func (e *BidTooLowError) Error() string {
+	return "Bid must be at least " + money.Format(e.Minimum)
+}
+
+// Increment returns how much the next bid must exceed price by. Currencies
+// without enough minor digits for a step round it up to the nearest unit.
+func Increment(price *pb.Money) *pb.Money {
+	digits, _ := money.Digits(price.GetCurrencyCode())
+	scale := int64(1)
+	for i := 0; i < digits; i++ {
+		scale *= 10
+	}
+
+	for _, inc := range increments {
+		if inc.below == 0 || price.GetMinorUnits() < inc.below*scale {
+			return money.New(price.GetCurrencyCode(), (inc.step*scale+99)/100)
+		}
+	}
+	return nil
+}
+
+// MinimumBid returns the lowest amount the next bid on an auction at price
+// may be: the start price until the first bid, then one increment above the
+// current price.
+func MinimumBid(a *pb.Auction, price *pb.Money) *pb.Money {
+	if a.BidCount == 0 {
+		return a.StartPrice
+	}
+	return add(price, Increment(price))
+}
+
+// Bid applies bid to listing, an auction the caller owns a copy of, and
+// returns the bids to record in the order they were placed. bid.Amount is
+// the least the bidder bids now (the minimum bid if unset) and
+// bid.MaxAmount the most the system may bid for them (bid.Amount if unset);
+// leaderMax is the high bidder's maximum. Competing maximums are resolved
+// to the lowest price at which the highest one wins: one increment over the
+// runner-up, capped at the winner's maximum, and raised to the reserve when
+// the winner's maximum reaches it. Equal maximums go to the earlier bid.
+// Each bid's Amount is set to what it stands at, and the high bidder's
+// proxy is recorded as an automatic bid whenever it responds.
+func Bid(listing *pb.Listing, leaderMax *pb.Money, bid *pb.Bid) ([]*pb.Bid, error) {
+	a := listing.Auction
+	price := listing.Price
+	minimum := MinimumBid(a, price)
+	if bid.Amount == nil {
+		bid.Amount = minimum
+	}
+	if bid.MaxAmount == nil {
+		bid.MaxAmount = bid.Amount
+	}
+	for _, amount := range []*pb.Money{bid.Amount, bid.MaxAmount} {
+		if err := money.SameCurrency(a.StartPrice, amount); err != nil {
+			return nil, err
+		}
+	}
+	if leaderMax == nil {
+		leaderMax = price
+	}
+
+	var placed []*pb.Bid
+	var leader *pb.Bid // The bid the leader now stands at
+	switch {
+	case a.BidCount > 0 && bid.UserId == a.HighBidderId:
+		// The high bidder can only raise their maximum
+		if !less(leaderMax, bid.MaxAmount) {
+			return nil, &BidTooLowError{Minimum: add(leaderMax, Increment(leaderMax))}
+		}
+		bid.Amount = price
+		leader = bid
+		placed = []*pb.Bid{bid}
+
+	case less(bid.Amount, minimum) || less(bid.MaxAmount, minimum):
+		return nil, &BidTooLowError{Minimum: minimum}
+
+	case a.BidCount == 0:
+		leader = bid
+		placed = []*pb.Bid{bid}
+
+	case less(leaderMax, bid.MaxAmount):
+		// The new bid beats the high bidder's proxy, which first bids up to
+		// its maximum
+		if less(price, leaderMax) {
+			placed = append(placed, automatic(listing, a.HighBidderId, leaderMax, leaderMax))
+		}
+		bid.Amount = maxOf(bid.Amount, minOf(bid.MaxAmount, add(leaderMax, Increment(leaderMax))))
+		leader = bid
+		placed = append(placed, bid)
+
+	default:
+		// The high bidder's proxy outbids the new bid by an increment, or
+		// matches it and wins as the earlier bid
+		bid.Amount = bid.MaxAmount
+		leader = automatic(listing, a.HighBidderId, minOf(leaderMax, add(bid.MaxAmount, Increment(bid.MaxAmount))), leaderMax)
+		placed = []*pb.Bid{bid, leader}
+	}
+
+	// A winning maximum at or above the reserve meets it
+	if a.ReservePrice != nil && less(leader.Amount, a.ReservePrice) && !less(leader.MaxAmount, a.ReservePrice) {
+		leader.Amount = a.ReservePrice
+	}
+
+	listing.Price = leader.Amount
+	a.BidCount += int32(len(placed))
+	a.HighBidderId = leader.UserId
+	a.ReserveMet = ReserveMet(a, leader.Amount)
+	a.MinimumBid = MinimumBid(a, leader.Amount)
+	a.BuyItNowAvailable = BuyItNowAvailable(a)
+	return placed, nil
+}
+
+// Extend pushes back the end of listing, an auction the caller owns a copy
+// of, when a bid at now lands within its extension window, so the end is
+// always at least a window away from the latest bid. It reports whether the
+// end moved.
+func Extend(listing *pb.Listing, now time.Time) bool {
+	window := listing.Auction.ExtensionWindow.AsDuration()
+	if window <= 0 || listing.EndsAt == nil || listing.EndsAt.AsTime().Sub(now) >= window {
+		return false
+	}
+	listing.EndsAt = timestamppb.New(now.Add(window))
+	listing.Auction.ExtensionCount++
+	return true
+}
+
+// BuyItNowAvailable reports whether an auction can still be bought at its
+// Buy It Now price: until the first bid, or while bidding is below the
+// reserve if there is one.
+func BuyItNowAvailable(a *pb.Auction) bool {
+	if a.BuyItNowPrice == nil {
+		return false
+	}
+	return a.BidCount == 0 || (a.ReservePrice != nil && !a.ReserveMet)
+}
+
+func automatic(listing *pb.Listing, userID int32, amount, max *pb.Money) *pb.Bid {
+	return &pb.Bid{ListingId: listing.Id, UserId: userID, Amount: amount, MaxAmount: max, Automatic: true}
+}
+
+// ReserveMet reports whether price reaches the auction's reserve. Auctions
+// without a reserve always meet it.
+func ReserveMet(a *pb.Auction, price *pb.Money) bool {
+	if a.ReservePrice == nil {
+		return true
+	}
+	c, err := money.Compare(price, a.ReservePrice)
+	return err == nil && c >= 0
+}
+
+// Winner returns the user an auction sells to when it closes, or 0 if it
+// received no bids or did not meet its reserve.
+func Winner(a *pb.Auction) int32 {
+	if a.BidCount == 0 || !a.ReserveMet {
+		return 0
+	}
+	return a.HighBidderId
+}
+
+// less, add, minOf and maxOf work on amounts already checked to be in
+// the listing's currency.
+func less(a, b *pb.Money) bool {
+	c, _ := money.Compare(a, b)
+	return c < 0
+}
+
+func add(a, b *pb.Money) *pb.Money {
+	sum, err := money.Add(a, b)
+	if err != nil {
+		return a
+	}
+	return sum
+}
+
+func minOf(a, b *pb.Money) *pb.Money {
+	if less(b, a) {
+		return b
+	}
+	return a
+}
+
+func maxOf(a, b *pb.Money) *pb.Money {
+	if less(a, b) {
+		return b
+	}
+	return a
+}
@@ -0,0 +1,348 @@
+package auction
+
+import (
+	"testing"
+	"time"
+
+	"google.golang.org/protobuf/types/known/durationpb"
+	"google.golang.org/protobuf/types/known/timestamppb"
+
+	pb "ebayclone-grpc/proto"
+	"ebayclone-grpc/src/money"
+)
+
+func TestIncrement(t *testing.T) {
+	tests := []struct {
+		price *pb.Money
+		want  int64
+	}{
+		{money.New("USD", 50), 5},
+		{money.New("USD", 99), 5},
+		{money.New("USD", 100), 25}, // Thresholds start the next step
+		{money.New("USD", 2499), 50},
+		{money.New("USD", 9999), 100},
+		{money.New("USD", 24999), 250},
+		{money.New("USD", 100000), 2500},
+		{money.New("USD", 1000000), 10000},
+		{money.New("JPY", 50), 1}, // 0.05 rounds up to a whole yen
+		{money.New("JPY", 300), 5},
+		{money.New("KWD", 500), 50},
+	}
+	for _, tt := range tests {
+		if got := Increment(tt.price); got.MinorUnits != tt.want || got.CurrencyCode != tt.price.CurrencyCode {
+			t.Errorf("Increment(%s): expected %d, got %s", money.Format(tt.price), tt.want, money.Format(got))
+		}
+	}
+}
+
+// newListing returns an auction starting at start with an optional reserve.
+func newListing(start, reserve int64) *pb.Listing {
+	listing := &pb.Listing{
+		Price:   money.New("USD", start),
+		Auction: &pb.Auction{StartPrice: money.New("USD", start), MinimumBid: money.New("USD", start)},
+	}
+	if reserve > 0 {
+		listing.Auction.ReservePrice = money.New("USD", reserve)
+	}
+	return listing
+}
+
+// bidder plays bids against one listing, keeping every bidder's maximum the
+// way storage does.
+type bidder struct {
+	listing *pb.Listing
+	bids    []*pb.Bid
+}
+
+func (b *bidder) place(userID int32, amount, max int64) ([]*pb.Bid, error) {
+	bid := &pb.Bid{UserId: userID}
+	if amount > 0 {
+		bid.Amount = money.New("USD", amount)
+	}
+	if max > 0 {
+		bid.MaxAmount = money.New("USD", max)
+	}
+
+	var leaderMax *pb.Money
+	for i := len(b.bids) - 1; i >= 0; i-- {
+		if b.bids[i].UserId == b.listing.Auction.HighBidderId {
+			leaderMax = b.bids[i].MaxAmount
+			break
+		}
+	}
+	placed, err := Bid(b.listing, leaderMax, bid)
+	if err == nil {
+		b.bids = append(b.bids, placed...)
+	}
+	return placed, err
+}
+
+func TestBid(t *testing.T) {
+	b := &bidder{listing: newListing(1000, 2000)}
+
+	// The first bid may be the start price itself
+	if _, err := Bid(b.listing, nil, &pb.Bid{UserId: 2, Amount: money.New("EUR", 1000)}); err == nil {
+		t.Errorf("Expected error for a bid in another currency")
+	}
+	if _, err := b.place(2, 999, 0); err == nil {
+		t.Errorf("Expected error for a bid below the start price")
+	}
+	if _, err := b.place(2, 1000, 0); err != nil {
+		t.Fatalf("Bid failed: %v", err)
+	}
+	a := b.listing.Auction
+	if a.HighBidderId != 2 || a.BidCount != 1 || b.listing.Price.MinorUnits != 1000 {
+		t.Errorf("Expected user 2 to lead at 10.00 USD, got %v", a)
+	}
+	if a.MinimumBid.MinorUnits != 1050 {
+		t.Errorf("Expected minimum bid of 10.50 USD, got %s", money.Format(a.MinimumBid))
+	}
+	if Winner(a) != 0 {
+		t.Errorf("Expected no winner below the reserve")
+	}
+
+	// Later bids must beat the current price by an increment
+	_, err := b.place(3, 1049, 0)
+	if e, ok := err.(*BidTooLowError); !ok || e.Minimum.MinorUnits != 1050 {
+		t.Errorf("Expected BidTooLowError with minimum 10.50 USD, got %v", err)
+	}
+	if _, err := b.place(3, 2000, 0); err != nil {
+		t.Fatalf("Bid failed: %v", err)
+	}
+	if !a.ReserveMet || Winner(a) != 3 {
+		t.Errorf("Expected user 3 to win once the reserve is met, got %v", a)
+	}
+	if a.MinimumBid.MinorUnits != 2050 {
+		t.Errorf("Expected minimum bid of 20.50 USD, got %s", money.Format(a.MinimumBid))
+	}
+}
+
+func TestProxyBid(t *testing.T) {
+	b := &bidder{listing: newListing(1000, 0)}
+	a := b.listing.Auction
+
+	// A maximum bids no more than needed
+	if _, err := b.place(2, 0, 5000); err != nil {
+		t.Fatalf("Bid failed: %v", err)
+	}
+	if b.listing.Price.MinorUnits != 1000 || a.HighBidderId != 2 {
+		t.Errorf("Expected user 2 to lead at the start price, got %s", money.Format(b.listing.Price))
+	}
+
+	// A lower maximum is outbid by one increment automatically
+	placed, err := b.place(3, 0, 3000)
+	if err != nil {
+		t.Fatalf("Bid failed: %v", err)
+	}
+	if len(placed) != 2 || placed[0].Amount.MinorUnits != 3000 || !placed[1].Automatic || placed[1].UserId != 2 {
+		t.Errorf("Expected user 3 at 30.00 USD then an automatic bid by user 2, got %v", placed)
+	}
+	if b.listing.Price.MinorUnits != 3100 || a.HighBidderId != 2 || a.BidCount != 3 {
+		t.Errorf("Expected user 2 to lead at 31.00 USD after 3 bids, got %s %v", money.Format(b.listing.Price), a)
+	}
+
+	// A higher maximum takes the lead one increment above the old one, after
+	// the old proxy has bid up to its maximum
+	placed, err = b.place(4, 0, 9000)
+	if err != nil {
+		t.Fatalf("Bid failed: %v", err)
+	}
+	if len(placed) != 2 || placed[0].UserId != 2 || placed[0].Amount.MinorUnits != 5000 || placed[1].Amount.MinorUnits != 5100 {
+		t.Errorf("Expected user 2 at 50.00 USD then user 4 at 51.00 USD, got %v", placed)
+	}
+	if b.listing.Price.MinorUnits != 5100 || a.HighBidderId != 4 {
+		t.Errorf("Expected user 4 to lead at 51.00 USD, got %s", money.Format(b.listing.Price))
+	}
+
+	// A jump bid sets the price above what the proxy needs
+	if _, err := b.place(5, 9500, 12000); err != nil {
+		t.Fatalf("Bid failed: %v", err)
+	}
+	if b.listing.Price.MinorUnits != 9500 || a.HighBidderId != 5 {
+		t.Errorf("Expected user 5 to lead at 95.00 USD, got %s", money.Format(b.listing.Price))
+	}
+
+	// The high bidder may raise their maximum without raising the price
+	if _, err := b.place(5, 0, 11000); err == nil {
+		t.Errorf("Expected error for lowering a maximum")
+	}
+	if _, err := b.place(5, 0, 20000); err != nil {
+		t.Fatalf("Bid failed: %v", err)
+	}
+	if b.listing.Price.MinorUnits != 9500 || a.HighBidderId != 5 {
+		t.Errorf("Expected price to stay at 95.00 USD, got %s", money.Format(b.listing.Price))
+	}
+
+	// Equal maximums go to the earlier bid
+	if _, err := b.place(6, 0, 20000); err != nil {
+		t.Fatalf("Bid failed: %v", err)
+	}
+	if b.listing.Price.MinorUnits != 20000 || a.HighBidderId != 5 {
+		t.Errorf("Expected user 5 to keep the lead at 200.00 USD, got user %d at %s", a.HighBidderId, money.Format(b.listing.Price))
+	}
+}
+
+func TestProxyBidReserve(t *testing.T) {
+	b := &bidder{listing: newListing(1000, 4000)}
+	a := b.listing.Auction
+
+	// A maximum below the reserve bids as usual
+	if _, err := b.place(2, 0, 3000); err != nil {
+		t.Fatalf("Bid failed: %v", err)
+	}
+	if b.listing.Price.MinorUnits != 1000 || a.ReserveMet {
+		t.Errorf("Expected 10.00 USD with the reserve not met, got %s", money.Format(b.listing.Price))
+	}
+
+	// A maximum at or above the reserve takes the price straight to it
+	if _, err := b.place(3, 0, 6000); err != nil {
+		t.Fatalf("Bid failed: %v", err)
+	}
+	if b.listing.Price.MinorUnits != 4000 || !a.ReserveMet || Winner(a) != 3 {
+		t.Errorf("Expected user 3 to win at the 40.00 USD reserve, got %s %v", money.Format(b.listing.Price), a)
+	}
+}
+
+// TestProxyBidSequences places the same proxy bids in every order. Whatever
+// the order, the highest accepted maximum wins (the earliest of equal ones)
+// at one increment over the runner-up's maximum, capped at its own and
+// raised to the reserve when it reaches it.
+func TestProxyBidSequences(t *testing.T) {
+	maximums := map[int32]int64{2: 500, 3: 499, 4: 2500, 5: 2500, 6: 775}
+	users := []int32{2, 3, 4, 5, 6}
+
+	for _, reserve := range []int64{0, 2000, 3000} {
+		permute(users, func(order []int32) {
+			b := &bidder{listing: newListing(100, reserve)}
+			var accepted []int32
+			for _, user := range order {
+				if _, err := b.place(user, 0, maximums[user]); err == nil {
+					accepted = append(accepted, user)
+				} else if _, ok := err.(*BidTooLowError); !ok {
+					t.Fatalf("Bid failed: %v", err)
+				}
+
+				// The price never exceeds the high bidder's maximum
+				if b.listing.Price.MinorUnits > maximums[b.listing.Auction.HighBidderId] {
+					t.Fatalf("Order %v: price %s above the high bidder's maximum", order, money.Format(b.listing.Price))
+				}
+			}
+
+			// Work out the expected result from the accepted bids alone
+			winner := accepted[0]
+			for _, user := range accepted[1:] {
+				if maximums[user] > maximums[winner] {
+					winner = user
+				}
+			}
+			price := int64(100)
+			var runnerUp int64
+			for _, user := range accepted {
+				if user != winner && maximums[user] > runnerUp {
+					runnerUp = maximums[user]
+				}
+			}
+			if runnerUp > 0 {
+				price = runnerUp + Increment(money.New("USD", runnerUp)).MinorUnits
+				if price > maximums[winner] {
+					price = maximums[winner]
+				}
+			}
+			if reserve > 0 && maximums[winner] >= reserve && price < reserve {
+				price = reserve
+			}
+
+			a := b.listing.Auction
+			if a.HighBidderId != winner || b.listing.Price.MinorUnits != price {
+				t.Errorf("Order %v reserve %d: expected user %d at %d, got user %d at %d",
+					order, reserve, winner, price, a.HighBidderId, b.listing.Price.MinorUnits)
+			}
+			if int(a.BidCount) != len(b.bids) {
+				t.Errorf("Order %v: expected bid count %d, got %d", order, len(b.bids), a.BidCount)
+			}
+			if a.ReserveMet != (reserve == 0 || price >= reserve) {
+				t.Errorf("Order %v reserve %d: unexpected reserve met %v at %d", order, reserve, a.ReserveMet, price)
+			}
+		})
+	}
+}
+
+func TestExtend(t *testing.T) {
+	end := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+	listing := newListing(1000, 0)
+	listing.EndsAt = timestamppb.New(end)
+
+	// Without a window the end never moves
+	if Extend(listing, end.Add(-time.Second)) {
+		t.Errorf("Expected no extension without a window")
+	}
+
+	listing.Auction.ExtensionWindow = durationpb.New(2 * time.Minute)
+	if Extend(listing, end.Add(-2*time.Minute)) {
+		t.Errorf("Expected no extension for a bid a full window before the end")
+	}
+
+	// A late bid leaves a full window after it
+	if !Extend(listing, end.Add(-time.Minute)) {
+		t.Fatalf("Expected extension for a bid within the window")
+	}
+	if !listing.EndsAt.AsTime().Equal(end.Add(time.Minute)) || listing.Auction.ExtensionCount != 1 {
+		t.Errorf("Expected end a minute later after 1 extension, got %v after %d", listing.EndsAt.AsTime(), listing.Auction.ExtensionCount)
+	}
+	if !Extend(listing, end.Add(30*time.Second)) || listing.Auction.ExtensionCount != 2 {
+		t.Errorf("Expected the extended end to extend again, got %d extensions", listing.Auction.ExtensionCount)
+	}
+}
+
+func TestBuyItNowAvailable(t *testing.T) {
+	// Without a reserve the first bid removes Buy It Now
+	b := &bidder{listing: newListing(1000, 0)}
+	a := b.listing.Auction
+	if BuyItNowAvailable(a) {
+		t.Errorf("Expected Buy It Now to be unavailable without a price")
+	}
+	a.BuyItNowPrice = money.New("USD", 5000)
+	if !BuyItNowAvailable(a) {
+		t.Errorf("Expected Buy It Now to be available before the first bid")
+	}
+	if _, err := b.place(2, 1000, 0); err != nil {
+		t.Fatalf("Bid failed: %v", err)
+	}
+	if a.BuyItNowAvailable {
+		t.Errorf("Expected the first bid to remove Buy It Now")
+	}
+
+	// With a reserve it stays until the reserve is met
+	b = &bidder{listing: newListing(1000, 3000)}
+	a = b.listing.Auction
+	a.BuyItNowPrice = money.New("USD", 5000)
+	if _, err := b.place(2, 1000, 0); err != nil {
+		t.Fatalf("Bid failed: %v", err)
+	}
+	if !a.BuyItNowAvailable {
+		t.Errorf("Expected Buy It Now to stay below the reserve")
+	}
+	if _, err := b.place(3, 3000, 0); err != nil {
+		t.Fatalf("Bid failed: %v", err)
+	}
+	if a.BuyItNowAvailable {
+		t.Errorf("Expected meeting the reserve to remove Buy It Now")
+	}
+}
+
+// permute calls f with every ordering of items.
+func permute(items []int32, f func([]int32)) {
+	var walk func(k int)
+	walk = func(k int) {
+		if k == len(items) {
+			f(append([]int32(nil), items...))
+			return
+		}
+		for i := k; i < len(items); i++ {
+			items[k], items[i] = items[i], items[k]
+			walk(k + 1)
+			items[k], items[i] = items[i], items[k]
+		}
+	}
+	walk(0)
+}
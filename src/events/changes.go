@@ -0,0 +1,54 @@
+package events
+
+import (
+	"time"
+
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/timestamppb"
+
+	pb "ebayclone-grpc/proto"
+)
+
+// Snapshot returns the event that starts a watch: the listing as it is now.
+func Snapshot(listing *pb.Listing, now time.Time) *pb.ListingEvent {
+	return newEvent(pb.ListingEventType_LISTING_EVENT_TYPE_SNAPSHOT, listing, now)
+}
+
+// Changes returns the events that take a listing from before to after, with
+// bids the bids placed along the way: one event per bid, then price, end
+// time and end of sale changes. Bids are copied without their maximums,
+// which only their bidder may see.
+func Changes(before, after *pb.Listing, bids []*pb.Bid, now time.Time) []*pb.ListingEvent {
+	var events []*pb.ListingEvent
+	for _, bid := range bids {
+		event := newEvent(pb.ListingEventType_LISTING_EVENT_TYPE_BID_PLACED, after, now)
+		event.Bid = proto.Clone(bid).(*pb.Bid)
+		event.Bid.MaxAmount = nil
+		events = append(events, event)
+	}
+	if !proto.Equal(before.Price, after.Price) {
+		events = append(events, newEvent(pb.ListingEventType_LISTING_EVENT_TYPE_PRICE_CHANGED, after, now))
+	}
+	if before.EndsAt != nil && !proto.Equal(before.EndsAt, after.EndsAt) && !Ended(after) {
+		events = append(events, newEvent(pb.ListingEventType_LISTING_EVENT_TYPE_EXTENDED, after, now))
+	}
+	if Ended(after) && !Ended(before) {
+		events = append(events, newEvent(pb.ListingEventType_LISTING_EVENT_TYPE_ENDED, after, now))
+	}
+	return events
+}
+
+// Ended reports whether an auction is over, sold or not, so no more events
+// follow.
+func Ended(listing *pb.Listing) bool {
+	return listing.Status == pb.ListingStatus_LISTING_STATUS_SOLD || listing.Status == pb.ListingStatus_LISTING_STATUS_ENDED
+}
+
+func newEvent(eventType pb.ListingEventType, listing *pb.Listing, now time.Time) *pb.ListingEvent {
+	return &pb.ListingEvent{
+		Type:      eventType,
+		ListingId: listing.Id,
+		Listing:   listing,
+		CreatedAt: timestamppb.New(now),
+	}
+}
@@ -0,0 +1,107 @@
+package events
+
+import (
+	"testing"
+	"time"
+
+	"google.golang.org/protobuf/types/known/timestamppb"
+
+	pb "ebayclone-grpc/proto"
+)
+
+func event(listingID int32) *pb.ListingEvent {
+	return &pb.ListingEvent{Type: pb.ListingEventType_LISTING_EVENT_TYPE_PRICE_CHANGED, ListingId: listingID}
+}
+
+func TestHub(t *testing.T) {
+	hub := NewHub(2)
+	first, second, other := hub.Subscribe(1), hub.Subscribe(1), hub.Subscribe(2)
+
+	hub.Publish(1, event(1))
+	for _, sub := range []*Subscription{first, second} {
+		if got := <-sub.C; got.ListingId != 1 {
+			t.Errorf("Expected event for listing 1, got %v", got)
+		}
+	}
+	if len(other.C) != 0 {
+		t.Errorf("Expected no events for listing 2")
+	}
+
+	// A subscription without room is dropped instead of blocking
+	hub.Publish(1, event(1))
+	<-second.C
+	hub.Publish(1, event(1), event(1))
+	if _, ok := <-second.C; !ok {
+		t.Fatalf("Expected subscription with room to receive events")
+	}
+	<-second.C
+	if _, ok := <-first.C; !ok {
+		t.Fatalf("Expected buffered event before the drop")
+	}
+	if _, ok := <-first.C; ok || !first.Dropped() {
+		t.Errorf("Expected full subscription to be dropped")
+	}
+
+	// Closing the listing ends its subscriptions without dropping them
+	second.Close()
+	second.Close()
+	if hub.Watched(1) {
+		t.Errorf("Expected listing 1 to be unwatched")
+	}
+	hub.CloseListing(2)
+	if _, ok := <-other.C; ok || other.Dropped() {
+		t.Errorf("Expected subscription to end without being dropped")
+	}
+	hub.Publish(2, event(2))
+}
+
+func TestChanges(t *testing.T) {
+	now := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+	before := &pb.Listing{
+		Id:      1,
+		Status:  pb.ListingStatus_LISTING_STATUS_ACTIVE,
+		Price:   &pb.Money{CurrencyCode: "USD", MinorUnits: 1000},
+		EndsAt:  timestamppb.New(now.Add(time.Minute)),
+		Auction: &pb.Auction{},
+	}
+	after := &pb.Listing{
+		Id:      1,
+		Status:  pb.ListingStatus_LISTING_STATUS_ACTIVE,
+		Price:   &pb.Money{CurrencyCode: "USD", MinorUnits: 1100},
+		EndsAt:  timestamppb.New(now.Add(5 * time.Minute)),
+		Auction: &pb.Auction{},
+	}
+	bids := []*pb.Bid{
+		{Id: 1, UserId: 2, Amount: before.Price, MaxAmount: &pb.Money{CurrencyCode: "USD", MinorUnits: 1000}},
+		{Id: 2, UserId: 3, Amount: after.Price, MaxAmount: &pb.Money{CurrencyCode: "USD", MinorUnits: 3000}},
+	}
+
+	got := Changes(before, after, bids, now)
+	want := []pb.ListingEventType{
+		pb.ListingEventType_LISTING_EVENT_TYPE_BID_PLACED,
+		pb.ListingEventType_LISTING_EVENT_TYPE_BID_PLACED,
+		pb.ListingEventType_LISTING_EVENT_TYPE_PRICE_CHANGED,
+		pb.ListingEventType_LISTING_EVENT_TYPE_EXTENDED,
+	}
+	if len(got) != len(want) {
+		t.Fatalf("Expected %d events, got %v", len(want), got)
+	}
+	for i, event := range got {
+		if event.Type != want[i] || event.Listing != after {
+			t.Errorf("Event %d: expected %v with the new listing, got %v", i, want[i], event)
+		}
+	}
+	if got[1].Bid.Id != 2 || got[1].Bid.MaxAmount != nil || bids[1].MaxAmount == nil {
+		t.Errorf("Expected a copy of bid 2 without its maximum, got %v", got[1].Bid)
+	}
+
+	// Selling ends the watch without reporting the end time as an extension
+	sold := &pb.Listing{Id: 1, Status: pb.ListingStatus_LISTING_STATUS_SOLD, Price: after.Price, Auction: &pb.Auction{}}
+	got = Changes(after, sold, nil, now)
+	if len(got) != 1 || got[0].Type != pb.ListingEventType_LISTING_EVENT_TYPE_ENDED {
+		t.Errorf("Expected only an ended event, got %v", got)
+	}
+	if got := Changes(sold, sold, nil, now); len(got) != 0 {
+		t.Errorf("Expected no events without changes, got %v", got)
+	}
+}
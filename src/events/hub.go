@@ -0,0 +1,114 @@
+// Package events fans out changes to listings to the clients watching them.
+// Publishers never wait for watchers: each subscription has a fixed buffer,
+// and one that falls behind is dropped so the watcher can reconnect and
+// start again from a fresh snapshot.
+package events
+
+import (
+	"sync"
+
+	pb "ebayclone-grpc/proto"
+)
+
+// Hub delivers listing events to subscriptions by listing id.
+type Hub struct {
+	mu     sync.Mutex
+	topics map[int32]map[*Subscription]struct{}
+	buffer int
+}
+
+// NewHub creates a hub whose subscriptions hold up to buffer undelivered
+// events.
+func NewHub(buffer int) *Hub {
+	return &Hub{topics: make(map[int32]map[*Subscription]struct{}), buffer: buffer}
+}
+
+// Subscription receives the events of one listing on C until it is closed.
+// C is closed when the subscription ends, whether by Close, by the hub
+// closing the listing or by falling behind.
+type Subscription struct {
+	C <-chan *pb.ListingEvent
+
+	ch        chan *pb.ListingEvent
+	hub       *Hub
+	listingID int32
+	dropped   bool
+}
+
+// Subscribe starts receiving the events of a listing.
+func (h *Hub) Subscribe(listingID int32) *Subscription {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	ch := make(chan *pb.ListingEvent, h.buffer)
+	sub := &Subscription{C: ch, ch: ch, hub: h, listingID: listingID}
+	if h.topics[listingID] == nil {
+		h.topics[listingID] = make(map[*Subscription]struct{})
+	}
+	h.topics[listingID][sub] = struct{}{}
+	return sub
+}
+
+// Watched reports whether anyone is subscribed to a listing, so publishers
+// can skip building events nobody receives.
+func (h *Hub) Watched(listingID int32) bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return len(h.topics[listingID]) > 0
+}
+
+// Publish delivers events to every subscription of a listing without
+// blocking. A subscription without room for all of them is dropped.
+func (h *Hub) Publish(listingID int32, events ...*pb.ListingEvent) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for sub := range h.topics[listingID] {
+		if cap(sub.ch)-len(sub.ch) < len(events) {
+			sub.dropped = true
+			h.remove(sub)
+			continue
+		}
+		for _, event := range events {
+			sub.ch <- event
+		}
+	}
+}
+
+// CloseListing ends every subscription to a listing, e.g. once it is
+// deleted.
+func (h *Hub) CloseListing(listingID int32) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for sub := range h.topics[listingID] {
+		h.remove(sub)
+	}
+}
+
+// remove closes sub and forgets it. Callers must hold the lock.
+func (h *Hub) remove(sub *Subscription) {
+	delete(h.topics[sub.listingID], sub)
+	if len(h.topics[sub.listingID]) == 0 {
+		delete(h.topics, sub.listingID)
+	}
+	close(sub.ch)
+}
+
+// Close stops the subscription. It is safe to call more than once.
+func (s *Subscription) Close() {
+	s.hub.mu.Lock()
+	defer s.hub.mu.Unlock()
+
+	if _, ok := s.hub.topics[s.listingID][s]; ok {
+		s.hub.remove(s)
+	}
+}
+
+// Dropped reports whether the subscription ended because it fell behind.
+// It is only meaningful once C is closed.
+func (s *Subscription) Dropped() bool {
+	s.hub.mu.Lock()
+	defer s.hub.mu.Unlock()
+	return s.dropped
+}
@@ -0,0 +1,217 @@
+package imageproc
+
+import (
+	"bytes"
+	"context"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"testing"
+
+	pb "ebayclone-grpc/proto"
+	"ebayclone-grpc/src/imagestore"
+	"ebayclone-grpc/src/storage"
+)
+
+// withOrientation inserts an EXIF block with the given orientation into a
+// JPEG image, using the byte order of the given TIFF header.
+func withOrientation(data []byte, orientation uint16, bigEndian bool) []byte {
+	exif := []byte("Exif\x00\x00II*\x00\x08\x00\x00\x00\x01\x00\x12\x01\x03\x00\x01\x00\x00\x00")
+	exif = append(exif, byte(orientation), byte(orientation>>8), 0, 0, 0, 0, 0, 0)
+	if bigEndian {
+		exif = []byte("Exif\x00\x00MM\x00*\x00\x00\x00\x08\x00\x01\x01\x12\x00\x03\x00\x00\x00\x01")
+		exif = append(exif, byte(orientation>>8), byte(orientation), 0, 0, 0, 0, 0, 0)
+	}
+	segment := append([]byte{0xff, 0xe1, byte((len(exif) + 2) >> 8), byte(len(exif) + 2)}, exif...)
+	return append(append(append([]byte{}, data[:2]...), segment...), data[2:]...)
+}
+
+func encodeJPEG(t *testing.T, img image.Image) []byte {
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, img, &jpeg.Options{Quality: 100}); err != nil {
+		t.Fatalf("jpeg.Encode failed: %v", err)
+	}
+	return buf.Bytes()
+}
+
+func TestExifOrientation(t *testing.T) {
+	data := encodeJPEG(t, image.NewGray(image.Rect(0, 0, 8, 8)))
+
+	if got := exifOrientation(data); got != 1 {
+		t.Errorf("Expected orientation 1 without EXIF, got %d", got)
+	}
+	if got := exifOrientation(withOrientation(data, 6, false)); got != 6 {
+		t.Errorf("Expected orientation 6 from little-endian EXIF, got %d", got)
+	}
+	if got := exifOrientation(withOrientation(data, 8, true)); got != 8 {
+		t.Errorf("Expected orientation 8 from big-endian EXIF, got %d", got)
+	}
+	if got := exifOrientation(withOrientation(data, 42, false)); got != 1 {
+		t.Errorf("Expected invalid orientation to be ignored, got %d", got)
+	}
+	if got := exifOrientation(data[:3]); got != 1 {
+		t.Errorf("Expected truncated image to be ignored, got %d", got)
+	}
+}
+
+func TestOrient(t *testing.T) {
+	// A 2x1 image with a black pixel on the left and a white one on the right
+	src := image.NewGray(image.Rect(0, 0, 2, 1))
+	src.SetGray(1, 0, color.Gray{Y: 255})
+
+	tests := []struct {
+		orientation int
+		w, h        int
+		whiteX      int
+		whiteY      int
+	}{
+		{orientation: 1, w: 2, h: 1, whiteX: 1, whiteY: 0},
+		{orientation: 2, w: 2, h: 1, whiteX: 0, whiteY: 0},
+		{orientation: 3, w: 2, h: 1, whiteX: 0, whiteY: 0},
+		{orientation: 6, w: 1, h: 2, whiteX: 0, whiteY: 1},
+		{orientation: 8, w: 1, h: 2, whiteX: 0, whiteY: 0},
+	}
+	for _, tt := range tests {
+		img := orient(src, tt.orientation)
+		bounds := img.Bounds()
+		if bounds.Dx() != tt.w || bounds.Dy() != tt.h {
+			t.Errorf("Orientation %d: expected %dx%d, got %dx%d", tt.orientation, tt.w, tt.h, bounds.Dx(), bounds.Dy())
+			continue
+		}
+		if r, _, _, _ := img.At(tt.whiteX, tt.whiteY).RGBA(); r != 0xffff {
+			t.Errorf("Orientation %d: expected white pixel at (%d, %d)", tt.orientation, tt.whiteX, tt.whiteY)
+		}
+	}
+}
+
+func TestRender(t *testing.T) {
+	// Test large photos are scaled down and sideways photos turned upright
+	photo := withOrientation(encodeJPEG(t, image.NewGray(image.Rect(0, 0, 3000, 1000))), 6, false)
+	renditions, err := Render(photo)
+	if err != nil {
+		t.Fatalf("Render failed: %v", err)
+	}
+	want := map[pb.ImageSize][2]int{
+		pb.ImageSize_IMAGE_SIZE_THUMBNAIL: {66, 200},
+		pb.ImageSize_IMAGE_SIZE_MEDIUM:    {266, 800},
+		pb.ImageSize_IMAGE_SIZE_FULL:      {533, 1600},
+	}
+	if len(renditions) != len(want) {
+		t.Fatalf("Expected %d renditions, got %d", len(want), len(renditions))
+	}
+	for _, r := range renditions {
+		if size := want[r.Size]; r.Width != size[0] || r.Height != size[1] {
+			t.Errorf("%v: expected %dx%d, got %dx%d", r.Size, size[0], size[1], r.Width, r.Height)
+		}
+		if r.ContentType != "image/jpeg" || bytes.Contains(r.Data, []byte("Exif")) {
+			t.Errorf("%v: expected JPEG without EXIF", r.Size)
+		}
+	}
+
+	// Test small images are never scaled up and transparency is kept
+	icon := image.NewNRGBA(image.Rect(0, 0, 64, 32))
+	var buf bytes.Buffer
+	png.Encode(&buf, icon)
+	renditions, err = Render(buf.Bytes())
+	if err != nil {
+		t.Fatalf("Render failed: %v", err)
+	}
+	for _, r := range renditions {
+		if r.Width != 64 || r.Height != 32 || r.ContentType != "image/png" {
+			t.Errorf("%v: expected 64x32 PNG, got %dx%d %s", r.Size, r.Width, r.Height, r.ContentType)
+		}
+	}
+
+	// Test images over the pixel limit are rejected
+	defer func(limit int) { maxPixels = limit }(maxPixels)
+	maxPixels = 64*32 - 1
+	if _, err := Render(buf.Bytes()); err == nil {
+		t.Errorf("Expected error for an image over the pixel limit")
+	}
+	maxPixels = 64 * 32
+	if _, err := Render(buf.Bytes()); err != nil {
+		t.Errorf("Render failed at the pixel limit: %v", err)
+	}
+
+	// Test undecodable images
+	if _, err := Render([]byte("\xff\xd8\xff garbage")); err == nil {
+		t.Errorf("Expected error for undecodable image")
+	}
+}
+
+// gatedStore holds up reads until release is closed, so a test can act on
+// an image while the pipeline is working on it.
+type gatedStore struct {
+	imagestore.Store
+	release chan struct{}
+}
+
+func (g *gatedStore) Get(ctx context.Context, id string) (*imagestore.Image, error) {
+	<-g.release
+	return g.Store.Get(ctx, id)
+}
+
+func TestPipeline(t *testing.T) {
+	store := storage.NewInMemoryStorage()
+	images, err := imagestore.NewFileStore(t.TempDir())
+	if err != nil {
+		t.Fatalf("NewFileStore failed: %v", err)
+	}
+	pipeline := NewPipeline(store, images, 2, 1)
+	pipeline.Start()
+	defer pipeline.Close()
+	ctx := context.Background()
+
+	put := func(data []byte) string {
+		id, _ := imagestore.NewID()
+		if err := images.Put(ctx, id, data, ""); err != nil {
+			t.Fatalf("Put failed: %v", err)
+		}
+		if err := pipeline.Submit(ctx, id, 1); err != nil {
+			t.Fatalf("Submit failed: %v", err)
+		}
+		return id
+	}
+
+	// Test images are processing until the workers are done
+	photo := put(encodeJPEG(t, image.NewGray(image.Rect(0, 0, 400, 300))))
+	broken := put([]byte("\xff\xd8\xff garbage"))
+	pipeline.Wait()
+
+	record, err := store.GetImage(photo)
+	if err != nil {
+		t.Fatalf("GetImage failed: %v", err)
+	}
+	if record.Status != pb.ImageStatus_IMAGE_STATUS_READY || len(record.Variants) != len(Sizes) {
+		t.Fatalf("Expected ready image with %d sizes, got %v with %d", len(Sizes), record.Status, len(record.Variants))
+	}
+	if record.OwnerId != 1 {
+		t.Errorf("Expected the image to keep its owner, got %d", record.OwnerId)
+	}
+	for _, variant := range record.Variants {
+		if _, err := images.Get(ctx, variant.ImageId); err != nil {
+			t.Errorf("Expected %v to be stored, got: %v", variant.Size, err)
+		}
+	}
+	if record, _ := store.GetImage(broken); record.Status != pb.ImageStatus_IMAGE_STATUS_FAILED {
+		t.Errorf("Expected undecodable image to fail, got %v", record.Status)
+	}
+
+	// Test images deleted while processing leave no renditions behind
+	gate := &gatedStore{Store: images, release: make(chan struct{})}
+	gated := NewPipeline(store, gate, 1, 1)
+	gated.Start()
+	defer gated.Close()
+	id, _ := imagestore.NewID()
+	images.Put(ctx, id, encodeJPEG(t, image.NewGray(image.Rect(0, 0, 400, 300))), "")
+	if err := gated.Submit(ctx, id, 1); err != nil {
+		t.Fatalf("Submit failed: %v", err)
+	}
+	store.DeleteImage(id)
+	close(gate.release)
+	gated.Wait()
+	if _, err := images.Get(ctx, id); err == nil {
+		t.Errorf("Expected renditions of deleted image to be discarded")
+	}
+}
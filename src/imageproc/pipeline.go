@@ -0,0 +1,146 @@
+package imageproc
+
+import (
+	"context"
+	"log"
+	"sync"
+
+	pb "ebayclone-grpc/proto"
+	"ebayclone-grpc/src/imagestore"
+	"ebayclone-grpc/src/storage"
+)
+
+// Pipeline renders uploaded images in the background on a fixed pool of
+// workers. Each image is tracked in storage as processing until its sizes
+// are written to the image store. The full size replaces the upload under
+// the same id, so the original bytes and their metadata are not kept.
+type Pipeline struct {
+	storage storage.Storage
+	images  imagestore.Store
+	jobs    chan string
+	workers int
+	wg      sync.WaitGroup // Running workers
+	pending sync.WaitGroup // Submitted images not yet processed
+}
+
+// NewPipeline creates a pipeline with the given number of workers and room
+// for queueSize waiting images. Call Start before submitting images.
+func NewPipeline(storage storage.Storage, images imagestore.Store, workers, queueSize int) *Pipeline {
+	return &Pipeline{
+		storage: storage,
+		images:  images,
+		jobs:    make(chan string, queueSize),
+		workers: workers,
+	}
+}
+
+// Start launches the workers.
+func (p *Pipeline) Start() {
+	for i := 0; i < p.workers; i++ {
+		p.wg.Add(1)
+		go func() {
+			defer p.wg.Done()
+			for id := range p.jobs {
+				p.process(id)
+			}
+		}()
+	}
+}
+
+// Close stops accepting images and waits for queued ones to finish. Submit
+// must not be called after Close.
+func (p *Pipeline) Close() {
+	close(p.jobs)
+	p.wg.Wait()
+}
+
+// Wait blocks until every submitted image has been processed.
+func (p *Pipeline) Wait() {
+	p.pending.Wait()
+}
+
+// Submit records a stored image, uploaded by ownerID, as processing and
+// queues it. It blocks while the queue is full, until ctx is done.
+func (p *Pipeline) Submit(ctx context.Context, id string, ownerID int32) error {
+	if err := p.storage.CreateImage(&pb.ListingImage{Id: id, Status: pb.ImageStatus_IMAGE_STATUS_PROCESSING, OwnerId: ownerID}); err != nil {
+		return err
+	}
+
+	p.pending.Add(1)
+	select {
+	case p.jobs <- id:
+		return nil
+	case <-ctx.Done():
+		p.pending.Done()
+		p.storage.DeleteImage(id)
+		return ctx.Err()
+	}
+}
+
+func (p *Pipeline) process(id string) {
+	defer p.pending.Done()
+	ctx := context.Background()
+
+	upload, err := p.images.Get(ctx, id)
+	if err != nil {
+		p.fail(id, err)
+		return
+	}
+	renditions, err := Render(upload.Data)
+	if err != nil {
+		p.fail(id, err)
+		return
+	}
+
+	var variants []*pb.ImageVariant
+	var written []string
+	for _, rendition := range renditions {
+		variantID := id
+		if rendition.Size != pb.ImageSize_IMAGE_SIZE_FULL {
+			if variantID, err = imagestore.NewID(); err != nil {
+				p.discard(written)
+				p.fail(id, err)
+				return
+			}
+		}
+		if err := p.images.Put(ctx, variantID, rendition.Data, rendition.ContentType); err != nil {
+			p.discard(written)
+			p.fail(id, err)
+			return
+		}
+		written = append(written, variantID)
+
+		variants = append(variants, &pb.ImageVariant{
+			Size:        rendition.Size,
+			ImageId:     variantID,
+			Width:       int32(rendition.Width),
+			Height:      int32(rendition.Height),
+			ContentType: rendition.ContentType,
+		})
+	}
+
+	err = p.storage.UpdateImage(&pb.ListingImage{
+		Id:       id,
+		Status:   pb.ImageStatus_IMAGE_STATUS_READY,
+		Variants: variants,
+	})
+	if err != nil {
+		// The image was deleted while it was being processed
+		p.discard(written)
+	}
+}
+
+func (p *Pipeline) fail(id string, cause error) {
+	log.Printf("Failed to process image %s: %v", id, cause)
+	p.storage.UpdateImage(&pb.ListingImage{Id: id, Status: pb.ImageStatus_IMAGE_STATUS_FAILED})
+}
+
+func (p *Pipeline) discard(ids []string) {
+	for _, id := range ids {
+		if err := p.images.Delete(context.Background(), id); err != nil {
+			if _, ok := err.(*imagestore.NotFoundError); !ok {
+				log.Printf("Failed to delete image %s: %v", id, err)
+			}
+		}
+	}
+}
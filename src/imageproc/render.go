@@ -0,0 +1,119 @@
+// Package imageproc renders uploaded listing images into standard sizes.
+// Rendering re-encodes the pixels only, so EXIF (including GPS position) and
+// any other metadata in the upload is dropped.
+package imageproc
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"image/png"
+
+	"golang.org/x/image/draw"
+	_ "golang.org/x/image/webp" // Register the WebP decoder
+
+	pb "ebayclone-grpc/proto"
+)
+
+// Size is a standard rendition, bounded by its long edge in pixels.
+type Size struct {
+	Name    pb.ImageSize
+	MaxEdge int
+}
+
+// Sizes lists the renditions produced for every image, smallest first.
+var Sizes = []Size{
+	{Name: pb.ImageSize_IMAGE_SIZE_THUMBNAIL, MaxEdge: 200},
+	{Name: pb.ImageSize_IMAGE_SIZE_MEDIUM, MaxEdge: 800},
+	{Name: pb.ImageSize_IMAGE_SIZE_FULL, MaxEdge: 1600},
+}
+
+const jpegQuality = 85
+
+// maxPixels bounds the pixel count of an upload. A small compressed file can
+// declare huge dimensions, so they are checked before any pixels are decoded.
+var maxPixels = 40_000_000
+
+// Rendition is an encoded image in one size.
+type Rendition struct {
+	Size        pb.ImageSize
+	Data        []byte
+	ContentType string
+	Width       int
+	Height      int
+}
+
+// Render decodes a JPEG, PNG or WebP image, applies its EXIF orientation and
+// encodes it in every standard size. Images are never scaled up. Images with
+// transparency are encoded as PNG, everything else as JPEG. Images of more
+// than maxPixels pixels are rejected without being decoded.
+func Render(data []byte) ([]Rendition, error) {
+	config, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("decode image: %w", err)
+	}
+	if int64(config.Width)*int64(config.Height) > int64(maxPixels) {
+		return nil, fmt.Errorf("image is %dx%d, over the %d pixel limit", config.Width, config.Height, maxPixels)
+	}
+
+	src, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("decode image: %w", err)
+	}
+	src = orient(src, exifOrientation(data))
+
+	var renditions []Rendition
+	for _, size := range Sizes {
+		img := resize(src, size.MaxEdge)
+
+		var buf bytes.Buffer
+		contentType := "image/jpeg"
+		if opaque(img) {
+			err = jpeg.Encode(&buf, img, &jpeg.Options{Quality: jpegQuality})
+		} else {
+			contentType = "image/png"
+			err = png.Encode(&buf, img)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("encode image: %w", err)
+		}
+
+		bounds := img.Bounds()
+		renditions = append(renditions, Rendition{
+			Size:        size.Name,
+			Data:        buf.Bytes(),
+			ContentType: contentType,
+			Width:       bounds.Dx(),
+			Height:      bounds.Dy(),
+		})
+	}
+	return renditions, nil
+}
+
+// resize scales img down so that its long edge is at most maxEdge.
+func resize(img image.Image, maxEdge int) image.Image {
+	bounds := img.Bounds()
+	w, h := bounds.Dx(), bounds.Dy()
+	if w <= maxEdge && h <= maxEdge {
+		return img
+	}
+
+	if w >= h {
+		h = max(1, h*maxEdge/w)
+		w = maxEdge
+	} else {
+		w = max(1, w*maxEdge/h)
+		h = maxEdge
+	}
+	dst := image.NewRGBA(image.Rect(0, 0, w, h))
+	draw.CatmullRom.Scale(dst, dst.Bounds(), img, bounds, draw.Src, nil)
+	return dst
+}
+
+func opaque(img image.Image) bool {
+	if o, ok := img.(interface{ Opaque() bool }); ok {
+		return o.Opaque()
+	}
+	return false
+}
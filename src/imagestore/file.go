@@ -0,0 +1,77 @@
+package imagestore
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"net/http"
+	"os"
+	"path/filepath"
+)
+
+// FileStore keeps images as files in a local directory. The content type is
+// detected from the stored bytes when reading.
+type FileStore struct {
+	dir string
+}
+
+// NewFileStore creates dir if needed and stores images in it.
+func NewFileStore(dir string) (*FileStore, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	return &FileStore{dir: dir}, nil
+}
+
+func (s *FileStore) Put(ctx context.Context, id string, data []byte, contentType string) error {
+	if err := checkID(id); err != nil {
+		return err
+	}
+
+	// Write to a temporary file first so readers never see a partial image
+	tmp, err := os.CreateTemp(s.dir, id+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), s.path(id))
+}
+
+func (s *FileStore) Get(ctx context.Context, id string) (*Image, error) {
+	if err := checkID(id); err != nil {
+		return nil, err
+	}
+
+	data, err := os.ReadFile(s.path(id))
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil, &NotFoundError{ID: id}
+		}
+		return nil, err
+	}
+	return &Image{ID: id, ContentType: http.DetectContentType(data), Data: data}, nil
+}
+
+func (s *FileStore) Delete(ctx context.Context, id string) error {
+	if err := checkID(id); err != nil {
+		return err
+	}
+
+	err := os.Remove(s.path(id))
+	if errors.Is(err, fs.ErrNotExist) {
+		return &NotFoundError{ID: id}
+	}
+	return err
+}
+
+func (s *FileStore) path(id string) string {
+	return filepath.Join(s.dir, id)
+}
@@ -0,0 +1,59 @@
+// Package imagestore keeps listing image bytes outside of listing records.
+// Listings only hold image ids; clients fetch the bytes separately.
+package imagestore
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+)
+
+// Store saves and loads image bytes by id.
+type Store interface {
+	Put(ctx context.Context, id string, data []byte, contentType string) error
+	Get(ctx context.Context, id string) (*Image, error)
+	Delete(ctx context.Context, id string) error
+}
+
+type Image struct {
+	ID          string
+	ContentType string
+	Data        []byte
+}
+
+type NotFoundError struct {
+	ID string
+}
+
+func (e *NotFoundError) Error() string {
+	return "Image " + e.ID + " not found"
+}
+
+// InvalidIDError is returned for ids that were not produced by NewID. Ids end
+// up in file paths and object keys, so anything else is rejected.
+type InvalidIDError struct {
+	ID string
+}
+
+func (e *InvalidIDError) Error() string {
+	return "Invalid image id: " + e.ID
+}
+
+// NewID returns a random image id.
+func NewID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+func checkID(id string) error {
+	if len(id) != 32 {
+		return &InvalidIDError{ID: id}
+	}
+	if _, err := hex.DecodeString(id); err != nil {
+		return &InvalidIDError{ID: id}
+	}
+	return nil
+}
@@ -1,20 +1,55 @@
 package main
 
 import (
+	"context"
 	"log"
 	"net"
+	"os"
+	"runtime"
+	"time"
 
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/reflection"
 
 	pb "ebayclone-grpc/proto"
+	"ebayclone-grpc/src/imageproc"
+	"ebayclone-grpc/src/imagestore"
+	"ebayclone-grpc/src/money"
+	"ebayclone-grpc/src/scheduler"
 	"ebayclone-grpc/src/services"
 	"ebayclone-grpc/src/storage"
 )
 
 func main() {
+	// Sign session tokens with JWT_SECRET so they survive restarts
+	if secret := os.Getenv("JWT_SECRET"); secret != "" {
+		services.SetJWTSecret([]byte(secret))
+	} else {
+		log.Println("JWT_SECRET is not set; session tokens expire when the server stops")
+	}
+
 	// Initialize storage
 	store := storage.NewInMemoryStorage()
+	images, err := newImageStore()
+	if err != nil {
+		log.Fatalf("Failed to open image store: %v", err)
+	}
+
+	rates, err := newExchangeRates()
+	if err != nil {
+		log.Fatalf("Failed to load exchange rates: %v", err)
+	}
+
+	// Render uploaded images in the background
+	pipeline := imageproc.NewPipeline(store, images, runtime.NumCPU(), 100)
+	pipeline.Start()
+	defer pipeline.Close()
+
+	// Start background jobs (listing expiry, relisting, closing auctions and
+	// expiring offers)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go scheduler.New(store, scheduler.SystemClock{}, 30*time.Second).Run(ctx)
 
 	// Create gRPC server
 	s := grpc.NewServer()
@@ -22,8 +57,13 @@ func main() {
 	// Register services
 	pb.RegisterUserServiceServer(s, services.NewUserService(store))
 	pb.RegisterSessionServiceServer(s, services.NewSessionService(store))
-	pb.RegisterListingServiceServer(s, services.NewListingService(store))
-	pb.RegisterOrderServiceServer(s, services.NewOrderService(store))
+	pb.RegisterListingServiceServer(s, services.NewListingService(store, images, pipeline, rates))
+	pb.RegisterImageServiceServer(s, services.NewImageService(store, images, pipeline))
+	pb.RegisterCategoryServiceServer(s, services.NewCategoryService(store))
+	pb.RegisterOrderServiceServer(s, services.NewOrderService(store, rates))
+	pb.RegisterBiddingServiceServer(s, services.NewBiddingService(store))
+	pb.RegisterOfferServiceServer(s, services.NewOfferService(store))
+	pb.RegisterCartServiceServer(s, services.NewCartService(store))
 
 	// Enable reflection for testing
 	reflection.Register(s)
@@ -39,3 +79,39 @@ func main() {
 		log.Fatalf("Failed to serve: %v", err)
 	}
 }
+
+// newImageStore picks where listing images are kept. Set IMAGE_STORE=s3 and
+// the S3_* variables to use an S3-compatible bucket; otherwise images are
+// written to IMAGE_DIR (default data/images).
+func newImageStore() (imagestore.Store, error) {
+	if os.Getenv("IMAGE_STORE") == "s3" {
+		return imagestore.NewS3Store(imagestore.S3Config{
+			Endpoint:        os.Getenv("S3_ENDPOINT"),
+			Bucket:          os.Getenv("S3_BUCKET"),
+			Region:          os.Getenv("S3_REGION"),
+			AccessKeyID:     os.Getenv("S3_ACCESS_KEY_ID"),
+			SecretAccessKey: os.Getenv("S3_SECRET_ACCESS_KEY"),
+		}, nil), nil
+	}
+
+	dir := os.Getenv("IMAGE_DIR")
+	if dir == "" {
+		dir = "data/images"
+	}
+	return imagestore.NewFileStore(dir)
+}
+
+// newExchangeRates loads the exchange rate table from EXCHANGE_RATES (default
+// config/exchange_rates.json). Without the default file, prices are only
+// compared and paid in their own currency.
+func newExchangeRates() (*money.StaticRates, error) {
+	path := os.Getenv("EXCHANGE_RATES")
+	if path == "" {
+		path = "config/exchange_rates.json"
+		if _, err := os.Stat(path); os.IsNotExist(err) {
+			log.Printf("No exchange rates at %s; currency conversion is disabled", path)
+			return money.NewStaticRates("USD", nil)
+		}
+	}
+	return money.LoadRates(path)
+}
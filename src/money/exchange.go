@@ -0,0 +1,148 @@
+package money
+
+import (
+	"encoding/json"
+	"math/big"
+	"os"
+	"strings"
+
+	pb "ebayclone-grpc/proto"
+)
+
+// rateDigits is the number of decimal places exchange rates are kept to.
+const rateDigits = 10
+
+// RateProvider gives the rate to convert one currency into another: one unit
+// of from is worth rate units of to.
+type RateProvider interface {
+	Rate(from, to string) (*big.Rat, error)
+}
+
+type NoRateError struct {
+	From string
+	To   string
+}
+
+func (e *NoRateError) Error() string {
+	return "No exchange rate from " + e.From + " to " + e.To
+}
+
+type InvalidRateError struct {
+	Currency string
+	Value    string
+}
+
+func (e *InvalidRateError) Error() string {
+	return "Invalid exchange rate for " + e.Currency + ": " + e.Value
+}
+
+// StaticRates is a RateProvider backed by a fixed table of rates against a
+// base currency, for use without a network connection. Rates between two
+// other currencies are derived through the base and rounded to 10 decimal
+// places.
+type StaticRates struct {
+	rates map[string]*big.Rat // Units of each currency per unit of the base
+}
+
+// NewStaticRates creates a table from decimal rates such as "0.92", giving
+// the units of each currency per unit of base.
+func NewStaticRates(base string, rates map[string]string) (*StaticRates, error) {
+	if _, err := Digits(base); err != nil {
+		return nil, err
+	}
+	table := &StaticRates{rates: map[string]*big.Rat{base: big.NewRat(1, 1)}}
+	for currency, value := range rates {
+		if _, err := Digits(currency); err != nil {
+			return nil, err
+		}
+		rate, ok := new(big.Rat).SetString(value)
+		if !ok || rate.Sign() <= 0 {
+			return nil, &InvalidRateError{Currency: currency, Value: value}
+		}
+		table.rates[currency] = rate
+	}
+	return table, nil
+}
+
+// LoadRates reads a table of rates from a JSON file of the form
+// {"base": "USD", "rates": {"EUR": "0.92", "GBP": "0.79"}}.
+func LoadRates(path string) (*StaticRates, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var file struct {
+		Base  string            `json:"base"`
+		Rates map[string]string `json:"rates"`
+	}
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, err
+	}
+	return NewStaticRates(file.Base, file.Rates)
+}
+
+func (r *StaticRates) Rate(from, to string) (*big.Rat, error) {
+	fromRate, ok := r.rates[from]
+	if !ok {
+		return nil, &NoRateError{From: from, To: to}
+	}
+	toRate, ok := r.rates[to]
+	if !ok {
+		return nil, &NoRateError{From: from, To: to}
+	}
+	rate := new(big.Rat).Quo(toRate, fromRate)
+	return roundRat(rate, rateDigits), nil
+}
+
+// Convert returns m in currency to at the given rate, rounded half away from
+// zero to the minor unit of to.
+func Convert(m *pb.Money, to string, rate *big.Rat) (*pb.Money, error) {
+	fromDigits, err := Digits(m.GetCurrencyCode())
+	if err != nil {
+		return nil, err
+	}
+	toDigits, err := Digits(to)
+	if err != nil {
+		return nil, err
+	}
+
+	// minor units of to = minor units of from * rate * 10^(toDigits - fromDigits)
+	amount := new(big.Rat).SetInt64(m.GetMinorUnits())
+	amount.Mul(amount, rate)
+	amount.Mul(amount, new(big.Rat).SetFrac(pow10(toDigits), pow10(fromDigits)))
+	units := roundRat(amount, 0).Num()
+	if !units.IsInt64() {
+		return nil, &OverflowError{}
+	}
+	return New(to, units.Int64()), nil
+}
+
+// FormatRate renders a rate as a decimal, e.g. "0.92".
+func FormatRate(rate *big.Rat) string {
+	s := rate.FloatString(rateDigits)
+	s = strings.TrimRight(s, "0")
+	return strings.TrimSuffix(s, ".")
+}
+
+// roundRat rounds x half away from zero to the given number of decimal
+// places.
+func roundRat(x *big.Rat, digits int) *big.Rat {
+	scale := pow10(digits)
+	scaled := new(big.Rat).Mul(x, new(big.Rat).SetInt(scale))
+	num, den := scaled.Num(), scaled.Denom()
+
+	quo, rem := new(big.Int).QuoRem(num, den, new(big.Int))
+	// Round away from zero when the remainder is at least half
+	if new(big.Int).Mul(new(big.Int).Abs(rem), big.NewInt(2)).Cmp(den) >= 0 {
+		if num.Sign() < 0 {
+			quo.Sub(quo, big.NewInt(1))
+		} else {
+			quo.Add(quo, big.NewInt(1))
+		}
+	}
+	return new(big.Rat).SetFrac(quo, scale)
+}
+
+func pow10(n int) *big.Int {
+	return new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(n)), nil)
+}
@@ -0,0 +1,168 @@
+// Package money does exact arithmetic on amounts of money. Amounts are
+// integer minor units (e.g. cents) of an ISO 4217 currency, so no operation
+// ever goes through floating point, and amounts in different currencies are
+// never mixed.
+package money
+
+import (
+	"math"
+	"strconv"
+	"strings"
+
+	pb "ebayclone-grpc/proto"
+)
+
+// currencies maps the supported ISO 4217 codes to the number of digits of
+// their minor unit.
+var currencies = map[string]int{
+	"AUD": 2,
+	"BRL": 2,
+	"CAD": 2,
+	"CHF": 2,
+	"CNY": 2,
+	"CZK": 2,
+	"DKK": 2,
+	"EUR": 2,
+	"GBP": 2,
+	"HKD": 2,
+	"INR": 2,
+	"JPY": 0,
+	"KRW": 0,
+	"KWD": 3,
+	"MXN": 2,
+	"NOK": 2,
+	"NZD": 2,
+	"PLN": 2,
+	"SEK": 2,
+	"SGD": 2,
+	"USD": 2,
+}
+
+type UnknownCurrencyError struct {
+	Code string
+}
+
+func (e *UnknownCurrencyError) Error() string {
+	if e.Code == "" {
+		return "Currency code is required"
+	}
+	return "Unknown currency: " + e.Code
+}
+
+type CurrencyMismatchError struct {
+	Want string
+	Got  string
+}
+
+func (e *CurrencyMismatchError) Error() string {
+	return "Currency mismatch: expected " + e.Want + ", got " + e.Got
+}
+
+type OverflowError struct{}
+
+func (e *OverflowError) Error() string {
+	return "Amount out of range"
+}
+
+// New returns an amount of minor units of currency.
+func New(currency string, minorUnits int64) *pb.Money {
+	return &pb.Money{CurrencyCode: currency, MinorUnits: minorUnits}
+}
+
+// Validate checks that m is set and in a supported currency.
+func Validate(m *pb.Money) error {
+	if m == nil {
+		return &UnknownCurrencyError{}
+	}
+	if _, ok := currencies[m.CurrencyCode]; !ok {
+		return &UnknownCurrencyError{Code: m.CurrencyCode}
+	}
+	return nil
+}
+
+// Digits returns the number of digits of the minor unit of a currency, e.g.
+// 2 for USD and 0 for JPY.
+func Digits(currency string) (int, error) {
+	digits, ok := currencies[currency]
+	if !ok {
+		return 0, &UnknownCurrencyError{Code: currency}
+	}
+	return digits, nil
+}
+
+// SameCurrency returns a CurrencyMismatchError unless a and b are in the same
+// currency.
+func SameCurrency(a, b *pb.Money) error {
+	if a.GetCurrencyCode() != b.GetCurrencyCode() {
+		return &CurrencyMismatchError{Want: a.GetCurrencyCode(), Got: b.GetCurrencyCode()}
+	}
+	return nil
+}
+
+// Add returns a + b.
+func Add(a, b *pb.Money) (*pb.Money, error) {
+	if err := SameCurrency(a, b); err != nil {
+		return nil, err
+	}
+	sum := a.MinorUnits + b.MinorUnits
+	if (sum > a.MinorUnits) != (b.MinorUnits > 0) {
+		return nil, &OverflowError{}
+	}
+	return New(a.CurrencyCode, sum), nil
+}
+
+// Mul returns m * n.
+func Mul(m *pb.Money, n int64) (*pb.Money, error) {
+	if m.MinorUnits == 0 || n == 0 {
+		return New(m.CurrencyCode, 0), nil
+	}
+	product := m.MinorUnits * n
+	if product/n != m.MinorUnits || (m.MinorUnits == -1 && n == math.MinInt64) || (n == -1 && m.MinorUnits == math.MinInt64) {
+		return nil, &OverflowError{}
+	}
+	return New(m.CurrencyCode, product), nil
+}
+
+// Compare returns -1, 0 or 1 when a is less than, equal to or greater than b.
+func Compare(a, b *pb.Money) (int, error) {
+	if err := SameCurrency(a, b); err != nil {
+		return 0, err
+	}
+	switch {
+	case a.MinorUnits < b.MinorUnits:
+		return -1, nil
+	case a.MinorUnits > b.MinorUnits:
+		return 1, nil
+	}
+	return 0, nil
+}
+
+// IsPositive reports whether m is greater than zero.
+func IsPositive(m *pb.Money) bool {
+	return m.GetMinorUnits() > 0
+}
+
+// Format renders m with its currency code, e.g. "19.99 USD".
+func Format(m *pb.Money) string {
+	digits := currencies[m.GetCurrencyCode()]
+	units := m.GetMinorUnits()
+	sign := ""
+	if units < 0 {
+		sign = "-"
+	}
+	s := strconv.FormatUint(absUint(units), 10)
+	if digits > 0 {
+		if len(s) <= digits {
+			s = strings.Repeat("0", digits-len(s)+1) + s
+		}
+		s = s[:len(s)-digits] + "." + s[len(s)-digits:]
+	}
+	return sign + s + " " + m.GetCurrencyCode()
+}
+
+func absUint(n int64) uint64 {
+	if n < 0 {
+		return uint64(-(n + 1)) + 1
+	}
+	return uint64(n)
+}
@@ -0,0 +1,160 @@
+package money
+
+import (
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+
+	pb "ebayclone-grpc/proto"
+)
+
+func TestValidate(t *testing.T) {
+	if err := Validate(New("USD", 1999)); err != nil {
+		t.Errorf("Expected USD to be valid, got: %v", err)
+	}
+	if _, ok := Validate(New("XYZ", 1)).(*UnknownCurrencyError); !ok {
+		t.Errorf("Expected UnknownCurrencyError for XYZ")
+	}
+	if _, ok := Validate(nil).(*UnknownCurrencyError); !ok {
+		t.Errorf("Expected UnknownCurrencyError for missing amount")
+	}
+	if digits, _ := Digits("JPY"); digits != 0 {
+		t.Errorf("Expected JPY without minor digits, got %d", digits)
+	}
+}
+
+func TestArithmetic(t *testing.T) {
+	// Test sums and products are exact where float64 is not (0.1 * 3)
+	total, err := Mul(New("USD", 10), 3)
+	if err != nil || total.MinorUnits != 30 || total.CurrencyCode != "USD" {
+		t.Errorf("Expected 30 USD cents, got %v (%v)", total, err)
+	}
+	sum, err := Add(New("EUR", 1999), New("EUR", 1))
+	if err != nil || sum.MinorUnits != 2000 {
+		t.Errorf("Expected 2000 EUR cents, got %v (%v)", sum, err)
+	}
+
+	// Test currencies are never mixed
+	if _, err := Add(New("EUR", 1), New("USD", 1)); err == nil {
+		t.Errorf("Expected error for adding EUR and USD")
+	}
+	if _, err := Compare(New("EUR", 1), New("USD", 1)); err == nil {
+		t.Errorf("Expected error for comparing EUR and USD")
+	}
+	if c, _ := Compare(New("USD", 5), New("USD", 7)); c != -1 {
+		t.Errorf("Expected 5 < 7, got %d", c)
+	}
+
+	// Test overflow is reported instead of wrapping around
+	if _, err := Add(New("USD", math.MaxInt64), New("USD", 1)); err == nil {
+		t.Errorf("Expected overflow error for addition")
+	}
+	if _, err := Mul(New("USD", math.MaxInt64/2+1), 2); err == nil {
+		t.Errorf("Expected overflow error for multiplication")
+	}
+}
+
+func TestFormat(t *testing.T) {
+	tests := []struct {
+		currency string
+		units    int64
+		want     string
+	}{
+		{"USD", 1999, "19.99 USD"},
+		{"USD", 5, "0.05 USD"},
+		{"USD", -150, "-1.50 USD"},
+		{"JPY", 1200, "1200 JPY"},
+		{"KWD", 1500, "1.500 KWD"},
+	}
+	for _, tt := range tests {
+		if got := Format(New(tt.currency, tt.units)); got != tt.want {
+			t.Errorf("Format(%d %s): expected %q, got %q", tt.units, tt.currency, tt.want, got)
+		}
+	}
+}
+
+func TestStaticRates(t *testing.T) {
+	rates, err := NewStaticRates("USD", map[string]string{"EUR": "0.92", "GBP": "0.79", "JPY": "151.6"})
+	if err != nil {
+		t.Fatalf("NewStaticRates failed: %v", err)
+	}
+
+	tests := []struct {
+		from, to string
+		want     string
+	}{
+		{"USD", "EUR", "0.92"},
+		{"EUR", "USD", "1.0869565217"}, // 1 / 0.92, rounded to 10 places
+		{"EUR", "GBP", "0.8586956522"},
+		{"USD", "USD", "1"},
+	}
+	for _, tt := range tests {
+		rate, err := rates.Rate(tt.from, tt.to)
+		if err != nil {
+			t.Errorf("Rate(%s, %s) failed: %v", tt.from, tt.to, err)
+			continue
+		}
+		if got := FormatRate(rate); got != tt.want {
+			t.Errorf("Rate(%s, %s): expected %s, got %s", tt.from, tt.to, tt.want, got)
+		}
+	}
+	if _, err := rates.Rate("USD", "CHF"); err == nil {
+		t.Errorf("Expected NoRateError for a currency missing from the table")
+	}
+
+	if _, err := NewStaticRates("USD", map[string]string{"EUR": "-1"}); err == nil {
+		t.Errorf("Expected error for negative rate")
+	}
+	if _, err := NewStaticRates("USD", map[string]string{"XYZ": "1"}); err == nil {
+		t.Errorf("Expected error for unknown currency")
+	}
+}
+
+func TestConvert(t *testing.T) {
+	rates, _ := NewStaticRates("USD", map[string]string{"EUR": "0.92", "JPY": "151.6", "KWD": "0.307"})
+
+	tests := []struct {
+		from  *pb.Money
+		to    string
+		units int64
+	}{
+		{New("USD", 1999), "EUR", 1839},   // 18.3908 EUR
+		{New("USD", 1000), "JPY", 1516},   // No minor unit in JPY
+		{New("JPY", 1516), "USD", 1000},   // 10.00 USD
+		{New("USD", 1), "KWD", 3},         // 0.00307 KWD rounds up to 0.003
+		{New("USD", -1999), "EUR", -1839}, // Rounds away from zero
+	}
+	for _, tt := range tests {
+		rate, err := rates.Rate(tt.from.CurrencyCode, tt.to)
+		if err != nil {
+			t.Fatalf("Rate failed: %v", err)
+		}
+		got, err := Convert(tt.from, tt.to, rate)
+		if err != nil {
+			t.Errorf("Convert(%s) failed: %v", Format(tt.from), err)
+			continue
+		}
+		if got.CurrencyCode != tt.to || got.MinorUnits != tt.units {
+			t.Errorf("Convert(%s, %s): expected %d, got %s", Format(tt.from), tt.to, tt.units, Format(got))
+		}
+	}
+}
+
+func TestLoadRates(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "rates.json")
+	if err := os.WriteFile(path, []byte(`{"base": "EUR", "rates": {"USD": "1.08"}}`), 0o644); err != nil {
+		t.Fatalf("WriteFile failed: %v", err)
+	}
+	rates, err := LoadRates(path)
+	if err != nil {
+		t.Fatalf("LoadRates failed: %v", err)
+	}
+	if rate, err := rates.Rate("EUR", "USD"); err != nil || FormatRate(rate) != "1.08" {
+		t.Errorf("Expected EUR to USD at 1.08, got %v (%v)", rate, err)
+	}
+
+	if _, err := LoadRates(filepath.Join(t.TempDir(), "missing.json")); err == nil {
+		t.Errorf("Expected error for missing file")
+	}
+}
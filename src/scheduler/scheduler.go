@@ -0,0 +1,140 @@
+package scheduler
+
+import (
+	"context"
+	"log"
+	"time"
+
+	pb "ebayclone-grpc/proto"
+	"ebayclone-grpc/src/storage"
+)
+
+// Clock tells the scheduler what time it is so tests can control it.
+type Clock interface {
+	Now() time.Time
+}
+
+// SystemClock reads the wall clock.
+type SystemClock struct{}
+
+func (SystemClock) Now() time.Time {
+	return time.Now()
+}
+
+// Scheduler runs time-based listing jobs in the background.
+type Scheduler struct {
+	storage  storage.Storage
+	clock    Clock
+	interval time.Duration
+}
+
+func New(storage storage.Storage, clock Clock, interval time.Duration) *Scheduler {
+	return &Scheduler{storage: storage, clock: clock, interval: interval}
+}
+
+// Run calls RunOnce every interval until ctx is cancelled.
+func (s *Scheduler) Run(ctx context.Context) {
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			s.RunOnce()
+		}
+	}
+}
+
+// RunOnce processes every job that is due at the clock's current time.
+func (s *Scheduler) RunOnce() {
+	now := s.clock.Now()
+	s.startScheduledListings(now)
+	s.closeAuctions(now)
+	s.expireListings(now)
+	s.expireOffers(now)
+}
+
+// startScheduledListings publishes listings whose scheduled start has passed.
+func (s *Scheduler) startScheduledListings(now time.Time) {
+	listings, err := s.storage.GetListings(storage.ListingFilter{
+		Statuses:     []pb.ListingStatus{pb.ListingStatus_LISTING_STATUS_SCHEDULED},
+		StartsBefore: now,
+	})
+	if err != nil {
+		log.Printf("Failed to get scheduled listings: %v", err)
+		return
+	}
+
+	for _, listing := range listings {
+		if _, err := s.storage.StartScheduledListing(listing.Id, now); err != nil {
+			log.Printf("Failed to start listing %d: %v", listing.Id, err)
+		}
+	}
+}
+
+// closeAuctions ends auctions whose run is over, selling each to its high
+// bidder if the reserve was met.
+func (s *Scheduler) closeAuctions(now time.Time) {
+	listings, err := s.storage.GetListings(storage.ListingFilter{
+		Statuses:   []pb.ListingStatus{pb.ListingStatus_LISTING_STATUS_ACTIVE},
+		Format:     pb.ListingFormat_LISTING_FORMAT_AUCTION,
+		EndsBefore: now,
+	})
+	if err != nil {
+		log.Printf("Failed to get ended auctions: %v", err)
+		return
+	}
+
+	for _, listing := range listings {
+		_, order, err := s.storage.CloseAuction(listing.Id, now)
+		if err != nil {
+			log.Printf("Failed to close auction %d: %v", listing.Id, err)
+			continue
+		}
+		if order != nil {
+			log.Printf("Auction %d sold to user %d (order %d)", listing.Id, order.UserId, order.Id)
+		}
+	}
+}
+
+// expireListings ends or relists listings whose run is over.
+func (s *Scheduler) expireListings(now time.Time) {
+	listings, err := s.storage.GetListings(storage.ListingFilter{
+		Statuses: []pb.ListingStatus{
+			pb.ListingStatus_LISTING_STATUS_ACTIVE,
+			pb.ListingStatus_LISTING_STATUS_PAUSED,
+			pb.ListingStatus_LISTING_STATUS_SOLD,
+		},
+		EndsBefore: now,
+	})
+	if err != nil {
+		log.Printf("Failed to get expired listings: %v", err)
+		return
+	}
+
+	for _, listing := range listings {
+		if _, err := s.storage.ExpireListing(listing.Id, now); err != nil {
+			log.Printf("Failed to expire listing %d: %v", listing.Id, err)
+		}
+	}
+}
+
+// expireOffers closes offers that were not answered in time.
+func (s *Scheduler) expireOffers(now time.Time) {
+	offers, err := s.storage.GetOffers(storage.OfferFilter{
+		Statuses:      []pb.OfferStatus{pb.OfferStatus_OFFER_STATUS_PENDING, pb.OfferStatus_OFFER_STATUS_COUNTERED},
+		ExpiresBefore: now,
+	})
+	if err != nil {
+		log.Printf("Failed to get expired offers: %v", err)
+		return
+	}
+
+	for _, offer := range offers {
+		if _, err := s.storage.ExpireOffer(offer.Id, now); err != nil {
+			log.Printf("Failed to expire offer %d: %v", offer.Id, err)
+		}
+	}
+}
@@ -0,0 +1,324 @@
+package scheduler
+
+import (
+	"testing"
+	"time"
+
+	"google.golang.org/protobuf/types/known/durationpb"
+	"google.golang.org/protobuf/types/known/timestamppb"
+
+	pb "ebayclone-grpc/proto"
+	"ebayclone-grpc/src/money"
+	"ebayclone-grpc/src/storage"
+)
+
+type fakeClock struct {
+	now time.Time
+}
+
+func (c *fakeClock) Now() time.Time {
+	return c.now
+}
+
+func (c *fakeClock) Advance(d time.Duration) {
+	c.now = c.now.Add(d)
+}
+
+func TestSchedulerExpiresListings(t *testing.T) {
+	store := storage.NewInMemoryStorage()
+	start := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+	clock := &fakeClock{now: start}
+	sched := New(store, clock, time.Minute)
+
+	week := 7 * 24 * time.Hour
+	newListing := func(title string, status pb.ListingStatus, autoRelist bool) *pb.Listing {
+		listing := &pb.Listing{
+			Title:      title,
+			Quantity:   1,
+			Status:     status,
+			EndsAt:     timestamppb.New(start.Add(week)),
+			Duration:   durationpb.New(week),
+			AutoRelist: autoRelist,
+		}
+		if err := store.CreateListing(listing); err != nil {
+			t.Fatalf("CreateListing failed: %v", err)
+		}
+		return listing
+	}
+
+	ending := newListing("Ends", pb.ListingStatus_LISTING_STATUS_ACTIVE, false)
+	relisting := newListing("Relists", pb.ListingStatus_LISTING_STATUS_ACTIVE, true)
+	paused := newListing("Paused", pb.ListingStatus_LISTING_STATUS_PAUSED, true)
+	open := &pb.Listing{Title: "Open-ended", Quantity: 1, Status: pb.ListingStatus_LISTING_STATUS_ACTIVE}
+	if err := store.CreateListing(open); err != nil {
+		t.Fatalf("CreateListing failed: %v", err)
+	}
+
+	get := func(id int32) *pb.Listing {
+		listing, err := store.GetListing(id)
+		if err != nil {
+			t.Fatalf("GetListing failed: %v", err)
+		}
+		return listing
+	}
+
+	// Nothing is due before the end time
+	clock.Advance(week - time.Second)
+	sched.RunOnce()
+	if get(ending.Id).Status != pb.ListingStatus_LISTING_STATUS_ACTIVE {
+		t.Errorf("Expected listing to stay active before its end time")
+	}
+
+	// At the end time listings end or relist
+	clock.Advance(time.Second)
+	sched.RunOnce()
+	if got := get(ending.Id).Status; got != pb.ListingStatus_LISTING_STATUS_ENDED {
+		t.Errorf("Expected listing to end, got %v", got)
+	}
+	if got := get(paused.Id).Status; got != pb.ListingStatus_LISTING_STATUS_ENDED {
+		t.Errorf("Expected paused listing to end instead of relisting, got %v", got)
+	}
+	relisted := get(relisting.Id)
+	if relisted.Status != pb.ListingStatus_LISTING_STATUS_ACTIVE || relisted.RelistCount != 1 {
+		t.Errorf("Expected listing to be relisted once, got status %v count %d", relisted.Status, relisted.RelistCount)
+	}
+	if !relisted.EndsAt.AsTime().Equal(clock.Now().Add(week)) {
+		t.Errorf("Expected relisted run to end a week from now, got %v", relisted.EndsAt.AsTime())
+	}
+	if got := get(open.Id).Status; got != pb.ListingStatus_LISTING_STATUS_ACTIVE {
+		t.Errorf("Expected listing without end time to stay active, got %v", got)
+	}
+
+	// Running again at the same time is a no-op
+	sched.RunOnce()
+	if got := get(relisting.Id).RelistCount; got != 1 {
+		t.Errorf("Expected relist count to stay 1, got %d", got)
+	}
+
+	// A relisting listing that sold out ends at the end of its run
+	if _, err := store.SetListingQuantity(relisting.Id, 0); err != nil {
+		t.Fatalf("SetListingQuantity failed: %v", err)
+	}
+	clock.Advance(week)
+	sched.RunOnce()
+	if got := get(relisting.Id).Status; got != pb.ListingStatus_LISTING_STATUS_ENDED {
+		t.Errorf("Expected sold out listing to end, got %v", got)
+	}
+}
+
+func TestSchedulerStartsScheduledListings(t *testing.T) {
+	store := storage.NewInMemoryStorage()
+	start := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+	clock := &fakeClock{now: start}
+	sched := New(store, clock, time.Minute)
+
+	listing := &pb.Listing{
+		Title:          "Launch day sneakers",
+		Quantity:       1,
+		Status:         pb.ListingStatus_LISTING_STATUS_SCHEDULED,
+		ScheduledStart: timestamppb.New(start.Add(time.Hour)),
+		Duration:       durationpb.New(24 * time.Hour),
+	}
+	if err := store.CreateListing(listing); err != nil {
+		t.Fatalf("CreateListing failed: %v", err)
+	}
+
+	sched.RunOnce()
+	got, _ := store.GetListing(listing.Id)
+	if got.Status != pb.ListingStatus_LISTING_STATUS_SCHEDULED {
+		t.Errorf("Expected listing to stay scheduled before its start, got %v", got.Status)
+	}
+
+	// The run starts when the listing goes live, not when it was created
+	clock.Advance(90 * time.Minute)
+	sched.RunOnce()
+	got, _ = store.GetListing(listing.Id)
+	if got.Status != pb.ListingStatus_LISTING_STATUS_ACTIVE {
+		t.Fatalf("Expected listing to go live, got %v", got.Status)
+	}
+	if !got.EndsAt.AsTime().Equal(clock.Now().Add(24 * time.Hour)) {
+		t.Errorf("Expected run to end 24h after going live, got %v", got.EndsAt.AsTime())
+	}
+
+	clock.Advance(24 * time.Hour)
+	sched.RunOnce()
+	got, _ = store.GetListing(listing.Id)
+	if got.Status != pb.ListingStatus_LISTING_STATUS_ENDED {
+		t.Errorf("Expected listing to end after its run, got %v", got.Status)
+	}
+}
+
+func TestSchedulerClosesAuctions(t *testing.T) {
+	store := storage.NewInMemoryStorage()
+	start := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+	clock := &fakeClock{now: start}
+	sched := New(store, clock, time.Minute)
+
+	newAuction := func(title string, reserve int64) *pb.Listing {
+		listing := &pb.Listing{
+			Title:    title,
+			Quantity: 1,
+			Status:   pb.ListingStatus_LISTING_STATUS_ACTIVE,
+			EndsAt:   timestamppb.New(start.Add(time.Hour)),
+			Price:    money.New("USD", 1000),
+			Format:   pb.ListingFormat_LISTING_FORMAT_AUCTION,
+			Auction:  &pb.Auction{StartPrice: money.New("USD", 1000), ReservePrice: money.New("USD", reserve)},
+			UserId:   1,
+		}
+		if err := store.CreateListing(listing); err != nil {
+			t.Fatalf("CreateListing failed: %v", err)
+		}
+		return listing
+	}
+	bid := func(listingID, userID int32, amount int64) {
+		address := &pb.Address{Street: "1 Main St", City: "Springfield", Country: "USA"}
+		if _, err := store.PlaceBid(&pb.Bid{ListingId: listingID, UserId: userID, Amount: money.New("USD", amount)}, address, clock.Now()); err != nil {
+			t.Fatalf("PlaceBid failed: %v", err)
+		}
+	}
+
+	sold := newAuction("Sold", 1500)
+	bid(sold.Id, 2, 1000)
+	bid(sold.Id, 3, 1500)
+	unmet := newAuction("Reserve not met", 5000)
+	bid(unmet.Id, 2, 1000)
+	unbid := newAuction("No bids", 1000)
+
+	// Bids at the end time are too late even before the auction is closed
+	clock.Advance(time.Hour)
+	if _, err := store.PlaceBid(&pb.Bid{ListingId: unbid.Id, UserId: 2, Amount: money.New("USD", 1000)}, nil, clock.Now()); err == nil {
+		t.Errorf("Expected error for a bid after the end time")
+	}
+
+	sched.RunOnce()
+	got, _ := store.GetListing(sold.Id)
+	if got.Status != pb.ListingStatus_LISTING_STATUS_SOLD || got.Auction.OrderId == 0 {
+		t.Fatalf("Expected auction to sell, got status %v order %d", got.Status, got.Auction.OrderId)
+	}
+	order, err := store.GetOrder(got.Auction.OrderId)
+	if err != nil {
+		t.Fatalf("GetOrder failed: %v", err)
+	}
+	if order.UserId != 3 || order.TotalPrice.MinorUnits != 1500 || order.ShippingAddress.GetCity() != "Springfield" {
+		t.Errorf("Expected order for user 3 at 15.00 USD, got %v", order)
+	}
+	if history := order.StatusHistory; len(history) != 1 || history[0].Actor != pb.OrderActor_ORDER_ACTOR_SYSTEM || history[0].UserId != 0 {
+		t.Errorf("Expected the order to be placed by the system, got %v", history)
+	}
+
+	for _, id := range []int32{unmet.Id, unbid.Id} {
+		got, _ := store.GetListing(id)
+		if got.Status != pb.ListingStatus_LISTING_STATUS_ENDED || got.Auction.OrderId != 0 {
+			t.Errorf("Expected auction %d to end without a sale, got status %v order %d", id, got.Status, got.Auction.OrderId)
+		}
+	}
+
+	// Closing again creates no second order
+	sched.RunOnce()
+	if _, total, _ := store.GetOrders(storage.OrderFilter{}, 1, 10); total != 1 {
+		t.Errorf("Expected 1 order, got %d", total)
+	}
+
+	// A cancelled sale ends the auction unsold rather than selling again
+	if _, err := store.TransitionOrder(order.Id, pb.OrderStatus_ORDER_STATUS_CANCELLED, pb.OrderActor_ORDER_ACTOR_BUYER, 3, "", clock.Now()); err != nil {
+		t.Fatalf("TransitionOrder failed: %v", err)
+	}
+	sched.RunOnce()
+	got, _ = store.GetListing(sold.Id)
+	if got.Status != pb.ListingStatus_LISTING_STATUS_ENDED || got.Auction.HighBidderId != 0 || got.Auction.OrderId != 0 {
+		t.Errorf("Expected cancelled auction to end without a winner, got status %v bidder %d order %d", got.Status, got.Auction.HighBidderId, got.Auction.OrderId)
+	}
+	if _, total, _ := store.GetOrders(storage.OrderFilter{}, 1, 10); total != 1 {
+		t.Errorf("Expected 1 order, got %d", total)
+	}
+}
+
+func TestSchedulerClosesExtendedAuctions(t *testing.T) {
+	store := storage.NewInMemoryStorage()
+	start := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+	clock := &fakeClock{now: start}
+	sched := New(store, clock, time.Minute)
+
+	listing := &pb.Listing{
+		Title:    "Extends",
+		Quantity: 1,
+		Status:   pb.ListingStatus_LISTING_STATUS_ACTIVE,
+		EndsAt:   timestamppb.New(start.Add(time.Hour)),
+		Price:    money.New("USD", 1000),
+		Format:   pb.ListingFormat_LISTING_FORMAT_AUCTION,
+		Auction:  &pb.Auction{StartPrice: money.New("USD", 1000), ExtensionWindow: durationpb.New(5 * time.Minute)},
+		UserId:   1,
+	}
+	if err := store.CreateListing(listing); err != nil {
+		t.Fatalf("CreateListing failed: %v", err)
+	}
+
+	// A bid a minute before the end pushes it to five minutes after the bid
+	clock.Advance(59 * time.Minute)
+	bid := &pb.Bid{ListingId: listing.Id, UserId: 2, Amount: money.New("USD", 1000)}
+	got, err := store.PlaceBid(bid, &pb.Address{Street: "1 Main St", City: "Springfield", Country: "USA"}, clock.Now())
+	if err != nil {
+		t.Fatalf("PlaceBid failed: %v", err)
+	}
+	if !got.EndsAt.AsTime().Equal(clock.Now().Add(5*time.Minute)) || got.Auction.ExtensionCount != 1 {
+		t.Errorf("Expected end 5 minutes after the bid, got %v", got.EndsAt.AsTime())
+	}
+
+	// The original end time no longer closes the auction
+	clock.Advance(time.Minute)
+	sched.RunOnce()
+	if got, _ := store.GetListing(listing.Id); got.Status != pb.ListingStatus_LISTING_STATUS_ACTIVE {
+		t.Errorf("Expected auction to stay active past its original end, got %v", got.Status)
+	}
+
+	// The high bidder raising their maximum changes nothing others see, so
+	// it does not extend the auction
+	raise := &pb.Bid{ListingId: listing.Id, UserId: 2, MaxAmount: money.New("USD", 5000)}
+	if got, err = store.PlaceBid(raise, nil, clock.Now()); err != nil {
+		t.Fatalf("PlaceBid failed: %v", err)
+	}
+	if !got.EndsAt.AsTime().Equal(start.Add(64*time.Minute)) || got.Auction.ExtensionCount != 1 {
+		t.Errorf("Expected the end to stay 4 minutes away, got %v after %d extensions", got.EndsAt.AsTime(), got.Auction.ExtensionCount)
+	}
+
+	clock.Advance(4 * time.Minute)
+	sched.RunOnce()
+	if got, _ := store.GetListing(listing.Id); got.Status != pb.ListingStatus_LISTING_STATUS_SOLD {
+		t.Errorf("Expected auction to sell at its extended end, got %v", got.Status)
+	}
+}
+
+func TestSchedulerExpiresOffers(t *testing.T) {
+	store := storage.NewInMemoryStorage()
+	start := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+	clock := &fakeClock{now: start}
+	sched := New(store, clock, time.Minute)
+
+	listing := &pb.Listing{Title: "Desk", Quantity: 1, Status: pb.ListingStatus_LISTING_STATUS_ACTIVE, Price: money.New("USD", 10000), UserId: 1}
+	if err := store.CreateListing(listing); err != nil {
+		t.Fatalf("CreateListing failed: %v", err)
+	}
+	if _, err := store.SetBestOffer(listing.Id, &pb.BestOffer{}); err != nil {
+		t.Fatalf("SetBestOffer failed: %v", err)
+	}
+	offer := &pb.Offer{ListingId: listing.Id, BuyerId: 2, Quantity: 1, Amount: money.New("USD", 8000), ExpiresAt: timestamppb.New(start.Add(time.Hour))}
+	if _, err := store.CreateOffer(offer, start); err != nil {
+		t.Fatalf("CreateOffer failed: %v", err)
+	}
+
+	clock.Advance(time.Hour - time.Second)
+	sched.RunOnce()
+	if got, _ := store.GetOffer(offer.Id); got.Status != pb.OfferStatus_OFFER_STATUS_PENDING {
+		t.Errorf("Expected offer to stay pending before it expires, got %v", got.Status)
+	}
+
+	// Offers past their expiry cannot be accepted even before they expire
+	clock.Advance(time.Second)
+	if _, _, err := store.AcceptOffer(offer.Id, 1, clock.Now()); err == nil {
+		t.Errorf("Expected error for accepting an expired offer")
+	}
+	sched.RunOnce()
+	if got, _ := store.GetOffer(offer.Id); got.Status != pb.OfferStatus_OFFER_STATUS_EXPIRED {
+		t.Errorf("Expected offer to expire, got %v", got.Status)
+	}
+}
@@ -0,0 +1,131 @@
+package services
+
+import (
+	"fmt"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	pb "ebayclone-grpc/proto"
+	"ebayclone-grpc/src/storage"
+)
+
+// validateAttributeSchemas checks the attribute declarations of a category.
+func validateAttributeSchemas(schemas []*pb.AttributeSchema) error {
+	seen := make(map[string]bool)
+	for _, schema := range schemas {
+		if schema.Name == "" {
+			return status.Error(codes.InvalidArgument, "Attribute name is required")
+		}
+		if seen[schema.Name] {
+			return status.Error(codes.InvalidArgument, fmt.Sprintf("Duplicate attribute %q", schema.Name))
+		}
+		seen[schema.Name] = true
+
+		switch schema.Type {
+		case pb.AttributeType_ATTRIBUTE_TYPE_STRING:
+		case pb.AttributeType_ATTRIBUTE_TYPE_NUMBER, pb.AttributeType_ATTRIBUTE_TYPE_BOOLEAN:
+			if len(schema.AllowedValues) > 0 {
+				return status.Error(codes.InvalidArgument, fmt.Sprintf("Attribute %q: allowed values are only supported for string attributes", schema.Name))
+			}
+		default:
+			return status.Error(codes.InvalidArgument, fmt.Sprintf("Attribute %q has an invalid type", schema.Name))
+		}
+	}
+	return nil
+}
+
+// categoryAttributes returns the effective attribute schema of a category:
+// its own attributes plus those inherited from its ancestors. The closest
+// declaration wins when names collide.
+func categoryAttributes(store storage.Storage, slug string) (map[string]*pb.AttributeSchema, error) {
+	category, err := store.GetCategoryBySlug(slug)
+	if err != nil {
+		if _, ok := err.(*storage.NotFoundError); ok {
+			return nil, status.Error(codes.InvalidArgument, "Unknown category: "+slug)
+		}
+		return nil, status.Error(codes.Internal, "Failed to get category")
+	}
+
+	schemas := make(map[string]*pb.AttributeSchema)
+	for {
+		for _, schema := range category.Attributes {
+			if _, exists := schemas[schema.Name]; !exists {
+				schemas[schema.Name] = schema
+			}
+		}
+		if category.ParentId == 0 {
+			return schemas, nil
+		}
+		category, err = store.GetCategory(category.ParentId)
+		if err != nil {
+			return nil, status.Error(codes.Internal, "Failed to get category")
+		}
+	}
+}
+
+// validateAttributes checks listing attribute values against the schema of
+// the listing's category.
+func validateAttributes(schemas map[string]*pb.AttributeSchema, values []*pb.AttributeValue) error {
+	seen := make(map[string]bool)
+	for _, value := range values {
+		schema, exists := schemas[value.Name]
+		if !exists {
+			return status.Error(codes.InvalidArgument, fmt.Sprintf("Unknown attribute %q for this category", value.Name))
+		}
+		if seen[value.Name] {
+			return status.Error(codes.InvalidArgument, fmt.Sprintf("Duplicate attribute %q", value.Name))
+		}
+		seen[value.Name] = true
+
+		switch v := value.Value.(type) {
+		case *pb.AttributeValue_StringValue:
+			if schema.Type != pb.AttributeType_ATTRIBUTE_TYPE_STRING {
+				return attributeTypeError(schema)
+			}
+			if len(schema.AllowedValues) > 0 && !containsString(schema.AllowedValues, v.StringValue) {
+				return status.Error(codes.InvalidArgument, fmt.Sprintf("Attribute %q must be one of %v", schema.Name, schema.AllowedValues))
+			}
+		case *pb.AttributeValue_NumberValue:
+			if schema.Type != pb.AttributeType_ATTRIBUTE_TYPE_NUMBER {
+				return attributeTypeError(schema)
+			}
+		case *pb.AttributeValue_BoolValue:
+			if schema.Type != pb.AttributeType_ATTRIBUTE_TYPE_BOOLEAN {
+				return attributeTypeError(schema)
+			}
+		default:
+			return status.Error(codes.InvalidArgument, fmt.Sprintf("Attribute %q has no value", schema.Name))
+		}
+	}
+
+	for name, schema := range schemas {
+		if schema.Required && !seen[name] {
+			return status.Error(codes.InvalidArgument, fmt.Sprintf("Attribute %q is required for this category", name))
+		}
+	}
+	return nil
+}
+
+func attributeTypeError(schema *pb.AttributeSchema) error {
+	return status.Error(codes.InvalidArgument, fmt.Sprintf("Attribute %q must be a %s", schema.Name, attributeTypeName(schema.Type)))
+}
+
+func attributeTypeName(t pb.AttributeType) string {
+	switch t {
+	case pb.AttributeType_ATTRIBUTE_TYPE_NUMBER:
+		return "number"
+	case pb.AttributeType_ATTRIBUTE_TYPE_BOOLEAN:
+		return "boolean"
+	}
+	return "string"
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,243 @@
+package services
+
+import (
+	"context"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+
+	pb "ebayclone-grpc/proto"
+	"ebayclone-grpc/src/auction"
+	"ebayclone-grpc/src/events"
+	"ebayclone-grpc/src/money"
+	"ebayclone-grpc/src/storage"
+)
+
+type BiddingService struct {
+	pb.UnimplementedBiddingServiceServer
+	storage storage.Storage
+}
+
+func NewBiddingService(storage storage.Storage) *BiddingService {
+	return &BiddingService{storage: storage}
+}
+
+func (s *BiddingService) PlaceBid(ctx context.Context, req *pb.PlaceBidRequest) (*pb.PlaceBidResponse, error) {
+	// Validate required fields
+	if req.ListingId <= 0 || (req.Amount == nil && req.MaxAmount == nil) || req.ShippingAddress == nil {
+		return nil, status.Error(codes.InvalidArgument, "ListingId, amount or max amount, and shipping address are required")
+	}
+	for _, amount := range []*pb.Money{req.Amount, req.MaxAmount} {
+		if amount == nil {
+			continue
+		}
+		if err := money.Validate(amount); err != nil {
+			return nil, status.Error(codes.InvalidArgument, err.Error())
+		}
+		if !money.IsPositive(amount) {
+			return nil, status.Error(codes.InvalidArgument, "Amount must be positive")
+		}
+	}
+	if req.Amount != nil && req.MaxAmount != nil {
+		c, err := money.Compare(req.MaxAmount, req.Amount)
+		if err != nil {
+			return nil, status.Error(codes.InvalidArgument, err.Error())
+		}
+		if c < 0 {
+			return nil, status.Error(codes.InvalidArgument, "Max amount must not be below the amount")
+		}
+	}
+	addr := req.ShippingAddress
+	if addr.Street == "" || addr.City == "" || addr.Country == "" {
+		return nil, status.Error(codes.InvalidArgument, "Street, city, and country are required in shipping address")
+	}
+
+	userID, err := getUserIDFromContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	bid := &pb.Bid{
+		ListingId: req.ListingId,
+		UserId:    userID,
+		Amount:    req.Amount,
+		MaxAmount: req.MaxAmount,
+	}
+	listing, err := s.storage.PlaceBid(bid, req.ShippingAddress, time.Now())
+	if err != nil {
+		switch err.(type) {
+		case *storage.NotFoundError:
+			return nil, status.Error(codes.NotFound, "Listing not found")
+		case *storage.SelfBidError:
+			return nil, status.Error(codes.PermissionDenied, err.Error())
+		case *storage.NotAuctionError, *storage.ListingNotActiveError, *storage.AuctionEndedError, *auction.BidTooLowError:
+			return nil, status.Error(codes.FailedPrecondition, err.Error())
+		case *money.CurrencyMismatchError:
+			return nil, status.Error(codes.InvalidArgument, err.Error())
+		}
+		return nil, status.Error(codes.Internal, "Failed to place bid")
+	}
+
+	return &pb.PlaceBidResponse{Bid: bid, Listing: listing}, nil
+}
+
+func (s *BiddingService) BuyItNow(ctx context.Context, req *pb.BuyItNowRequest) (*pb.Order, error) {
+	// Validate required fields
+	if req.ListingId <= 0 || req.ShippingAddress == nil {
+		return nil, status.Error(codes.InvalidArgument, "ListingId and shipping address are required")
+	}
+	addr := req.ShippingAddress
+	if addr.Street == "" || addr.City == "" || addr.Country == "" {
+		return nil, status.Error(codes.InvalidArgument, "Street, city, and country are required in shipping address")
+	}
+
+	userID, err := getUserIDFromContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	_, order, err := s.storage.BuyItNow(req.ListingId, userID, req.ShippingAddress, time.Now())
+	if err != nil {
+		switch err.(type) {
+		case *storage.NotFoundError:
+			return nil, status.Error(codes.NotFound, "Listing not found")
+		case *storage.SelfBidError:
+			return nil, status.Error(codes.PermissionDenied, err.Error())
+		case *storage.NotAuctionError, *storage.ListingNotActiveError, *storage.AuctionEndedError, *storage.BuyItNowUnavailableError:
+			return nil, status.Error(codes.FailedPrecondition, err.Error())
+		}
+		return nil, status.Error(codes.Internal, "Failed to buy listing")
+	}
+
+	return order, nil
+}
+
+func (s *BiddingService) GetBids(ctx context.Context, req *pb.GetBidsRequest) (*pb.GetBidsResponse, error) {
+	bids, err := s.storage.GetBids(req.ListingId)
+	if err != nil {
+		if _, ok := err.(*storage.NotFoundError); ok {
+			return nil, status.Error(codes.NotFound, "Listing not found")
+		}
+		return nil, status.Error(codes.Internal, "Failed to get bids")
+	}
+
+	// Maximums are secret, or proxies could be bid up to them exactly.
+	// Anonymous visitors see none.
+	userID, _ := getUserIDFromContext(ctx)
+	for i, bid := range bids {
+		if bid.UserId != userID {
+			bids[i] = proto.Clone(bid).(*pb.Bid)
+			bids[i].MaxAmount = nil
+		}
+	}
+	return &pb.GetBidsResponse{Bids: bids}, nil
+}
+
+// WatchListing streams the changes of an auction, starting with a snapshot,
+// until it ends or the client goes away. Watchers that fall behind are cut
+// off with ResourceExhausted and should reconnect.
+func (s *BiddingService) WatchListing(req *pb.WatchListingRequest, stream pb.BiddingService_WatchListingServer) error {
+	listing, sub, err := s.storage.WatchListing(req.ListingId)
+	if err != nil {
+		if _, ok := err.(*storage.NotFoundError); ok {
+			return status.Error(codes.NotFound, "Listing not found")
+		}
+		return status.Error(codes.Internal, "Failed to watch listing")
+	}
+	defer sub.Close()
+
+	if listing.Auction == nil {
+		return status.Error(codes.FailedPrecondition, "Listing is not an auction")
+	}
+	if err := stream.Send(events.Snapshot(listing, time.Now())); err != nil {
+		return err
+	}
+	if events.Ended(listing) {
+		return nil
+	}
+
+	for {
+		select {
+		case <-stream.Context().Done():
+			return status.FromContextError(stream.Context().Err()).Err()
+		case event, ok := <-sub.C:
+			if !ok {
+				if sub.Dropped() {
+					return status.Error(codes.ResourceExhausted, "Too far behind on listing events; watch again to resume")
+				}
+				return status.Error(codes.NotFound, "Listing was deleted")
+			}
+			if err := stream.Send(event); err != nil {
+				return err
+			}
+			if event.Type == pb.ListingEventType_LISTING_EVENT_TYPE_ENDED {
+				return nil
+			}
+		}
+	}
+}
+
+// newAuction validates the auction terms of a new listing and returns its
+// initial bidding state.
+func newAuction(req *pb.ListingCreate) (*pb.Auction, error) {
+	terms := req.Auction
+	if terms == nil || terms.StartPrice == nil {
+		return nil, status.Error(codes.InvalidArgument, "Auctions require a start price")
+	}
+	if req.Price != nil || req.Quantity > 1 || len(req.Variations) > 0 {
+		return nil, status.Error(codes.InvalidArgument, "Auctions are priced by their start price and sell a single item")
+	}
+	if req.Duration == nil && req.EndsAt == nil {
+		return nil, status.Error(codes.InvalidArgument, "Auctions require a duration or ends_at")
+	}
+	if req.AutoRelist {
+		return nil, status.Error(codes.InvalidArgument, "Auctions cannot auto-relist")
+	}
+	if err := validatePrice(terms.StartPrice); err != nil {
+		return nil, err
+	}
+
+	if terms.ReservePrice != nil {
+		if err := money.SameCurrency(terms.StartPrice, terms.ReservePrice); err != nil {
+			return nil, status.Error(codes.InvalidArgument, err.Error())
+		}
+		if c, _ := money.Compare(terms.ReservePrice, terms.StartPrice); c < 0 {
+			return nil, status.Error(codes.InvalidArgument, "Reserve price must not be below the start price")
+		}
+	}
+
+	if terms.ExtensionWindow != nil {
+		if err := terms.ExtensionWindow.CheckValid(); err != nil || terms.ExtensionWindow.AsDuration() <= 0 {
+			return nil, status.Error(codes.InvalidArgument, "Extension window must be positive")
+		}
+	}
+
+	// Buy It Now must be worth more than bidding could open at
+	if terms.BuyItNowPrice != nil {
+		if err := validatePrice(terms.BuyItNowPrice); err != nil {
+			return nil, err
+		}
+		if err := money.SameCurrency(terms.StartPrice, terms.BuyItNowPrice); err != nil {
+			return nil, status.Error(codes.InvalidArgument, err.Error())
+		}
+		if c, _ := money.Compare(terms.BuyItNowPrice, terms.StartPrice); c <= 0 {
+			return nil, status.Error(codes.InvalidArgument, "Buy It Now price must be above the start price")
+		}
+		if terms.ReservePrice != nil {
+			if c, _ := money.Compare(terms.BuyItNowPrice, terms.ReservePrice); c < 0 {
+				return nil, status.Error(codes.InvalidArgument, "Buy It Now price must not be below the reserve price")
+			}
+		}
+	}
+
+	state := &pb.Auction{
+		StartPrice:      terms.StartPrice,
+		ReservePrice:    terms.ReservePrice,
+		MinimumBid:      terms.StartPrice,
+		ExtensionWindow: terms.ExtensionWindow,
+		BuyItNowPrice:   terms.BuyItNowPrice,
+	}
+	state.ReserveMet = auction.ReserveMet(state, terms.StartPrice)
+	state.BuyItNowAvailable = auction.BuyItNowAvailable(state)
+	return state, nil
+}
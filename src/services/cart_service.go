@@ -0,0 +1,203 @@
+package services
+
+import (
+	"context"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/emptypb"
+
+	pb "ebayclone-grpc/proto"
+	"ebayclone-grpc/src/money"
+	"ebayclone-grpc/src/storage"
+)
+
+type CartService struct {
+	pb.UnimplementedCartServiceServer
+	storage storage.Storage
+}
+
+func NewCartService(storage storage.Storage) *CartService {
+	return &CartService{storage: storage}
+}
+
+func (s *CartService) GetCart(ctx context.Context, req *emptypb.Empty) (*pb.Cart, error) {
+	userID, err := getUserIDFromContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	items, err := s.storage.GetCart(userID)
+	if err != nil {
+		return nil, status.Error(codes.Internal, "Failed to get cart")
+	}
+	return s.cart(userID, items)
+}
+
+func (s *CartService) AddCartItem(ctx context.Context, req *pb.AddCartItemRequest) (*pb.Cart, error) {
+	if req.ListingId <= 0 {
+		return nil, status.Error(codes.InvalidArgument, "ListingId is required")
+	}
+	if req.Quantity < 0 {
+		return nil, status.Error(codes.InvalidArgument, "Quantity must not be negative")
+	}
+	quantity := req.Quantity
+	if quantity == 0 {
+		quantity = 1
+	}
+
+	userID, err := getUserIDFromContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	item := &pb.CartItem{ListingId: req.ListingId, VariationId: req.VariationId, Quantity: quantity}
+	items, err := s.storage.AddCartItem(userID, item, time.Now())
+	if err != nil {
+		switch err.(type) {
+		case *storage.NotFoundError:
+			return nil, status.Error(codes.NotFound, err.Error())
+		case *storage.AuctionListingError:
+			return nil, status.Error(codes.FailedPrecondition, err.Error())
+		case *storage.VariationRequiredError:
+			return nil, status.Error(codes.InvalidArgument, err.Error())
+		}
+		return nil, status.Error(codes.Internal, "Failed to add cart item")
+	}
+	return s.cart(userID, items)
+}
+
+func (s *CartService) UpdateCartItem(ctx context.Context, req *pb.UpdateCartItemRequest) (*pb.Cart, error) {
+	if req.Quantity < 0 {
+		return nil, status.Error(codes.InvalidArgument, "Quantity must not be negative")
+	}
+	return s.setQuantity(ctx, req.ListingId, req.VariationId, req.Quantity)
+}
+
+func (s *CartService) RemoveCartItem(ctx context.Context, req *pb.RemoveCartItemRequest) (*pb.Cart, error) {
+	return s.setQuantity(ctx, req.ListingId, req.VariationId, 0)
+}
+
+func (s *CartService) setQuantity(ctx context.Context, listingID, variationID, quantity int32) (*pb.Cart, error) {
+	userID, err := getUserIDFromContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	items, err := s.storage.SetCartItemQuantity(userID, listingID, variationID, quantity)
+	if err != nil {
+		if _, ok := err.(*storage.NotFoundError); ok {
+			return nil, status.Error(codes.NotFound, "Item is not in the cart")
+		}
+		return nil, status.Error(codes.Internal, "Failed to update cart")
+	}
+	return s.cart(userID, items)
+}
+
+func (s *CartService) Checkout(ctx context.Context, req *pb.CheckoutRequest) (*pb.CheckoutResponse, error) {
+	// Validate shipping address
+	addr := req.ShippingAddress
+	if addr == nil || addr.Street == "" || addr.City == "" || addr.Country == "" {
+		return nil, status.Error(codes.InvalidArgument, "Street, city, and country are required in shipping address")
+	}
+
+	userID, err := getUserIDFromContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	orders, err := s.storage.Checkout(userID, req.ShippingAddress, req.BuyerNotes, time.Now())
+	if err != nil {
+		switch err.(type) {
+		case *storage.EmptyCartError, *storage.CartItemUnavailableError:
+			return nil, status.Error(codes.FailedPrecondition, err.Error())
+		case *storage.SelfPurchaseError:
+			return nil, status.Error(codes.PermissionDenied, err.Error())
+		case *money.OverflowError:
+			return nil, moneyError(err)
+		}
+		return nil, status.Error(codes.Internal, "Failed to check out")
+	}
+
+	// Orders come back grouped by seller
+	resp := &pb.CheckoutResponse{}
+	for _, order := range orders {
+		if n := len(resp.Sellers); n == 0 || resp.Sellers[n-1].SellerId != order.SellerId {
+			resp.Sellers = append(resp.Sellers, &pb.SellerOrders{SellerId: order.SellerId})
+		}
+		group := resp.Sellers[len(resp.Sellers)-1]
+		group.Orders = append(group.Orders, order)
+	}
+	return resp, nil
+}
+
+// cart describes a user's cart items with the current price and stock of
+// their listings.
+func (s *CartService) cart(userID int32, items []*pb.CartItem) (*pb.Cart, error) {
+	cart := &pb.Cart{UserId: userID, Purchasable: true}
+	totals := map[string]*pb.Money{}
+	var currencies []string
+
+	for _, stored := range items {
+		item := proto.Clone(stored).(*pb.CartItem)
+		cart.Items = append(cart.Items, item)
+
+		listing, err := s.storage.GetListing(item.ListingId)
+		if err != nil {
+			if _, ok := err.(*storage.NotFoundError); !ok {
+				return nil, status.Error(codes.Internal, "Failed to get listing")
+			}
+			item.UnavailableReason = "Listing no longer exists"
+			cart.Purchasable = false
+			continue
+		}
+		describeCartItem(item, listing)
+		if !item.Purchasable {
+			cart.Purchasable = false
+			continue
+		}
+
+		currency := item.Subtotal.CurrencyCode
+		if totals[currency] == nil {
+			totals[currency] = item.Subtotal
+			currencies = append(currencies, currency)
+		} else if totals[currency], err = money.Add(totals[currency], item.Subtotal); err != nil {
+			return nil, moneyError(err)
+		}
+	}
+
+	for _, currency := range currencies {
+		cart.Totals = append(cart.Totals, totals[currency])
+	}
+	return cart, nil
+}
+
+// describeCartItem fills in an item's details from its listing and says
+// whether checkout could order it now.
+func describeCartItem(item *pb.CartItem, listing *pb.Listing) {
+	item.Title = listing.Title
+	item.SellerId = listing.UserId
+	item.UnitPrice = listing.Price
+	item.Available = listing.Quantity
+	if item.VariationId != 0 {
+		variation := storage.FindVariation(listing, item.VariationId)
+		if variation == nil {
+			item.UnavailableReason = "Variation no longer exists"
+			return
+		}
+		item.UnitPrice = variation.Price
+		item.Available = variation.Quantity
+	}
+	if subtotal, err := money.Mul(item.UnitPrice, int64(item.Quantity)); err == nil {
+		item.Subtotal = subtotal
+	}
+
+	switch {
+	case listing.Status != pb.ListingStatus_LISTING_STATUS_ACTIVE:
+		item.UnavailableReason = "Listing is not active"
+	case item.Available < item.Quantity:
+		item.UnavailableReason = "Not enough stock"
+	case item.Subtotal == nil:
+		item.UnavailableReason = "Subtotal is too large"
+	default:
+		item.Purchasable = true
+	}
+}
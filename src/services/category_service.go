@@ -0,0 +1,153 @@
+package services
+
+import (
+	"context"
+	"regexp"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	pb "ebayclone-grpc/proto"
+	"ebayclone-grpc/src/storage"
+)
+
+var slugPattern = regexp.MustCompile(`^[a-z0-9]+(-[a-z0-9]+)*$`)
+
+type CategoryService struct {
+	pb.UnimplementedCategoryServiceServer
+	storage storage.Storage
+}
+
+func NewCategoryService(storage storage.Storage) *CategoryService {
+	return &CategoryService{storage: storage}
+}
+
+func (s *CategoryService) CreateCategory(ctx context.Context, req *pb.CategoryCreate) (*pb.Category, error) {
+	// Validate required fields
+	if req.Slug == "" || req.Name == "" {
+		return nil, status.Error(codes.InvalidArgument, "Slug and name are required")
+	}
+	if !slugPattern.MatchString(req.Slug) {
+		return nil, status.Error(codes.InvalidArgument, "Slug must contain only lowercase letters, digits, and dashes")
+	}
+	if err := validateAttributeSchemas(req.Attributes); err != nil {
+		return nil, err
+	}
+
+	category := &pb.Category{
+		ParentId:   req.ParentId,
+		Slug:       req.Slug,
+		Name:       req.Name,
+		Attributes: req.Attributes,
+	}
+
+	err := s.storage.CreateCategory(category)
+	if err != nil {
+		switch err.(type) {
+		case *storage.CategoryExistsError:
+			return nil, status.Error(codes.AlreadyExists, "Slug already exists")
+		case *storage.NotFoundError:
+			return nil, status.Error(codes.NotFound, "Parent category not found")
+		}
+		return nil, status.Error(codes.Internal, "Failed to create category")
+	}
+
+	return category, nil
+}
+
+func (s *CategoryService) GetCategory(ctx context.Context, req *pb.GetCategoryRequest) (*pb.Category, error) {
+	category, err := s.storage.GetCategory(req.Id)
+	if err != nil {
+		if _, ok := err.(*storage.NotFoundError); ok {
+			return nil, status.Error(codes.NotFound, "Category not found")
+		}
+		return nil, status.Error(codes.Internal, "Failed to get category")
+	}
+	return category, nil
+}
+
+func (s *CategoryService) GetCategories(ctx context.Context, req *pb.CategoriesRequest) (*pb.CategoriesResponse, error) {
+	categories, err := s.storage.GetCategories(req.ParentId, req.IncludeDescendants)
+	if err != nil {
+		if _, ok := err.(*storage.NotFoundError); ok {
+			return nil, status.Error(codes.NotFound, "Parent category not found")
+		}
+		return nil, status.Error(codes.Internal, "Failed to get categories")
+	}
+
+	return &pb.CategoriesResponse{Categories: categories}, nil
+}
+
+func (s *CategoryService) UpdateCategory(ctx context.Context, req *pb.UpdateCategoryRequest) (*pb.Category, error) {
+	if req.Category == nil {
+		return nil, status.Error(codes.InvalidArgument, "Category is required")
+	}
+
+	// Get existing category
+	existing, err := s.storage.GetCategory(req.Id)
+	if err != nil {
+		if _, ok := err.(*storage.NotFoundError); ok {
+			return nil, status.Error(codes.NotFound, "Category not found")
+		}
+		return nil, status.Error(codes.Internal, "Failed to get category")
+	}
+
+	// Update fields if provided
+	updated := &pb.Category{
+		Id:         existing.Id,
+		ParentId:   existing.ParentId,
+		Slug:       existing.Slug,
+		Name:       existing.Name,
+		Attributes: existing.Attributes,
+	}
+
+	mask, err := newFieldMask(req.UpdateMask, "parent_id", "name", "attributes")
+	if err != nil {
+		return nil, err
+	}
+
+	// A masked parent_id of 0 moves the category to the top level
+	if mask.has("parent_id", req.Category.ParentId != 0) {
+		updated.ParentId = req.Category.ParentId
+	}
+	if mask.has("name", req.Category.Name != "") {
+		if req.Category.Name == "" {
+			return nil, status.Error(codes.InvalidArgument, "Name is required")
+		}
+		updated.Name = req.Category.Name
+	}
+	if mask.has("attributes", len(req.Category.Attributes) > 0) {
+		if err := validateAttributeSchemas(req.Category.Attributes); err != nil {
+			return nil, err
+		}
+		updated.Attributes = req.Category.Attributes
+	}
+
+	err = s.storage.UpdateCategory(req.Id, updated)
+	if err != nil {
+		switch err.(type) {
+		case *storage.NotFoundError:
+			return nil, status.Error(codes.NotFound, "Parent category not found")
+		case *storage.InvalidParentError:
+			return nil, status.Error(codes.InvalidArgument, err.Error())
+		}
+		return nil, status.Error(codes.Internal, "Failed to update category")
+	}
+
+	return updated, nil
+}
+
+func (s *CategoryService) DeleteCategory(ctx context.Context, req *pb.DeleteCategoryRequest) (*pb.Success, error) {
+	err := s.storage.DeleteCategory(req.Id)
+	if err != nil {
+		switch err.(type) {
+		case *storage.NotFoundError:
+			return nil, status.Error(codes.NotFound, "Category not found")
+		case *storage.CategoryInUseError:
+			return nil, status.Error(codes.FailedPrecondition, err.Error())
+		}
+		return nil, status.Error(codes.Internal, "Failed to delete category")
+	}
+
+	return &pb.Success{Message: "Category deleted successfully"}, nil
+}
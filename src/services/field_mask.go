@@ -0,0 +1,41 @@
+package services
+
+import (
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/fieldmaskpb"
+)
+
+// fieldMask is the set of fields an update request applies. A nil fieldMask
+// stands for a request without an update mask, which applies every field
+// that holds a non-zero value, so zero values cannot clear a field.
+type fieldMask map[string]bool
+
+// newFieldMask checks that every path of mask is one of paths.
+func newFieldMask(mask *fieldmaskpb.FieldMask, paths ...string) (fieldMask, error) {
+	if len(mask.GetPaths()) == 0 {
+		return nil, nil
+	}
+
+	known := make(map[string]bool)
+	for _, path := range paths {
+		known[path] = true
+	}
+	m := make(fieldMask)
+	for _, path := range mask.Paths {
+		if !known[path] {
+			return nil, status.Error(codes.InvalidArgument, "Unknown update_mask path: "+path)
+		}
+		m[path] = true
+	}
+	return m, nil
+}
+
+// has reports whether the field at path is applied. set tells whether the
+// field holds a non-zero value, which decides when there is no mask.
+func (m fieldMask) has(path string, set bool) bool {
+	if m == nil {
+		return set
+	}
+	return m[path]
+}
@@ -0,0 +1,239 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"log"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	pb "ebayclone-grpc/proto"
+	"ebayclone-grpc/src/imageproc"
+	"ebayclone-grpc/src/imagestore"
+	"ebayclone-grpc/src/storage"
+)
+
+const (
+	maxImageSize     = 5 * 1024 * 1024 // 5MB limit
+	maxListingImages = 5
+	sniffLen         = 12 // Bytes needed to recognise every accepted format
+)
+
+type ImageService struct {
+	pb.UnimplementedImageServiceServer
+	storage  storage.Storage
+	images   imagestore.Store
+	pipeline *imageproc.Pipeline
+}
+
+func NewImageService(storage storage.Storage, images imagestore.Store, pipeline *imageproc.Pipeline) *ImageService {
+	return &ImageService{storage: storage, images: images, pipeline: pipeline}
+}
+
+func (s *ImageService) GetImage(ctx context.Context, req *pb.GetImageRequest) (*pb.Image, error) {
+	// Uploads are only served once processing has removed their metadata.
+	// Rendered sizes have no record of their own.
+	if record, err := s.storage.GetImage(req.Id); err == nil {
+		switch record.Status {
+		case pb.ImageStatus_IMAGE_STATUS_PROCESSING:
+			return nil, status.Error(codes.FailedPrecondition, "Image is still being processed")
+		case pb.ImageStatus_IMAGE_STATUS_FAILED:
+			return nil, status.Error(codes.FailedPrecondition, "Image could not be processed")
+		}
+	}
+
+	image, err := s.images.Get(ctx, req.Id)
+	if err != nil {
+		switch err.(type) {
+		case *imagestore.NotFoundError, *imagestore.InvalidIDError:
+			return nil, status.Error(codes.NotFound, "Image not found")
+		}
+		return nil, status.Error(codes.Internal, "Failed to get image")
+	}
+
+	return &pb.Image{
+		Id:          image.ID,
+		ContentType: image.ContentType,
+		Data:        image.Data,
+	}, nil
+}
+
+// UploadImage receives an image as a stream of chunks so that images are not
+// bound by the gRPC message size limit. The upload is rejected as soon as it
+// grows past the size limit or its first bytes show it is not a JPEG, PNG or
+// WebP image. Uploads belong to the caller, who alone can put them on a
+// listing.
+func (s *ImageService) UploadImage(stream pb.ImageService_UploadImageServer) error {
+	userID, err := getUserIDFromContext(stream.Context())
+	if err != nil {
+		return err
+	}
+
+	var data []byte
+	var contentType string
+	for {
+		req, err := stream.Recv()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+
+		if len(data)+len(req.Chunk) > maxImageSize {
+			return status.Error(codes.InvalidArgument, "Image exceeds 5MB limit")
+		}
+		data = append(data, req.Chunk...)
+
+		if contentType == "" && len(data) >= sniffLen {
+			if contentType = sniffImageType(data); contentType == "" {
+				return status.Error(codes.InvalidArgument, "Only JPEG, PNG and WebP images are allowed")
+			}
+		}
+	}
+
+	if len(data) == 0 {
+		return status.Error(codes.InvalidArgument, "Image is empty")
+	}
+	if contentType == "" {
+		if contentType = sniffImageType(data); contentType == "" {
+			return status.Error(codes.InvalidArgument, "Only JPEG, PNG and WebP images are allowed")
+		}
+	}
+
+	ids, err := storeImages(stream.Context(), s.storage, s.images, s.pipeline, userID, [][]byte{data})
+	if err != nil {
+		return err
+	}
+	id := ids[0]
+
+	return stream.SendAndClose(&pb.UploadImageResponse{
+		Id:          id,
+		ContentType: contentType,
+		Size:        int64(len(data)),
+	})
+}
+
+// sniffImageType returns the content type of a JPEG, PNG or WebP image from
+// its magic bytes, or "" for anything else.
+func sniffImageType(data []byte) string {
+	switch {
+	case bytes.HasPrefix(data, []byte("\xff\xd8\xff")):
+		return "image/jpeg"
+	case bytes.HasPrefix(data, []byte("\x89PNG\r\n\x1a\n")):
+		return "image/png"
+	case len(data) >= 12 && bytes.Equal(data[:4], []byte("RIFF")) && bytes.Equal(data[8:12], []byte("WEBP")):
+		return "image/webp"
+	}
+	return ""
+}
+
+// validateImages checks the size, format and number of images attached to a
+// listing or variation, whether sent inline or uploaded beforehand.
+func validateImages(images [][]byte, imageIDs []string) error {
+	for i, imageBytes := range images {
+		if len(imageBytes) > maxImageSize {
+			return status.Error(codes.InvalidArgument, fmt.Sprintf("Image %d exceeds 5MB limit", i+1))
+		}
+		if sniffImageType(imageBytes) == "" {
+			return status.Error(codes.InvalidArgument, fmt.Sprintf("Image %d is not a JPEG, PNG or WebP image", i+1))
+		}
+	}
+
+	if len(images)+len(imageIDs) > maxListingImages {
+		return status.Error(codes.InvalidArgument, "Maximum 5 images allowed")
+	}
+	return nil
+}
+
+// checkUploadedImages verifies that image ids refer to images uploaded by
+// userID that could be processed and are not shown by a listing other than
+// listingID, which is 0 for a new listing.
+func checkUploadedImages(store storage.Storage, ids []string, userID int32, listingID int32) error {
+	seen := make(map[string]bool)
+	for _, id := range ids {
+		if seen[id] {
+			return status.Error(codes.InvalidArgument, "Duplicate image: "+id)
+		}
+		seen[id] = true
+
+		image, err := store.GetImage(id)
+		if err != nil {
+			if _, ok := err.(*storage.NotFoundError); ok {
+				return status.Error(codes.InvalidArgument, "Unknown image: "+id)
+			}
+			return status.Error(codes.Internal, "Failed to get image")
+		}
+		if image.Status == pb.ImageStatus_IMAGE_STATUS_FAILED {
+			return status.Error(codes.InvalidArgument, "Image could not be processed: "+id)
+		}
+		if image.OwnerId != userID {
+			return status.Error(codes.PermissionDenied, "Image was uploaded by another user: "+id)
+		}
+		if image.ListingId != 0 && image.ListingId != listingID {
+			return status.Error(codes.FailedPrecondition, "Image is already used by another listing: "+id)
+		}
+	}
+	return nil
+}
+
+// storeImages saves images uploaded by userID in the image store, queues
+// them for processing and returns their ids. If any image fails, the ones
+// already stored are removed again.
+func storeImages(ctx context.Context, store storage.Storage, images imagestore.Store, pipeline *imageproc.Pipeline, userID int32, uploads [][]byte) ([]string, error) {
+	var ids []string
+	for _, imageBytes := range uploads {
+		id, err := imagestore.NewID()
+		if err == nil {
+			err = images.Put(ctx, id, imageBytes, sniffImageType(imageBytes))
+		}
+		if err == nil {
+			ids = append(ids, id)
+			err = pipeline.Submit(ctx, id, userID)
+		}
+		if err != nil {
+			deleteImages(ctx, store, images, ids)
+			return nil, status.Error(codes.Internal, "Failed to store image")
+		}
+	}
+	return ids, nil
+}
+
+// deleteImages removes images that are no longer referenced, including their
+// rendered sizes. Failures only leave orphaned files behind, so they are
+// logged rather than returned.
+func deleteImages(ctx context.Context, store storage.Storage, images imagestore.Store, ids []string) {
+	for _, id := range ids {
+		files := []string{id}
+		if record, err := store.GetImage(id); err == nil {
+			store.DeleteImage(id)
+			for _, variant := range record.Variants {
+				if variant.ImageId != id {
+					files = append(files, variant.ImageId)
+				}
+			}
+		}
+
+		for _, file := range files {
+			if err := images.Delete(ctx, file); err != nil {
+				if _, ok := err.(*imagestore.NotFoundError); !ok {
+					log.Printf("Failed to delete image %s: %v", file, err)
+				}
+			}
+		}
+	}
+}
+
+// removedImages returns the ids in before that are missing from after.
+func removedImages(before, after []string) []string {
+	var removed []string
+	for _, id := range before {
+		if !containsString(after, id) {
+			removed = append(removed, id)
+		}
+	}
+	return removed
+}
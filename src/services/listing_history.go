@@ -0,0 +1,36 @@
+package services
+
+import (
+	"context"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	pb "ebayclone-grpc/proto"
+	"ebayclone-grpc/src/storage"
+)
+
+// GetListingHistory returns what a listing said over time, including after
+// it was deleted. Orders name the revision they were placed against in
+// listing_revision.
+func (s *ListingService) GetListingHistory(ctx context.Context, req *pb.GetListingHistoryRequest) (*pb.ListingHistoryResponse, error) {
+	if req.Revision < 0 {
+		return nil, status.Error(codes.InvalidArgument, "Revision must not be negative")
+	}
+
+	revisions, err := s.storage.GetListingRevisions(req.Id)
+	if err != nil {
+		if _, ok := err.(*storage.NotFoundError); ok {
+			return nil, status.Error(codes.NotFound, "Listing not found")
+		}
+		return nil, status.Error(codes.Internal, "Failed to get listing history")
+	}
+
+	if req.Revision > 0 {
+		if int(req.Revision) > len(revisions) {
+			return nil, status.Error(codes.NotFound, "Revision not found")
+		}
+		revisions = revisions[req.Revision-1 : req.Revision]
+	}
+	return &pb.ListingHistoryResponse{Revisions: revisions}, nil
+}
@@ -0,0 +1,160 @@
+package services
+
+import (
+	"context"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	pb "ebayclone-grpc/proto"
+	"ebayclone-grpc/src/storage"
+)
+
+func (s *ListingService) AddListingImages(ctx context.Context, req *pb.AddListingImagesRequest) (*pb.Listing, error) {
+	if len(req.Images) == 0 && len(req.ImageIds) == 0 {
+		return nil, status.Error(codes.InvalidArgument, "No images to add")
+	}
+	if err := validateImages(req.Images, req.ImageIds); err != nil {
+		return nil, err
+	}
+	userID, err := getUserIDFromContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkUploadedImages(s.storage, req.ImageIds, userID, req.Id); err != nil {
+		return nil, err
+	}
+	if err := s.checkSeller(ctx, req.Id); err != nil {
+		return nil, err
+	}
+
+	stored, err := storeImages(ctx, s.storage, s.images, s.pipeline, userID, req.Images)
+	if err != nil {
+		return nil, err
+	}
+
+	listing, err := s.updateImages(ctx, req.Id, func(images []string) ([]string, error) {
+		for _, id := range req.ImageIds {
+			if containsString(images, id) {
+				return nil, status.Error(codes.InvalidArgument, "Image is already on the listing: "+id)
+			}
+		}
+		added := append(stored, req.ImageIds...)
+		if len(images)+len(added) > maxListingImages {
+			return nil, status.Error(codes.InvalidArgument, "Maximum 5 images allowed")
+		}
+		return append(images, added...), nil
+	})
+	if err != nil {
+		deleteImages(ctx, s.storage, s.images, stored)
+		return nil, err
+	}
+	return listing, nil
+}
+
+func (s *ListingService) RemoveListingImage(ctx context.Context, req *pb.RemoveListingImageRequest) (*pb.Listing, error) {
+	if err := s.checkSeller(ctx, req.Id); err != nil {
+		return nil, err
+	}
+
+	listing, err := s.updateImages(ctx, req.Id, func(images []string) ([]string, error) {
+		i := indexOf(images, req.ImageId)
+		if i < 0 {
+			return nil, status.Error(codes.NotFound, "Image not found on listing")
+		}
+		return append(images[:i], images[i+1:]...), nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	deleteImages(ctx, s.storage, s.images, []string{req.ImageId})
+	return listing, nil
+}
+
+func (s *ListingService) ReorderListingImages(ctx context.Context, req *pb.ReorderListingImagesRequest) (*pb.Listing, error) {
+	if err := s.checkSeller(ctx, req.Id); err != nil {
+		return nil, err
+	}
+
+	return s.updateImages(ctx, req.Id, func(images []string) ([]string, error) {
+		if len(req.ImageIds) != len(images) {
+			return nil, status.Error(codes.InvalidArgument, "image_ids must list every image of the listing exactly once")
+		}
+		seen := make(map[string]bool)
+		for _, id := range req.ImageIds {
+			if seen[id] || !containsString(images, id) {
+				return nil, status.Error(codes.InvalidArgument, "image_ids must list every image of the listing exactly once")
+			}
+			seen[id] = true
+		}
+		return req.ImageIds, nil
+	})
+}
+
+// SetPrimaryImage moves an image to the front. The first image of a listing
+// is its primary image, shown in search results.
+func (s *ListingService) SetPrimaryImage(ctx context.Context, req *pb.SetPrimaryImageRequest) (*pb.Listing, error) {
+	if err := s.checkSeller(ctx, req.Id); err != nil {
+		return nil, err
+	}
+
+	return s.updateImages(ctx, req.Id, func(images []string) ([]string, error) {
+		i := indexOf(images, req.ImageId)
+		if i < 0 {
+			return nil, status.Error(codes.NotFound, "Image not found on listing")
+		}
+		primary := []string{req.ImageId}
+		return append(append(primary, images[:i]...), images[i+1:]...), nil
+	})
+}
+
+// checkSeller verifies that the caller is the seller of the listing.
+func (s *ListingService) checkSeller(ctx context.Context, id int32) error {
+	userID, err := getUserIDFromContext(ctx)
+	if err != nil {
+		return err
+	}
+	listing, err := s.storage.GetListing(id)
+	if err != nil {
+		if _, ok := err.(*storage.NotFoundError); ok {
+			return status.Error(codes.NotFound, "Listing not found")
+		}
+		return status.Error(codes.Internal, "Failed to get listing")
+	}
+	if listing.UserId != userID {
+		return status.Error(codes.PermissionDenied, "Only the seller can change a listing")
+	}
+	return nil
+}
+
+// updateImages applies update to the listing's images atomically.
+func (s *ListingService) updateImages(ctx context.Context, id int32, update func(images []string) ([]string, error)) (*pb.Listing, error) {
+	userID, err := getUserIDFromContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	listing, err := s.storage.UpdateListingImages(id, userID, update)
+	if err != nil {
+		if _, ok := err.(*storage.NotFoundError); ok {
+			return nil, status.Error(codes.NotFound, "Listing not found")
+		}
+		if _, ok := err.(*storage.ImageUnavailableError); ok {
+			return nil, status.Error(codes.FailedPrecondition, err.Error())
+		}
+		if _, ok := status.FromError(err); ok {
+			return nil, err
+		}
+		return nil, status.Error(codes.Internal, "Failed to update listing images")
+	}
+	return listing, nil
+}
+
+func indexOf(values []string, value string) int {
+	for i, v := range values {
+		if v == value {
+			return i
+		}
+	}
+	return -1
+}
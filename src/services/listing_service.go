@@ -2,71 +2,312 @@ package services
 
 import (
 	"context"
-	"encoding/base64"
-	"fmt"
+	"sort"
 	"time"
 
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/durationpb"
 	"google.golang.org/protobuf/types/known/timestamppb"
 
 	pb "ebayclone-grpc/proto"
+	"ebayclone-grpc/src/imageproc"
+	"ebayclone-grpc/src/imagestore"
+	"ebayclone-grpc/src/money"
 	"ebayclone-grpc/src/storage"
 )
 
 type ListingService struct {
 	pb.UnimplementedListingServiceServer
-	storage storage.Storage
+	storage  storage.Storage
+	images   imagestore.Store
+	pipeline *imageproc.Pipeline
+	rates    money.RateProvider
 }
 
-func NewListingService(storage storage.Storage) *ListingService {
-	return &ListingService{storage: storage}
+func NewListingService(storage storage.Storage, images imagestore.Store, pipeline *imageproc.Pipeline, rates money.RateProvider) *ListingService {
+	return &ListingService{storage: storage, images: images, pipeline: pipeline, rates: rates}
 }
 
 func (s *ListingService) GetListings(ctx context.Context, req *pb.ListingsRequest) (*pb.ListingsResponse, error) {
-	listings, err := s.storage.GetListings(req.Search, req.PriceMin, req.PriceMax)
+	// Validate geographic filters
+	if req.Near != nil && !validCoordinates(req.Near) {
+		return nil, status.Error(codes.InvalidArgument, "Invalid coordinates for near")
+	}
+	if req.RadiusKm < 0 {
+		return nil, status.Error(codes.InvalidArgument, "Radius must not be negative")
+	}
+	if req.RadiusKm > 0 && req.Near == nil {
+		return nil, status.Error(codes.InvalidArgument, "Radius search requires near")
+	}
+	switch req.SortBy {
+	case "":
+	case "distance":
+		if req.Near == nil {
+			return nil, status.Error(codes.InvalidArgument, "Sorting by distance requires near")
+		}
+	default:
+		return nil, status.Error(codes.InvalidArgument, "Invalid sort_by. Must be: distance")
+	}
+	if req.Category != "" {
+		if err := s.checkCategory(req.Category); err != nil {
+			return nil, err
+		}
+	}
+	for _, filter := range req.Attributes {
+		if filter.Name == "" {
+			return nil, status.Error(codes.InvalidArgument, "Attribute filter name is required")
+		}
+	}
+	if req.Currency != "" {
+		if _, err := money.Digits(req.Currency); err != nil {
+			return nil, status.Error(codes.InvalidArgument, err.Error())
+		}
+	}
+
+	// Price bounds are given in the buyer's currency
+	currency := req.Currency
+	for _, bound := range []*pb.Money{req.PriceMin, req.PriceMax} {
+		if bound == nil {
+			continue
+		}
+		if err := money.Validate(bound); err != nil {
+			return nil, status.Error(codes.InvalidArgument, err.Error())
+		}
+		if currency == "" {
+			currency = bound.CurrencyCode
+		}
+		if bound.CurrencyCode != currency {
+			return nil, status.Error(codes.InvalidArgument, (&money.CurrencyMismatchError{Want: currency, Got: bound.CurrencyCode}).Error())
+		}
+	}
+
+	// Only active listings are shown unless other statuses are requested
+	statuses := req.Statuses
+	if len(statuses) == 0 {
+		statuses = []pb.ListingStatus{pb.ListingStatus_LISTING_STATUS_ACTIVE}
+	}
+
+	listings, err := s.storage.GetListings(storage.ListingFilter{
+		Search:         req.Search,
+		PriceMin:       req.PriceMin,
+		PriceMax:       req.PriceMax,
+		Category:       req.Category,
+		Attributes:     req.Attributes,
+		Statuses:       statuses,
+		Near:           req.Near,
+		RadiusKm:       req.RadiusKm,
+		SortByDistance: req.SortBy == "distance",
+		Rates:          s.rates,
+		Format:         req.Format,
+	})
 	if err != nil {
 		return nil, status.Error(codes.Internal, "Failed to get listings")
 	}
 
-	return &pb.ListingsResponse{Listings: listings}, nil
+	resp := &pb.ListingsResponse{Listings: listings}
+	if req.Near != nil {
+		resp.DistancesKm = make(map[int32]float64)
+		for _, listing := range listings {
+			if listing.Coordinates != nil {
+				resp.DistancesKm[listing.Id] = storage.DistanceKm(req.Near, listing.Coordinates)
+			}
+		}
+	}
+	if req.Currency != "" {
+		resp.ConvertedPrices = make(map[int32]*pb.Money)
+		for _, listing := range listings {
+			// Listings without a rate to the buyer's currency are left out
+			if price, _, err := convert(s.rates, listing.Price, req.Currency); err == nil {
+				resp.ConvertedPrices[listing.Id] = price
+			}
+		}
+	}
+	return resp, nil
 }
 
 func (s *ListingService) CreateListing(ctx context.Context, req *pb.ListingCreate) (*pb.Listing, error) {
+	userID, err := getUserIDFromContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+
 	// Validate required fields
-	if req.Title == "" || req.Description == "" || req.Price <= 0 {
+	if _, ok := pb.ListingFormat_name[int32(req.Format)]; !ok {
+		return nil, status.Error(codes.InvalidArgument, "Invalid format")
+	}
+	isAuction := req.Format == pb.ListingFormat_LISTING_FORMAT_AUCTION
+	if req.Title == "" || req.Description == "" || (req.Price == nil && len(req.Variations) == 0 && !isAuction) {
 		return nil, status.Error(codes.InvalidArgument, "Title, description, and price are required")
 	}
+	if req.Price != nil {
+		if err := validatePrice(req.Price); err != nil {
+			return nil, err
+		}
+	}
 
-	// Convert image bytes to base64 strings for storage
-	var imageStrings []string
-	for i, imageBytes := range req.Images {
-		if len(imageBytes) > 5*1024*1024 { // 5MB limit
-			return nil, status.Error(codes.InvalidArgument, fmt.Sprintf("Image %d exceeds 5MB limit", i+1))
+	if err := validateImages(req.Images, req.ImageIds); err != nil {
+		return nil, err
+	}
+	if err := checkUploadedImages(s.storage, req.ImageIds, userID, 0); err != nil {
+		return nil, err
+	}
+
+	if req.Coordinates != nil && !validCoordinates(req.Coordinates) {
+		return nil, status.Error(codes.InvalidArgument, "Invalid coordinates")
+	}
+
+	price, quantity := req.Price, req.Quantity
+	if quantity < 0 {
+		return nil, status.Error(codes.InvalidArgument, "Quantity must not be negative")
+	}
+	if quantity == 0 {
+		quantity = 1
+	}
+
+	// Listings with variations take their price and stock from them
+	variations, err := buildVariations(req.Variations)
+	if err != nil {
+		return nil, err
+	}
+	if len(variations) > 0 {
+		if req.Price != nil || req.Quantity > 0 {
+			return nil, status.Error(codes.InvalidArgument, "Price and quantity are set per variation")
+		}
+		price, quantity = variationTotals(variations)
+		if quantity == 0 {
+			return nil, status.Error(codes.InvalidArgument, "At least one variation must be in stock")
+		}
+	}
+	for _, variation := range req.Variations {
+		if err := checkUploadedImages(s.storage, variation.ImageIds, userID, 0); err != nil {
+			return nil, err
 		}
-		imageStrings = append(imageStrings, base64.StdEncoding.EncodeToString(imageBytes))
 	}
 
-	if len(imageStrings) > 5 {
-		return nil, status.Error(codes.InvalidArgument, "Maximum 5 images allowed")
+	// Auctions sell a single item at the price bidding reaches
+	format := pb.ListingFormat_LISTING_FORMAT_FIXED_PRICE
+	var auctionState *pb.Auction
+	if isAuction {
+		if auctionState, err = newAuction(req); err != nil {
+			return nil, err
+		}
+		format = pb.ListingFormat_LISTING_FORMAT_AUCTION
+		price, quantity = auctionState.StartPrice, 1
+	} else if req.Auction != nil {
+		return nil, status.Error(codes.InvalidArgument, "Auction terms require the auction format")
+	}
+	if req.BestOffer != nil {
+		if isAuction {
+			return nil, status.Error(codes.InvalidArgument, "Auctions cannot accept offers")
+		}
+		if err := validateBestOffer(req.BestOffer, price); err != nil {
+			return nil, err
+		}
+	}
+
+	// Validate category and item specifics
+	if err := s.validateCategoryAttributes(req.Category, req.Attributes, variations); err != nil {
+		return nil, err
+	}
+
+	listingStatus := pb.ListingStatus_LISTING_STATUS_ACTIVE
+	now := time.Now()
+	runStart := now
+	switch {
+	case req.Draft && req.ScheduledStart != nil:
+		return nil, status.Error(codes.InvalidArgument, "A listing cannot be both a draft and scheduled")
+	case req.Draft:
+		listingStatus = pb.ListingStatus_LISTING_STATUS_DRAFT
+	case req.ScheduledStart != nil:
+		if !req.ScheduledStart.AsTime().After(now) {
+			return nil, status.Error(codes.InvalidArgument, "scheduled_start must be in the future")
+		}
+		listingStatus = pb.ListingStatus_LISTING_STATUS_SCHEDULED
+		runStart = req.ScheduledStart.AsTime()
+	}
+
+	// Work out when the listing's run ends. Drafts and scheduled listings
+	// start their run when they go live, so they only keep the duration.
+	live := listingStatus == pb.ListingStatus_LISTING_STATUS_ACTIVE
+	duration, endsAt := req.Duration, req.EndsAt
+	switch {
+	case duration != nil && endsAt != nil:
+		return nil, status.Error(codes.InvalidArgument, "Specify either duration or ends_at, not both")
+	case duration != nil:
+		if duration.AsDuration() <= 0 {
+			return nil, status.Error(codes.InvalidArgument, "Duration must be positive")
+		}
+		endsAt = nil
+		if live {
+			endsAt = timestamppb.New(now.Add(duration.AsDuration()))
+		}
+	case endsAt != nil:
+		if !endsAt.AsTime().After(runStart) {
+			return nil, status.Error(codes.InvalidArgument, "ends_at must be after the listing goes live")
+		}
+		duration = durationpb.New(endsAt.AsTime().Sub(runStart))
+		if !live {
+			endsAt = nil
+		}
+	case req.AutoRelist:
+		return nil, status.Error(codes.InvalidArgument, "Auto-relist requires a duration or ends_at")
 	}
 
 	listing := &pb.Listing{
-		Title:       req.Title,
-		Description: req.Description,
-		Price:       req.Price,
-		Category:    req.Category,
-		Condition:   req.Condition,
-		Location:    req.Location,
-		Images:      imageStrings,
-		UserId:      getUserIDFromContext(ctx), // Extract from JWT token
+		Title:          req.Title,
+		Description:    req.Description,
+		Price:          price,
+		Category:       req.Category,
+		Condition:      req.Condition,
+		Location:       req.Location,
+		Coordinates:    req.Coordinates,
+		Attributes:     req.Attributes,
+		Quantity:       quantity,
+		Variations:     variations,
+		Status:         listingStatus,
+		EndsAt:         endsAt,
+		Duration:       duration,
+		AutoRelist:     req.AutoRelist,
+		ScheduledStart: req.ScheduledStart,
+		Format:         format,
+		Auction:        auctionState,
+		UserId:         userID,
+	}
+
+	// Images go to the image store; the listing keeps only their ids.
+	// Inline images come first, followed by previously uploaded ones.
+	imageIDs, err := storeImages(ctx, s.storage, s.images, s.pipeline, userID, req.Images)
+	if err != nil {
+		return nil, err
+	}
+	listing.Images = append(imageIDs, req.ImageIds...)
+	for i, variation := range variations {
+		stored, err := storeImages(ctx, s.storage, s.images, s.pipeline, userID, req.Variations[i].Images)
+		if err != nil {
+			deleteImages(ctx, s.storage, s.images, imageIDs)
+			return nil, err
+		}
+		imageIDs = append(imageIDs, stored...)
+		variation.Images = append(stored, req.Variations[i].ImageIds...)
 	}
 
-	err := s.storage.CreateListing(listing)
+	err = s.storage.CreateListing(listing)
 	if err != nil {
+		deleteImages(ctx, s.storage, s.images, imageIDs)
+		if _, ok := err.(*storage.ImageUnavailableError); ok {
+			return nil, status.Error(codes.FailedPrecondition, err.Error())
+		}
 		return nil, status.Error(codes.Internal, "Failed to create listing")
 	}
 
+	// Offer terms are kept privately, apart from the listing
+	if req.BestOffer != nil {
+		if listing, err = s.storage.SetBestOffer(listing.Id, req.BestOffer); err != nil {
+			return nil, status.Error(codes.Internal, "Failed to set Best Offer terms")
+		}
+	}
+
 	return listing, nil
 }
 
@@ -82,6 +323,14 @@ func (s *ListingService) GetListing(ctx context.Context, req *pb.GetListingReque
 }
 
 func (s *ListingService) UpdateListing(ctx context.Context, req *pb.UpdateListingRequest) (*pb.Listing, error) {
+	if req.Listing == nil {
+		return nil, status.Error(codes.InvalidArgument, "Listing is required")
+	}
+	userID, err := getUserIDFromContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+
 	// Get existing listing
 	existing, err := s.storage.GetListing(req.Id)
 	if err != nil {
@@ -90,52 +339,172 @@ func (s *ListingService) UpdateListing(ctx context.Context, req *pb.UpdateListin
 		}
 		return nil, status.Error(codes.Internal, "Failed to get listing")
 	}
+	if existing.UserId != userID {
+		return nil, status.Error(codes.PermissionDenied, "Only the seller can update a listing")
+	}
 
 	// Update fields if provided
 	updated := &pb.Listing{
-		Id:          existing.Id,
-		Title:       existing.Title,
-		Description: existing.Description,
-		Price:       existing.Price,
-		Category:    existing.Category,
-		Condition:   existing.Condition,
-		Location:    existing.Location,
-		Images:      existing.Images,
-		UserId:      existing.UserId,
-		CreatedAt:   existing.CreatedAt,
-	}
-
-	if req.Listing.Title != "" {
+		Id:             existing.Id,
+		Title:          existing.Title,
+		Description:    existing.Description,
+		Price:          existing.Price,
+		Category:       existing.Category,
+		Condition:      existing.Condition,
+		Location:       existing.Location,
+		Coordinates:    existing.Coordinates,
+		Attributes:     existing.Attributes,
+		EndsAt:         existing.EndsAt,
+		Duration:       existing.Duration,
+		AutoRelist:     existing.AutoRelist,
+		RelistCount:    existing.RelistCount,
+		ScheduledStart: existing.ScheduledStart,
+		Images:         existing.Images,
+		UserId:         existing.UserId,
+		CreatedAt:      existing.CreatedAt,
+		Version:        req.ExpectedVersion,
+	}
+
+	mask, err := newFieldMask(req.UpdateMask, "title", "description", "price", "category", "condition",
+		"location", "coordinates", "attributes", "quantity", "image_ids", "best_offer")
+	if err != nil {
+		return nil, err
+	}
+
+	if mask.has("title", req.Listing.Title != "") {
+		if req.Listing.Title == "" {
+			return nil, status.Error(codes.InvalidArgument, "Title is required")
+		}
 		updated.Title = req.Listing.Title
 	}
-	if req.Listing.Description != "" {
+	if mask.has("description", req.Listing.Description != "") {
 		updated.Description = req.Listing.Description
 	}
-	if req.Listing.Price > 0 {
+	if mask.has("price", req.Listing.Price != nil) {
+		if existing.Auction != nil {
+			return nil, status.Error(codes.InvalidArgument, "Auction prices are set by bidding")
+		}
+		if len(existing.Variations) > 0 {
+			return nil, status.Error(codes.InvalidArgument, "Price is set per variation")
+		}
+		if err := validatePrice(req.Listing.Price); err != nil {
+			return nil, err
+		}
+		// Orders and price filters compare amounts in the listing's currency
+		if err := money.SameCurrency(existing.Price, req.Listing.Price); err != nil {
+			return nil, status.Error(codes.InvalidArgument, err.Error())
+		}
 		updated.Price = req.Listing.Price
 	}
-	if req.Listing.Category != "" {
+	if mask.has("category", req.Listing.Category != "") {
 		updated.Category = req.Listing.Category
 	}
-	if req.Listing.Condition != "" {
+	if mask.has("condition", req.Listing.Condition != "") {
 		updated.Condition = req.Listing.Condition
 	}
-	if req.Listing.Location != "" {
+	if mask.has("location", req.Listing.Location != "") {
 		updated.Location = req.Listing.Location
 	}
+	if mask.has("coordinates", req.Listing.Coordinates != nil) {
+		if req.Listing.Coordinates != nil && !validCoordinates(req.Listing.Coordinates) {
+			return nil, status.Error(codes.InvalidArgument, "Invalid coordinates")
+		}
+		updated.Coordinates = req.Listing.Coordinates
+	}
+	setAttributes := mask.has("attributes", len(req.Listing.Attributes) > 0)
+	if setAttributes {
+		updated.Attributes = req.Listing.Attributes
+	}
+	if mask.has("image_ids", len(req.Listing.ImageIds) > 0) {
+		if err := validateImages(nil, req.Listing.ImageIds); err != nil {
+			return nil, err
+		}
+		if err := checkUploadedImages(s.storage, req.Listing.ImageIds, existing.UserId, existing.Id); err != nil {
+			return nil, err
+		}
+		updated.Images = req.Listing.ImageIds
+	}
+
+	// A quantity of zero in the mask marks the listing (or variation) sold out
+	setQuantity := mask.has("quantity", req.Listing.Quantity > 0)
+	if req.Listing.Quantity < 0 {
+		return nil, status.Error(codes.InvalidArgument, "Quantity must not be negative")
+	}
+	if req.Listing.VariationId != 0 && !setQuantity {
+		return nil, status.Error(codes.InvalidArgument, "variation_id requires quantity")
+	}
+	if setQuantity && existing.Auction != nil {
+		return nil, status.Error(codes.InvalidArgument, "Auctions sell a single item")
+	}
+	if setQuantity && req.Listing.VariationId == 0 && len(existing.Variations) > 0 {
+		return nil, status.Error(codes.InvalidArgument, "Quantity is set per variation; specify variation_id")
+	}
+
+	setBestOffer := mask.has("best_offer", req.Listing.BestOffer != nil)
+	if setBestOffer && existing.Auction != nil {
+		return nil, status.Error(codes.InvalidArgument, "Auctions cannot accept offers")
+	}
+	if setBestOffer && req.Listing.BestOffer != nil {
+		if err := validateBestOffer(req.Listing.BestOffer, updated.Price); err != nil {
+			return nil, err
+		}
+	}
+
+	// Revalidate item specifics when the category or attributes change
+	if updated.Category != existing.Category || setAttributes {
+		if err := s.validateCategoryAttributes(updated.Category, updated.Attributes, existing.Variations); err != nil {
+			return nil, err
+		}
+	}
 
 	updated.UpdatedAt = timestamppb.New(time.Now())
 
-	err = s.storage.UpdateListing(req.Id, updated)
+	// Offer terms are kept privately, apart from the listing, but change
+	// with it
+	if setBestOffer {
+		err = s.storage.UpdateListingBestOffer(req.Id, updated, userID, req.Listing.BestOffer)
+	} else {
+		err = s.storage.UpdateListing(req.Id, updated, userID)
+	}
 	if err != nil {
+		switch err.(type) {
+		case *storage.NotFoundError:
+			return nil, status.Error(codes.NotFound, "Listing not found")
+		case *storage.VersionConflictError:
+			return nil, status.Error(codes.Aborted, err.Error())
+		case *storage.OffersNotAcceptedError, *storage.ImageUnavailableError:
+			return nil, status.Error(codes.FailedPrecondition, err.Error())
+		}
 		return nil, status.Error(codes.Internal, "Failed to update listing")
 	}
+	deleteImages(ctx, s.storage, s.images, removedImages(existing.Images, updated.Images))
+
+	// Stock is set separately so it cannot overwrite concurrent reservations
+	if setQuantity {
+		if req.Listing.VariationId != 0 {
+			updated, err = s.storage.SetVariationQuantity(req.Id, req.Listing.VariationId, req.Listing.Quantity)
+		} else {
+			updated, err = s.storage.SetListingQuantity(req.Id, req.Listing.Quantity)
+		}
+		if err != nil {
+			switch err.(type) {
+			case *storage.NotFoundError:
+				return nil, status.Error(codes.NotFound, err.Error())
+			case *storage.VariationRequiredError:
+				return nil, status.Error(codes.InvalidArgument, err.Error())
+			}
+			return nil, status.Error(codes.Internal, "Failed to update listing quantity")
+		}
+	}
 
 	return updated, nil
 }
 
 func (s *ListingService) DeleteListing(ctx context.Context, req *pb.DeleteListingRequest) (*pb.Success, error) {
-	err := s.storage.DeleteListing(req.Id)
+	listing, err := s.storage.GetListing(req.Id)
+	if err == nil {
+		err = s.storage.DeleteListing(req.Id)
+	}
 	if err != nil {
 		if _, ok := err.(*storage.NotFoundError); ok {
 			return nil, status.Error(codes.NotFound, "Listing not found")
@@ -143,12 +512,130 @@ func (s *ListingService) DeleteListing(ctx context.Context, req *pb.DeleteListin
 		return nil, status.Error(codes.Internal, "Failed to delete listing")
 	}
 
+	deleteImages(ctx, s.storage, s.images, listing.Images)
+	for _, variation := range listing.Variations {
+		deleteImages(ctx, s.storage, s.images, variation.Images)
+	}
+
 	return &pb.Success{Message: "Listing deleted successfully"}, nil
 }
 
-// Helper function to extract user ID from JWT token in context
-func getUserIDFromContext(ctx context.Context) int32 {
-	// In a real implementation, extract from JWT token in metadata
-	// For demo purposes, return a default user ID
-	return 1
+// checkCategory verifies that a category slug exists in the taxonomy.
+func (s *ListingService) checkCategory(slug string) error {
+	_, err := s.storage.GetCategoryBySlug(slug)
+	if err != nil {
+		if _, ok := err.(*storage.NotFoundError); ok {
+			return status.Error(codes.InvalidArgument, "Unknown category: "+slug)
+		}
+		return status.Error(codes.Internal, "Failed to get category")
+	}
+	return nil
+}
+
+func (s *ListingService) PublishListing(ctx context.Context, req *pb.ListingActionRequest) (*pb.Listing, error) {
+	return s.transition(ctx, req.Id, pb.ListingStatus_LISTING_STATUS_ACTIVE)
+}
+
+func (s *ListingService) PauseListing(ctx context.Context, req *pb.ListingActionRequest) (*pb.Listing, error) {
+	return s.transition(ctx, req.Id, pb.ListingStatus_LISTING_STATUS_PAUSED)
+}
+
+func (s *ListingService) EndListing(ctx context.Context, req *pb.ListingActionRequest) (*pb.Listing, error) {
+	return s.transition(ctx, req.Id, pb.ListingStatus_LISTING_STATUS_ENDED)
+}
+
+func (s *ListingService) GetScheduledListings(ctx context.Context, req *pb.ScheduledListingsRequest) (*pb.ListingsResponse, error) {
+	userID, err := getUserIDFromContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	listings, err := s.storage.GetListings(storage.ListingFilter{
+		UserID:   userID,
+		Statuses: []pb.ListingStatus{pb.ListingStatus_LISTING_STATUS_SCHEDULED},
+	})
+	if err != nil {
+		return nil, status.Error(codes.Internal, "Failed to get listings")
+	}
+
+	sort.Slice(listings, func(i, j int) bool {
+		return listings[i].ScheduledStart.AsTime().Before(listings[j].ScheduledStart.AsTime())
+	})
+	return &pb.ListingsResponse{Listings: listings}, nil
+}
+
+func (s *ListingService) RescheduleListing(ctx context.Context, req *pb.RescheduleListingRequest) (*pb.Listing, error) {
+	if req.ScheduledStart == nil || !req.ScheduledStart.AsTime().After(time.Now()) {
+		return nil, status.Error(codes.InvalidArgument, "scheduled_start must be in the future")
+	}
+
+	userID, err := getUserIDFromContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	existing, err := s.storage.GetListing(req.Id)
+	if err != nil {
+		if _, ok := err.(*storage.NotFoundError); ok {
+			return nil, status.Error(codes.NotFound, "Listing not found")
+		}
+		return nil, status.Error(codes.Internal, "Failed to get listing")
+	}
+	if existing.UserId != userID {
+		return nil, status.Error(codes.PermissionDenied, "Only the seller can reschedule a listing")
+	}
+
+	listing, err := s.storage.RescheduleListing(req.Id, req.ScheduledStart.AsTime())
+	if err != nil {
+		switch err.(type) {
+		case *storage.NotFoundError:
+			return nil, status.Error(codes.NotFound, "Listing not found")
+		case *storage.InvalidTransitionError:
+			return nil, status.Error(codes.FailedPrecondition, err.Error())
+		}
+		return nil, status.Error(codes.Internal, "Failed to reschedule listing")
+	}
+	return listing, nil
+}
+
+func (s *ListingService) transition(ctx context.Context, id int32, to pb.ListingStatus) (*pb.Listing, error) {
+	if err := s.checkSeller(ctx, id); err != nil {
+		return nil, err
+	}
+	listing, err := s.storage.TransitionListing(id, to)
+	if err != nil {
+		switch err.(type) {
+		case *storage.NotFoundError:
+			return nil, status.Error(codes.NotFound, "Listing not found")
+		case *storage.InvalidTransitionError:
+			return nil, status.Error(codes.FailedPrecondition, err.Error())
+		case *storage.OutOfStockError:
+			return nil, status.Error(codes.FailedPrecondition, "Listing has no stock to publish")
+		}
+		return nil, status.Error(codes.Internal, "Failed to update listing status")
+	}
+	return listing, nil
+}
+
+// validateCategoryAttributes verifies that the category exists and that the
+// attributes, and those of every variation, match its schema.
+func (s *ListingService) validateCategoryAttributes(category string, attributes []*pb.AttributeValue, variations []*pb.ListingVariation) error {
+	if category == "" {
+		if len(attributes) > 0 || len(variations) > 0 {
+			return status.Error(codes.InvalidArgument, "Attributes require a category")
+		}
+		return nil
+	}
+
+	schemas, err := categoryAttributes(s.storage, category)
+	if err != nil {
+		return err
+	}
+	if len(variations) > 0 {
+		return validateVariations(schemas, attributes, variations)
+	}
+	return validateAttributes(schemas, attributes)
+}
+
+func validCoordinates(point *pb.GeoPoint) bool {
+	return point.Latitude >= -90 && point.Latitude <= 90 &&
+		point.Longitude >= -180 && point.Longitude <= 180
 }
@@ -0,0 +1,212 @@
+package services
+
+import (
+	"context"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+
+	pb "ebayclone-grpc/proto"
+	"ebayclone-grpc/src/money"
+	"ebayclone-grpc/src/storage"
+)
+
+// offerLifetime is how long the other party has to answer an offer or a
+// counter before it expires.
+const offerLifetime = 48 * time.Hour
+
+type OfferService struct {
+	pb.UnimplementedOfferServiceServer
+	storage storage.Storage
+}
+
+func NewOfferService(storage storage.Storage) *OfferService {
+	return &OfferService{storage: storage}
+}
+
+func (s *OfferService) MakeOffer(ctx context.Context, req *pb.MakeOfferRequest) (*pb.Offer, error) {
+	// Validate required fields
+	if req.ListingId <= 0 || req.Amount == nil || req.ShippingAddress == nil {
+		return nil, status.Error(codes.InvalidArgument, "ListingId, amount, and shipping address are required")
+	}
+	if err := validateOfferAmount(req.Amount); err != nil {
+		return nil, err
+	}
+	if req.Quantity < 0 {
+		return nil, status.Error(codes.InvalidArgument, "Quantity must not be negative")
+	}
+	addr := req.ShippingAddress
+	if addr.Street == "" || addr.City == "" || addr.Country == "" {
+		return nil, status.Error(codes.InvalidArgument, "Street, city, and country are required in shipping address")
+	}
+
+	quantity := req.Quantity
+	if quantity == 0 {
+		quantity = 1
+	}
+	userID, err := getUserIDFromContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	offer := &pb.Offer{
+		ListingId:       req.ListingId,
+		VariationId:     req.VariationId,
+		BuyerId:         userID,
+		Quantity:        quantity,
+		Amount:          req.Amount,
+		Message:         req.Message,
+		ShippingAddress: req.ShippingAddress,
+		ExpiresAt:       timestamppb.New(now.Add(offerLifetime)),
+	}
+	if _, err := s.storage.CreateOffer(offer, now); err != nil {
+		return nil, offerError(err, "Failed to make offer")
+	}
+
+	return offer, nil
+}
+
+func (s *OfferService) CounterOffer(ctx context.Context, req *pb.CounterOfferRequest) (*pb.Offer, error) {
+	if req.OfferId <= 0 || req.Amount == nil {
+		return nil, status.Error(codes.InvalidArgument, "OfferId and amount are required")
+	}
+	if err := validateOfferAmount(req.Amount); err != nil {
+		return nil, err
+	}
+
+	userID, err := getUserIDFromContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	offer, _, err := s.storage.CounterOffer(req.OfferId, userID, req.Amount, req.Message, now.Add(offerLifetime), now)
+	if err != nil {
+		return nil, offerError(err, "Failed to counter offer")
+	}
+	return offer, nil
+}
+
+func (s *OfferService) AcceptOffer(ctx context.Context, req *pb.OfferActionRequest) (*pb.Order, error) {
+	userID, err := getUserIDFromContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	_, order, err := s.storage.AcceptOffer(req.OfferId, userID, time.Now())
+	if err != nil {
+		return nil, offerError(err, "Failed to accept offer")
+	}
+	return order, nil
+}
+
+func (s *OfferService) DeclineOffer(ctx context.Context, req *pb.OfferActionRequest) (*pb.Offer, error) {
+	userID, err := getUserIDFromContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	offer, err := s.storage.DeclineOffer(req.OfferId, userID, time.Now())
+	if err != nil {
+		return nil, offerError(err, "Failed to decline offer")
+	}
+	return offer, nil
+}
+
+func (s *OfferService) WithdrawOffer(ctx context.Context, req *pb.OfferActionRequest) (*pb.Offer, error) {
+	userID, err := getUserIDFromContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	offer, err := s.storage.WithdrawOffer(req.OfferId, userID, time.Now())
+	if err != nil {
+		return nil, offerError(err, "Failed to withdraw offer")
+	}
+	return offer, nil
+}
+
+func (s *OfferService) GetOffer(ctx context.Context, req *pb.GetOfferRequest) (*pb.Offer, error) {
+	userID, err := getUserIDFromContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	offer, err := s.storage.GetOffer(req.Id)
+	if err != nil {
+		return nil, offerError(err, "Failed to get offer")
+	}
+
+	if userID != offer.BuyerId && userID != offer.SellerId {
+		return nil, status.Error(codes.PermissionDenied, "Only the buyer and seller can see an offer")
+	}
+	return offer, nil
+}
+
+func (s *OfferService) GetOffers(ctx context.Context, req *pb.OffersRequest) (*pb.OffersResponse, error) {
+	userID, err := getUserIDFromContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	filter := storage.OfferFilter{UserID: userID, ListingID: req.ListingId}
+	if req.Status != pb.OfferStatus_OFFER_STATUS_UNSPECIFIED {
+		filter.Statuses = []pb.OfferStatus{req.Status}
+	}
+
+	offers, err := s.storage.GetOffers(filter)
+	if err != nil {
+		return nil, status.Error(codes.Internal, "Failed to get offers")
+	}
+	return &pb.OffersResponse{Offers: offers}, nil
+}
+
+// validateBestOffer checks a listing's Best Offer terms against its price:
+// both thresholds are optional, in the listing's currency and below its
+// price, and offers cannot be declined at amounts that would be accepted.
+func validateBestOffer(terms *pb.BestOffer, price *pb.Money) error {
+	for _, threshold := range []*pb.Money{terms.AutoAcceptPrice, terms.AutoDeclinePrice} {
+		if threshold == nil {
+			continue
+		}
+		if err := validatePrice(threshold); err != nil {
+			return err
+		}
+		if err := money.SameCurrency(price, threshold); err != nil {
+			return status.Error(codes.InvalidArgument, err.Error())
+		}
+		if c, _ := money.Compare(threshold, price); c >= 0 {
+			return status.Error(codes.InvalidArgument, "Best Offer thresholds must be below the listing's price")
+		}
+	}
+	if terms.AutoAcceptPrice != nil && terms.AutoDeclinePrice != nil {
+		if c, _ := money.Compare(terms.AutoDeclinePrice, terms.AutoAcceptPrice); c >= 0 {
+			return status.Error(codes.InvalidArgument, "Auto-decline price must be below the auto-accept price")
+		}
+	}
+	return nil
+}
+
+func validateOfferAmount(amount *pb.Money) error {
+	if err := money.Validate(amount); err != nil {
+		return status.Error(codes.InvalidArgument, err.Error())
+	}
+	if !money.IsPositive(amount) {
+		return status.Error(codes.InvalidArgument, "Amount must be positive")
+	}
+	return nil
+}
+
+// offerError maps storage errors from offer operations to gRPC statuses.
+func offerError(err error, message string) error {
+	switch err.(type) {
+	case *storage.NotFoundError:
+		return status.Error(codes.NotFound, err.Error())
+	case *storage.OfferPermissionError:
+		return status.Error(codes.PermissionDenied, err.Error())
+	case *storage.OfferClosedError, *storage.OfferExpiredError, *storage.OffersNotAcceptedError,
+		*storage.ListingNotActiveError, *storage.OutOfStockError, *storage.AuctionListingError:
+		return status.Error(codes.FailedPrecondition, err.Error())
+	case *storage.VariationRequiredError, *storage.CounterAmountError:
+		return status.Error(codes.InvalidArgument, err.Error())
+	case *money.CurrencyMismatchError, *money.OverflowError:
+		return moneyError(err)
+	}
+	return status.Error(codes.Internal, message)
+}
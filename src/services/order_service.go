@@ -7,23 +7,31 @@ import (
 
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
 	"google.golang.org/protobuf/types/known/timestamppb"
 
 	pb "ebayclone-grpc/proto"
+	"ebayclone-grpc/src/money"
 	"ebayclone-grpc/src/storage"
 )
 
 type OrderService struct {
 	pb.UnimplementedOrderServiceServer
 	storage storage.Storage
+	rates   money.RateProvider
 }
 
-func NewOrderService(storage storage.Storage) *OrderService {
-	return &OrderService{storage: storage}
+func NewOrderService(storage storage.Storage, rates money.RateProvider) *OrderService {
+	return &OrderService{storage: storage, rates: rates}
 }
 
 func (s *OrderService) GetOrders(ctx context.Context, req *pb.OrdersRequest) (*pb.OrdersResponse, error) {
-	orders, total, err := s.storage.GetOrders(req.UserId, req.Status, req.Page, req.Limit)
+	filter := storage.OrderFilter{
+		UserID:    req.UserId,
+		ListingID: req.ListingId,
+		Status:    req.Status,
+	}
+	orders, total, err := s.storage.GetOrders(filter, req.Page, req.Limit)
 	if err != nil {
 		return nil, status.Error(codes.Internal, "Failed to get orders")
 	}
@@ -47,8 +55,17 @@ func (s *OrderService) GetOrders(ctx context.Context, req *pb.OrdersRequest) (*p
 }
 
 func (s *OrderService) CreateOrder(ctx context.Context, req *pb.OrderCreate) (*pb.Order, error) {
+	// A single item can be given directly instead of in items
+	items := req.Items
+	if req.ListingId != 0 || req.Quantity != 0 || req.VariationId != 0 {
+		if len(items) > 0 {
+			return nil, status.Error(codes.InvalidArgument, "Set either listing_id or items, not both")
+		}
+		items = []*pb.OrderItemCreate{{ListingId: req.ListingId, VariationId: req.VariationId, Quantity: req.Quantity}}
+	}
+
 	// Validate required fields
-	if req.ListingId <= 0 || req.Quantity <= 0 || req.ShippingAddress == nil {
+	if len(items) == 0 || req.ShippingAddress == nil {
 		return nil, status.Error(codes.InvalidArgument, "ListingId, quantity, and shipping address are required")
 	}
 
@@ -57,36 +74,102 @@ func (s *OrderService) CreateOrder(ctx context.Context, req *pb.OrderCreate) (*p
 	if addr.Street == "" || addr.City == "" || addr.Country == "" {
 		return nil, status.Error(codes.InvalidArgument, "Street, city, and country are required in shipping address")
 	}
-
-	// Get listing to calculate total price
-	listing, err := s.storage.GetListing(req.ListingId)
-	if err != nil {
-		if _, ok := err.(*storage.NotFoundError); ok {
-			return nil, status.Error(codes.NotFound, "Listing not found")
+	if req.Currency != "" {
+		if _, err := money.Digits(req.Currency); err != nil {
+			return nil, status.Error(codes.InvalidArgument, err.Error())
 		}
-		return nil, status.Error(codes.Internal, "Failed to get listing")
 	}
 
-	totalPrice := listing.Price * float64(req.Quantity)
-
+	userID, err := getUserIDFromContext(ctx)
+	if err != nil {
+		return nil, err
+	}
 	order := &pb.Order{
-		UserId:          getUserIDFromContext(ctx),
-		ListingId:       req.ListingId,
-		Quantity:        req.Quantity,
-		TotalPrice:      totalPrice,
-		Status:          "pending",
+		UserId:          userID,
 		ShippingAddress: req.ShippingAddress,
 		BuyerNotes:      req.BuyerNotes,
 	}
+	for _, item := range items {
+		orderItem, err := s.orderItem(item)
+		if err != nil {
+			return nil, err
+		}
+		order.Items = append(order.Items, orderItem)
+	}
+
+	// Every item must be priced in the same currency
+	if err := storage.TotalOrder(order); err != nil {
+		return nil, moneyError(err)
+	}
+
+	// Buyers paying in another currency are charged at the current rate,
+	// which the order keeps
+	if req.Currency != "" && req.Currency != order.TotalPrice.CurrencyCode {
+		converted, rate, err := convert(s.rates, order.TotalPrice, req.Currency)
+		if err != nil {
+			return nil, moneyError(err)
+		}
+		order.ConvertedTotal = converted
+		order.ExchangeRate = rate
+	}
 
 	err = s.storage.CreateOrder(order)
 	if err != nil {
+		switch err.(type) {
+		case *storage.OutOfStockError, *storage.ListingNotActiveError, *storage.AuctionListingError:
+			return nil, status.Error(codes.FailedPrecondition, err.Error())
+		case *storage.VariationRequiredError, *storage.MixedSellersError:
+			return nil, status.Error(codes.InvalidArgument, err.Error())
+		case *storage.NotFoundError:
+			return nil, status.Error(codes.NotFound, err.Error())
+		case *money.CurrencyMismatchError, *money.OverflowError:
+			return nil, moneyError(err)
+		}
 		return nil, status.Error(codes.Internal, "Failed to create order")
 	}
 
 	return order, nil
 }
 
+// orderItem validates an item to order and prices it at its listing's
+// current price.
+func (s *OrderService) orderItem(req *pb.OrderItemCreate) (*pb.OrderItem, error) {
+	if req.ListingId <= 0 || req.Quantity <= 0 {
+		return nil, status.Error(codes.InvalidArgument, "ListingId and quantity are required")
+	}
+
+	listing, err := s.storage.GetListing(req.ListingId)
+	if err != nil {
+		if _, ok := err.(*storage.NotFoundError); ok {
+			return nil, status.Error(codes.NotFound, "Listing not found")
+		}
+		return nil, status.Error(codes.Internal, "Failed to get listing")
+	}
+	if listing.Status != pb.ListingStatus_LISTING_STATUS_ACTIVE {
+		return nil, status.Error(codes.FailedPrecondition, "Listing is not active")
+	}
+
+	// Listings with variations are priced per variation
+	price := listing.Price
+	if len(listing.Variations) > 0 || req.VariationId != 0 {
+		variation := storage.FindVariation(listing, req.VariationId)
+		if variation == nil {
+			if req.VariationId == 0 {
+				return nil, status.Error(codes.InvalidArgument, "variation_id is required for listings with variations")
+			}
+			return nil, status.Error(codes.NotFound, "Variation not found")
+		}
+		price = variation.Price
+	}
+
+	return &pb.OrderItem{
+		ListingId:   req.ListingId,
+		VariationId: req.VariationId,
+		Quantity:    req.Quantity,
+		UnitPrice:   price,
+	}, nil
+}
+
 func (s *OrderService) GetOrder(ctx context.Context, req *pb.GetOrderRequest) (*pb.Order, error) {
 	order, err := s.storage.GetOrder(req.Id)
 	if err != nil {
@@ -99,6 +182,10 @@ func (s *OrderService) GetOrder(ctx context.Context, req *pb.GetOrderRequest) (*
 }
 
 func (s *OrderService) UpdateOrder(ctx context.Context, req *pb.UpdateOrderRequest) (*pb.Order, error) {
+	if req.Order == nil {
+		return nil, status.Error(codes.InvalidArgument, "Order is required")
+	}
+
 	// Get existing order
 	existing, err := s.storage.GetOrder(req.Id)
 	if err != nil {
@@ -112,45 +199,156 @@ func (s *OrderService) UpdateOrder(ctx context.Context, req *pb.UpdateOrderReque
 	updated := &pb.Order{
 		Id:              existing.Id,
 		UserId:          existing.UserId,
-		ListingId:       existing.ListingId,
-		Quantity:        existing.Quantity,
-		TotalPrice:      existing.TotalPrice,
+		SellerId:        existing.SellerId,
+		ShippingCost:    existing.ShippingCost,
+		Tax:             existing.Tax,
+		ConvertedTotal:  existing.ConvertedTotal,
+		ExchangeRate:    existing.ExchangeRate,
 		Status:          existing.Status,
 		ShippingAddress: existing.ShippingAddress,
 		BuyerNotes:      existing.BuyerNotes,
 		CreatedAt:       existing.CreatedAt,
 		CancelledAt:     existing.CancelledAt,
 		CancelReason:    existing.CancelReason,
+		OfferId:         existing.OfferId,
+		Version:         req.ExpectedVersion,
+	}
+	// Items are totalled again below, so the stored ones are copied
+	for _, item := range existing.Items {
+		updated.Items = append(updated.Items, proto.Clone(item).(*pb.OrderItem))
+	}
+
+	mask, err := newFieldMask(req.UpdateMask, "user_id", "items", "shipping_cost", "tax")
+	if err != nil {
+		return nil, err
 	}
 
-	if req.Order.UserId > 0 {
+	if mask.has("user_id", req.Order.UserId > 0) {
+		if req.Order.UserId <= 0 {
+			return nil, status.Error(codes.InvalidArgument, "UserId is required")
+		}
 		updated.UserId = req.Order.UserId
 	}
-	if req.Order.ListingId > 0 {
-		updated.ListingId = req.Order.ListingId
+	if mask.has("items", len(req.Order.Items) > 0) {
+		if len(req.Order.Items) == 0 {
+			return nil, status.Error(codes.InvalidArgument, "An order needs at least one item")
+		}
+		updated.Items = nil
+		for _, item := range req.Order.Items {
+			orderItem, err := s.updatedItem(existing, item)
+			if err != nil {
+				return nil, err
+			}
+			updated.Items = append(updated.Items, orderItem)
+		}
 	}
-	if req.Order.Quantity > 0 {
-		updated.Quantity = req.Order.Quantity
+	if mask.has("shipping_cost", req.Order.ShippingCost != nil) {
+		if err := validateCharge(req.Order.ShippingCost, existing.TotalPrice, "Shipping cost"); err != nil {
+			return nil, err
+		}
+		updated.ShippingCost = req.Order.ShippingCost
+	}
+	if mask.has("tax", req.Order.Tax != nil) {
+		if err := validateCharge(req.Order.Tax, existing.TotalPrice, "Tax"); err != nil {
+			return nil, err
+		}
+		updated.Tax = req.Order.Tax
 	}
-	if req.Order.TotalPrice > 0 {
-		updated.TotalPrice = req.Order.TotalPrice
+
+	if err := storage.TotalOrder(updated); err != nil {
+		return nil, moneyError(err)
+	}
+	if err := money.SameCurrency(existing.TotalPrice, updated.TotalPrice); err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	// The buyer's amount follows at the rate the order was placed at
+	if existing.ExchangeRate != nil {
+		converted, err := reconvert(updated.TotalPrice, existing.ExchangeRate)
+		if err != nil {
+			return nil, moneyError(err)
+		}
+		updated.ConvertedTotal = converted
 	}
 
 	updated.UpdatedAt = timestamppb.New(time.Now())
 
 	err = s.storage.UpdateOrder(req.Id, updated)
 	if err != nil {
+		switch err.(type) {
+		case *storage.OutOfStockError, *storage.ListingNotActiveError, *storage.AuctionListingError, *storage.OrderShippedError:
+			return nil, status.Error(codes.FailedPrecondition, err.Error())
+		case *storage.OrderCancelledError:
+			return nil, status.Error(codes.FailedPrecondition, "Order is cancelled")
+		case *storage.VersionConflictError:
+			return nil, status.Error(codes.Aborted, err.Error())
+		case *storage.VariationRequiredError, *storage.MixedSellersError:
+			return nil, status.Error(codes.InvalidArgument, err.Error())
+		case *storage.NotFoundError:
+			return nil, status.Error(codes.NotFound, err.Error())
+		}
 		return nil, status.Error(codes.Internal, "Failed to update order")
 	}
 
 	return updated, nil
 }
 
+// updatedItem returns an item of an updated order. Items the order already
+// had keep the price and listing revision they were ordered at unless their
+// quantity goes up; new and increased ones are priced at their listing's
+// current price. The price of an order placed from an offer was agreed for
+// its quantities, so those cannot change.
+func (s *OrderService) updatedItem(existing *pb.Order, req *pb.OrderItemCreate) (*pb.OrderItem, error) {
+	for _, item := range existing.Items {
+		if item.ListingId != req.ListingId || item.VariationId != req.VariationId {
+			continue
+		}
+		if req.Quantity <= 0 {
+			return nil, status.Error(codes.InvalidArgument, "Quantity must be positive")
+		}
+		if existing.OfferId != 0 && req.Quantity != item.Quantity {
+			return nil, status.Error(codes.FailedPrecondition, "Quantities of an order placed from an offer cannot change")
+		}
+		if req.Quantity > item.Quantity {
+			break
+		}
+		return &pb.OrderItem{
+			ListingId:       item.ListingId,
+			VariationId:     item.VariationId,
+			Quantity:        req.Quantity,
+			UnitPrice:       item.UnitPrice,
+			ListingRevision: item.ListingRevision,
+		}, nil
+	}
+	return s.orderItem(req)
+}
+
+// validateCharge checks a shipping cost or tax for an order totalling total.
+// A nil amount removes the charge.
+func validateCharge(amount *pb.Money, total *pb.Money, name string) error {
+	if amount == nil {
+		return nil
+	}
+	if err := money.Validate(amount); err != nil {
+		return status.Error(codes.InvalidArgument, err.Error())
+	}
+	if amount.MinorUnits < 0 {
+		return status.Error(codes.InvalidArgument, name+" must not be negative")
+	}
+	if err := money.SameCurrency(total, amount); err != nil {
+		return status.Error(codes.InvalidArgument, err.Error())
+	}
+	return nil
+}
+
 func (s *OrderService) DeleteOrder(ctx context.Context, req *pb.DeleteOrderRequest) (*pb.Success, error) {
 	err := s.storage.DeleteOrder(req.Id)
 	if err != nil {
-		if _, ok := err.(*storage.NotFoundError); ok {
+		switch err.(type) {
+		case *storage.NotFoundError:
 			return nil, status.Error(codes.NotFound, "Order not found")
+		case *storage.OrderShippedError:
+			return nil, status.Error(codes.FailedPrecondition, err.Error())
 		}
 		return nil, status.Error(codes.Internal, "Failed to delete order")
 	}
@@ -159,57 +357,33 @@ func (s *OrderService) DeleteOrder(ctx context.Context, req *pb.DeleteOrderReque
 }
 
 func (s *OrderService) CancelOrder(ctx context.Context, req *pb.CancelOrderRequest) (*pb.CancelOrderResponse, error) {
-	// Get existing order
-	existing, err := s.storage.GetOrder(req.Id)
-	if err != nil {
-		if _, ok := err.(*storage.NotFoundError); ok {
-			return nil, status.Error(codes.NotFound, "Order not found")
-		}
-		return nil, status.Error(codes.Internal, "Failed to get order")
-	}
-
-	// Update order status to cancelled
-	updated := &pb.Order{
-		Id:              existing.Id,
-		UserId:          existing.UserId,
-		ListingId:       existing.ListingId,
-		Quantity:        existing.Quantity,
-		TotalPrice:      existing.TotalPrice,
-		Status:          "cancelled",
-		ShippingAddress: existing.ShippingAddress,
-		BuyerNotes:      existing.BuyerNotes,
-		CreatedAt:       existing.CreatedAt,
-		UpdatedAt:       timestamppb.New(time.Now()),
-		CancelledAt:     timestamppb.New(time.Now()),
-		CancelReason:    req.CancelReason,
-	}
-
-	err = s.storage.UpdateOrder(req.Id, updated)
+	// Cancel the order and restore the listing's stock
+	cancelled, err := s.transition(ctx, req.Id, pb.OrderStatus_ORDER_STATUS_CANCELLED, req.CancelReason)
 	if err != nil {
-		return nil, status.Error(codes.Internal, "Failed to cancel order")
+		return nil, err
 	}
 
 	return &pb.CancelOrderResponse{
 		Message: "Order cancelled successfully",
-		Order:   updated,
+		Order:   cancelled,
 	}, nil
 }
 
 func (s *OrderService) UpdateOrderStatus(ctx context.Context, req *pb.UpdateOrderStatusRequest) (*pb.Order, error) {
-	// Validate status
-	validStatuses := map[string]bool{
-		"pending":   true,
-		"confirmed": true,
-		"shipped":   true,
-		"delivered": true,
+	if _, ok := pb.OrderStatus_name[int32(req.Status)]; !ok || req.Status == pb.OrderStatus_ORDER_STATUS_UNSPECIFIED {
+		return nil, status.Error(codes.InvalidArgument, "Invalid status")
 	}
+	return s.transition(ctx, req.Id, req.Status, "")
+}
 
-	if !validStatuses[req.Status] {
-		return nil, status.Error(codes.InvalidArgument, "Invalid status. Must be: pending, confirmed, shipped, or delivered")
+// transition moves an order to a new status as the caller, who acts as its
+// seller when the seller may make the move and as its buyer otherwise.
+func (s *OrderService) transition(ctx context.Context, id int32, to pb.OrderStatus, reason string) (*pb.Order, error) {
+	userID, err := getUserIDFromContext(ctx)
+	if err != nil {
+		return nil, err
 	}
-
-	// Get existing order
-	existing, err := s.storage.GetOrder(req.Id)
+	existing, err := s.storage.GetOrder(id)
 	if err != nil {
 		if _, ok := err.(*storage.NotFoundError); ok {
 			return nil, status.Error(codes.NotFound, "Order not found")
@@ -217,26 +391,22 @@ func (s *OrderService) UpdateOrderStatus(ctx context.Context, req *pb.UpdateOrde
 		return nil, status.Error(codes.Internal, "Failed to get order")
 	}
 
-	// Update order status
-	updated := &pb.Order{
-		Id:              existing.Id,
-		UserId:          existing.UserId,
-		ListingId:       existing.ListingId,
-		Quantity:        existing.Quantity,
-		TotalPrice:      existing.TotalPrice,
-		Status:          req.Status,
-		ShippingAddress: existing.ShippingAddress,
-		BuyerNotes:      existing.BuyerNotes,
-		CreatedAt:       existing.CreatedAt,
-		UpdatedAt:       timestamppb.New(time.Now()),
-		CancelledAt:     existing.CancelledAt,
-		CancelReason:    existing.CancelReason,
+	actor := pb.OrderActor_ORDER_ACTOR_BUYER
+	if userID == existing.SellerId && (userID != existing.UserId || storage.MayTransitionOrder(existing.Status, to, pb.OrderActor_ORDER_ACTOR_SELLER)) {
+		actor = pb.OrderActor_ORDER_ACTOR_SELLER
 	}
 
-	err = s.storage.UpdateOrder(req.Id, updated)
+	updated, err := s.storage.TransitionOrder(id, to, actor, userID, reason, time.Now())
 	if err != nil {
+		switch err.(type) {
+		case *storage.NotFoundError:
+			return nil, status.Error(codes.NotFound, "Order not found")
+		case *storage.InvalidTransitionError:
+			return nil, status.Error(codes.FailedPrecondition, err.Error())
+		case *storage.OrderPermissionError:
+			return nil, status.Error(codes.PermissionDenied, err.Error())
+		}
 		return nil, status.Error(codes.Internal, "Failed to update order status")
 	}
-
 	return updated, nil
 }
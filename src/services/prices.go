@@ -0,0 +1,73 @@
+package services
+
+import (
+	"math/big"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	pb "ebayclone-grpc/proto"
+	"ebayclone-grpc/src/money"
+)
+
+// validatePrice checks that price is a positive amount in a supported
+// currency.
+func validatePrice(price *pb.Money) error {
+	if price == nil {
+		return status.Error(codes.InvalidArgument, "Price is required")
+	}
+	if err := money.Validate(price); err != nil {
+		return status.Error(codes.InvalidArgument, err.Error())
+	}
+	if !money.IsPositive(price) {
+		return status.Error(codes.InvalidArgument, "Price must be positive")
+	}
+	return nil
+}
+
+// convert returns amount in currency and the rate it was converted at. The
+// rate is nil when amount is already in currency.
+func convert(rates money.RateProvider, amount *pb.Money, currency string) (*pb.Money, *pb.ExchangeRate, error) {
+	if amount.CurrencyCode == currency {
+		return amount, nil, nil
+	}
+	if rates == nil {
+		return nil, nil, &money.NoRateError{From: amount.CurrencyCode, To: currency}
+	}
+	rate, err := rates.Rate(amount.CurrencyCode, currency)
+	if err != nil {
+		return nil, nil, err
+	}
+	converted, err := money.Convert(amount, currency, rate)
+	if err != nil {
+		return nil, nil, err
+	}
+	return converted, &pb.ExchangeRate{
+		FromCurrency: amount.CurrencyCode,
+		ToCurrency:   currency,
+		Rate:         money.FormatRate(rate),
+	}, nil
+}
+
+// reconvert converts amount again at a previously recorded rate.
+func reconvert(amount *pb.Money, recorded *pb.ExchangeRate) (*pb.Money, error) {
+	rate, ok := new(big.Rat).SetString(recorded.Rate)
+	if !ok {
+		return nil, status.Error(codes.Internal, "Invalid recorded exchange rate")
+	}
+	return money.Convert(amount, recorded.ToCurrency, rate)
+}
+
+// moneyError maps errors from money arithmetic to gRPC errors.
+func moneyError(err error) error {
+	switch err.(type) {
+	case *money.UnknownCurrencyError, *money.CurrencyMismatchError, *money.OverflowError:
+		return status.Error(codes.InvalidArgument, err.Error())
+	case *money.NoRateError:
+		return status.Error(codes.FailedPrecondition, err.Error())
+	}
+	if _, ok := status.FromError(err); ok {
+		return err
+	}
+	return status.Error(codes.Internal, "Failed to calculate price")
+}
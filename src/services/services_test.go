@@ -1,21 +1,68 @@
 package services
 
 import (
+	"bytes"
 	"context"
+	"image"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"sync"
 	"testing"
+	"time"
 
+	"github.com/golang-jwt/jwt/v5"
+	"google.golang.org/grpc"
 	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
 	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/durationpb"
 	"google.golang.org/protobuf/types/known/emptypb"
+	"google.golang.org/protobuf/types/known/fieldmaskpb"
+	"google.golang.org/protobuf/types/known/timestamppb"
 
 	pb "ebayclone-grpc/proto"
+	"ebayclone-grpc/src/imageproc"
+	"ebayclone-grpc/src/imagestore"
+	"ebayclone-grpc/src/money"
 	"ebayclone-grpc/src/storage"
 )
 
+// newTestImages returns an image store in a temporary directory and an
+// image pipeline that is stopped when the test ends.
+func newTestImages(t *testing.T, store storage.Storage) (imagestore.Store, *imageproc.Pipeline) {
+	images, err := imagestore.NewFileStore(t.TempDir())
+	if err != nil {
+		t.Fatalf("NewFileStore failed: %v", err)
+	}
+	pipeline := imageproc.NewPipeline(store, images, 2, 10)
+	pipeline.Start()
+	t.Cleanup(pipeline.Close)
+	return images, pipeline
+}
+
+func usd(cents int64) *pb.Money {
+	return money.New("USD", cents)
+}
+
+// newTestRates returns a fixed table: 1 USD = 0.9 EUR = 0.8 GBP = 150 JPY.
+func newTestRates(t *testing.T) *money.StaticRates {
+	rates, err := money.NewStaticRates("USD", map[string]string{"EUR": "0.9", "GBP": "0.8", "JPY": "150"})
+	if err != nil {
+		t.Fatalf("NewStaticRates failed: %v", err)
+	}
+	return rates
+}
+
+func newTestListingService(t *testing.T, store storage.Storage) *ListingService {
+	images, pipeline := newTestImages(t, store)
+	return NewListingService(store, images, pipeline, newTestRates(t))
+}
+
 func TestUserService(t *testing.T) {
 	store := storage.NewInMemoryStorage()
 	service := NewUserService(store)
-	ctx := context.Background()
+	ctx := userContext(t, 1)
 
 	// Test CreateUser
 	user, err := service.CreateUser(ctx, &pb.UserCreate{
@@ -72,7 +119,7 @@ func TestSessionService(t *testing.T) {
 	store := storage.NewInMemoryStorage()
 	userService := NewUserService(store)
 	sessionService := NewSessionService(store)
-	ctx := context.Background()
+	ctx := userContext(t, 1)
 
 	// Create a user first
 	_, err := userService.CreateUser(ctx, &pb.UserCreate{
@@ -105,6 +152,39 @@ func TestSessionService(t *testing.T) {
 		t.Errorf("Expected Unauthenticated error for wrong password, got: %v", err)
 	}
 
+	// Test that the token authenticates requests
+	cartService := NewCartService(store)
+	withToken := func(token string) context.Context {
+		return metadata.NewIncomingContext(context.Background(), metadata.Pairs("authorization", "Bearer "+token))
+	}
+	cart, err := cartService.GetCart(withToken(loginResp.Token), &emptypb.Empty{})
+	if err != nil {
+		t.Fatalf("GetCart failed: %v", err)
+	}
+	if cart.UserId != 1 {
+		t.Errorf("Expected the cart of user 1, got user %d", cart.UserId)
+	}
+
+	// Test requests without a valid token
+	sign := func(secret []byte, claims jwt.MapClaims) string {
+		token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(secret)
+		if err != nil {
+			t.Fatalf("SignedString failed: %v", err)
+		}
+		return token
+	}
+	for name, ctx := range map[string]context.Context{
+		"missing token":   context.Background(),
+		"malformed token": withToken("not-a-token"),
+		"forged token":    withToken(sign([]byte("guessed-secret"), jwt.MapClaims{"user_id": 1})),
+		"missing user id": withToken(sign(jwtSecret, jwt.MapClaims{"email": "test@example.com"})),
+		"expired token":   withToken(sign(jwtSecret, jwt.MapClaims{"user_id": 1, "exp": time.Now().Add(-time.Hour).Unix()})),
+	} {
+		if _, err := cartService.GetCart(ctx, &emptypb.Empty{}); status.Code(err) != codes.Unauthenticated {
+			t.Errorf("Expected Unauthenticated error for %s, got: %v", name, err)
+		}
+	}
+
 	// Test Logout
 	_, err = sessionService.Logout(ctx, &emptypb.Empty{})
 	if err != nil {
@@ -114,14 +194,19 @@ func TestSessionService(t *testing.T) {
 
 func TestListingService(t *testing.T) {
 	store := storage.NewInMemoryStorage()
-	service := NewListingService(store)
-	ctx := context.Background()
+	service := newTestListingService(t, store)
+	ctx := userContext(t, 1)
+
+	_, err := NewCategoryService(store).CreateCategory(ctx, &pb.CategoryCreate{Slug: "electronics", Name: "Electronics"})
+	if err != nil {
+		t.Fatalf("Failed to create category: %v", err)
+	}
 
 	// Test CreateListing
 	listing, err := service.CreateListing(ctx, &pb.ListingCreate{
 		Title:       "iPhone 13",
 		Description: "Great phone",
-		Price:       999.99,
+		Price:       usd(99999),
 		Category:    "electronics",
 		Condition:   "new",
 		Location:    "New York",
@@ -129,7 +214,7 @@ func TestListingService(t *testing.T) {
 	if err != nil {
 		t.Fatalf("CreateListing failed: %v", err)
 	}
-	if listing.Title != "iPhone 13" || listing.Price != 999.99 {
+	if listing.Title != "iPhone 13" || listing.Price.MinorUnits != 99999 {
 		t.Errorf("Listing data mismatch: got %+v", listing)
 	}
 
@@ -162,17 +247,23 @@ func TestListingService(t *testing.T) {
 
 func TestOrderService(t *testing.T) {
 	store := storage.NewInMemoryStorage()
-	listingService := NewListingService(store)
-	orderService := NewOrderService(store)
-	ctx := context.Background()
+	listingService := newTestListingService(t, store)
+	orderService := NewOrderService(store, newTestRates(t))
+	ctx := userContext(t, 1)
+
+	_, err := NewCategoryService(store).CreateCategory(ctx, &pb.CategoryCreate{Slug: "test", Name: "Test"})
+	if err != nil {
+		t.Fatalf("Failed to create category: %v", err)
+	}
 
 	// Create a listing first
 	listing, err := listingService.CreateListing(ctx, &pb.ListingCreate{
 		Title:       "Test Product",
 		Description: "Test description",
-		Price:       100.0,
+		Price:       usd(10000),
 		Category:    "test",
 		Condition:   "new",
+		Quantity:    5,
 	})
 	if err != nil {
 		t.Fatalf("Failed to create listing: %v", err)
@@ -192,8 +283,8 @@ func TestOrderService(t *testing.T) {
 	if err != nil {
 		t.Fatalf("CreateOrder failed: %v", err)
 	}
-	if order.TotalPrice != 200.0 { // 100.0 * 2
-		t.Errorf("Expected total price 200.0, got %f", order.TotalPrice)
+	if order.TotalPrice.MinorUnits != 20000 || order.TotalPrice.CurrencyCode != "USD" { // 100.00 * 2
+		t.Errorf("Expected total price 200.00 USD, got %v", order.TotalPrice)
 	}
 
 	// Test GetOrder
@@ -208,13 +299,13 @@ func TestOrderService(t *testing.T) {
 	// Test UpdateOrderStatus
 	updatedOrder, err := orderService.UpdateOrderStatus(ctx, &pb.UpdateOrderStatusRequest{
 		Id:     order.Id,
-		Status: "shipped",
+		Status: pb.OrderStatus_ORDER_STATUS_CONFIRMED,
 	})
 	if err != nil {
 		t.Fatalf("UpdateOrderStatus failed: %v", err)
 	}
-	if updatedOrder.Status != "shipped" {
-		t.Errorf("Expected status 'shipped', got '%s'", updatedOrder.Status)
+	if updatedOrder.Status != pb.OrderStatus_ORDER_STATUS_CONFIRMED {
+		t.Errorf("Expected status CONFIRMED, got %v", updatedOrder.Status)
 	}
 
 	// Test CancelOrder
@@ -225,8 +316,8 @@ func TestOrderService(t *testing.T) {
 	if err != nil {
 		t.Fatalf("CancelOrder failed: %v", err)
 	}
-	if cancelResp.Order.Status != "cancelled" {
-		t.Errorf("Expected cancelled status, got '%s'", cancelResp.Order.Status)
+	if cancelResp.Order.Status != pb.OrderStatus_ORDER_STATUS_CANCELLED {
+		t.Errorf("Expected cancelled status, got %v", cancelResp.Order.Status)
 	}
 
 	// Test GetOrders
@@ -244,9 +335,3281 @@ func TestOrderService(t *testing.T) {
 	// Test error cases
 	_, err = orderService.UpdateOrderStatus(ctx, &pb.UpdateOrderStatusRequest{
 		Id:     order.Id,
-		Status: "invalid_status",
+		Status: pb.OrderStatus(99),
 	})
 	if status.Code(err) != codes.InvalidArgument {
 		t.Errorf("Expected InvalidArgument error for invalid status, got: %v", err)
 	}
 }
+
+func TestListingServiceGeoSearch(t *testing.T) {
+	store := storage.NewInMemoryStorage()
+	service := newTestListingService(t, store)
+	ctx := userContext(t, 1)
+
+	places := []struct {
+		title string
+		point *pb.GeoPoint
+	}{
+		{"Bike Manhattan", &pb.GeoPoint{Latitude: 40.7831, Longitude: -73.9712}},
+		{"Bike Brooklyn", &pb.GeoPoint{Latitude: 40.6782, Longitude: -73.9442}},
+		{"Bike Boston", &pb.GeoPoint{Latitude: 42.3601, Longitude: -71.0589}},
+		{"Bike Unknown", nil},
+	}
+	ids := make(map[string]int32)
+	for _, place := range places {
+		listing, err := service.CreateListing(ctx, &pb.ListingCreate{
+			Title:       place.title,
+			Description: "Road bike",
+			Price:       usd(30000),
+			Location:    place.title,
+			Coordinates: place.point,
+		})
+		if err != nil {
+			t.Fatalf("CreateListing failed: %v", err)
+		}
+		ids[place.title] = listing.Id
+	}
+
+	// Test radius search around Times Square
+	timesSquare := &pb.GeoPoint{Latitude: 40.7580, Longitude: -73.9855}
+	resp, err := service.GetListings(ctx, &pb.ListingsRequest{
+		Near:     timesSquare,
+		RadiusKm: 25,
+		SortBy:   "distance",
+	})
+	if err != nil {
+		t.Fatalf("GetListings failed: %v", err)
+	}
+	if len(resp.Listings) != 2 {
+		t.Fatalf("Expected 2 listings within 25km, got %d", len(resp.Listings))
+	}
+	if resp.Listings[0].Id != ids["Bike Manhattan"] || resp.Listings[1].Id != ids["Bike Brooklyn"] {
+		t.Errorf("Expected Manhattan then Brooklyn, got %q then %q", resp.Listings[0].Title, resp.Listings[1].Title)
+	}
+	if d := resp.DistancesKm[ids["Bike Manhattan"]]; d < 2 || d > 4 {
+		t.Errorf("Expected Manhattan about 3km away, got %f", d)
+	}
+
+	// Test sorting without a radius keeps unlocated listings last
+	resp, err = service.GetListings(ctx, &pb.ListingsRequest{Near: timesSquare, SortBy: "distance"})
+	if err != nil {
+		t.Fatalf("GetListings failed: %v", err)
+	}
+	if len(resp.Listings) != 4 || resp.Listings[2].Id != ids["Bike Boston"] || resp.Listings[3].Id != ids["Bike Unknown"] {
+		t.Errorf("Unexpected distance ordering: %v", resp.Listings)
+	}
+	if _, ok := resp.DistancesKm[ids["Bike Unknown"]]; ok {
+		t.Error("Listing without coordinates should not have a distance")
+	}
+
+	// Test moving a listing updates the index
+	_, err = service.UpdateListing(ctx, &pb.UpdateListingRequest{
+		Id:      ids["Bike Boston"],
+		Listing: &pb.ListingUpdate{Coordinates: &pb.GeoPoint{Latitude: 40.7484, Longitude: -73.9857}},
+	})
+	if err != nil {
+		t.Fatalf("UpdateListing failed: %v", err)
+	}
+	resp, err = service.GetListings(ctx, &pb.ListingsRequest{Near: timesSquare, RadiusKm: 5})
+	if err != nil {
+		t.Fatalf("GetListings failed: %v", err)
+	}
+	if len(resp.Listings) != 2 {
+		t.Errorf("Expected moved listing within 5km, got %d listings", len(resp.Listings))
+	}
+
+	// Test listings on the antimeridian are found from either side of it
+	fiji, err := service.CreateListing(ctx, &pb.ListingCreate{
+		Title:       "Bike Taveuni",
+		Description: "Road bike",
+		Price:       usd(30000),
+		Coordinates: &pb.GeoPoint{Latitude: -16.8, Longitude: 180},
+	})
+	if err != nil {
+		t.Fatalf("CreateListing failed: %v", err)
+	}
+	for _, lng := range []float64{179.9, -179.9} {
+		resp, err = service.GetListings(ctx, &pb.ListingsRequest{Near: &pb.GeoPoint{Latitude: -16.8, Longitude: lng}, RadiusKm: 25})
+		if err != nil {
+			t.Fatalf("GetListings failed: %v", err)
+		}
+		if len(resp.Listings) != 1 || resp.Listings[0].Id != fiji.Id {
+			t.Errorf("Expected the listing on the antimeridian near longitude %v, got %v", lng, resp.Listings)
+		}
+	}
+
+	// Test error cases
+	_, err = service.GetListings(ctx, &pb.ListingsRequest{RadiusKm: 10})
+	if status.Code(err) != codes.InvalidArgument {
+		t.Errorf("Expected InvalidArgument error for radius without near, got: %v", err)
+	}
+	_, err = service.GetListings(ctx, &pb.ListingsRequest{SortBy: "distance"})
+	if status.Code(err) != codes.InvalidArgument {
+		t.Errorf("Expected InvalidArgument error for distance sort without near, got: %v", err)
+	}
+	_, err = service.CreateListing(ctx, &pb.ListingCreate{
+		Title:       "Bad",
+		Description: "Bad coordinates",
+		Price:       usd(100),
+		Coordinates: &pb.GeoPoint{Latitude: 91},
+	})
+	if status.Code(err) != codes.InvalidArgument {
+		t.Errorf("Expected InvalidArgument error for invalid coordinates, got: %v", err)
+	}
+}
+
+func TestCategoryService(t *testing.T) {
+	store := storage.NewInMemoryStorage()
+	service := NewCategoryService(store)
+	listingService := newTestListingService(t, store)
+	ctx := userContext(t, 1)
+
+	// Test CreateCategory builds a tree
+	electronics, err := service.CreateCategory(ctx, &pb.CategoryCreate{Slug: "electronics", Name: "Electronics"})
+	if err != nil {
+		t.Fatalf("CreateCategory failed: %v", err)
+	}
+	phones, err := service.CreateCategory(ctx, &pb.CategoryCreate{ParentId: electronics.Id, Slug: "phones", Name: "Phones"})
+	if err != nil {
+		t.Fatalf("CreateCategory failed: %v", err)
+	}
+	smartphones, err := service.CreateCategory(ctx, &pb.CategoryCreate{ParentId: phones.Id, Slug: "smartphones", Name: "Smartphones"})
+	if err != nil {
+		t.Fatalf("CreateCategory failed: %v", err)
+	}
+	books, err := service.CreateCategory(ctx, &pb.CategoryCreate{Slug: "books", Name: "Books"})
+	if err != nil {
+		t.Fatalf("CreateCategory failed: %v", err)
+	}
+
+	// Test GetCategories
+	topLevel, err := service.GetCategories(ctx, &pb.CategoriesRequest{})
+	if err != nil {
+		t.Fatalf("GetCategories failed: %v", err)
+	}
+	if len(topLevel.Categories) != 2 {
+		t.Errorf("Expected 2 top-level categories, got %d", len(topLevel.Categories))
+	}
+	subtree, err := service.GetCategories(ctx, &pb.CategoriesRequest{ParentId: electronics.Id, IncludeDescendants: true})
+	if err != nil {
+		t.Fatalf("GetCategories failed: %v", err)
+	}
+	if len(subtree.Categories) != 2 {
+		t.Errorf("Expected 2 descendants of electronics, got %d", len(subtree.Categories))
+	}
+
+	// Test listings must use known categories
+	_, err = listingService.CreateListing(ctx, &pb.ListingCreate{
+		Title:       "Mystery item",
+		Description: "Unknown category",
+		Price:       usd(1000),
+		Category:    "gadgets",
+	})
+	if status.Code(err) != codes.InvalidArgument {
+		t.Errorf("Expected InvalidArgument error for unknown category, got: %v", err)
+	}
+
+	for _, listing := range []*pb.ListingCreate{
+		{Title: "Pixel", Description: "Phone", Price: usd(50000), Category: "smartphones"},
+		{Title: "Headphones", Description: "Audio", Price: usd(10000), Category: "electronics"},
+		{Title: "Novel", Description: "Paperback", Price: usd(1000), Category: "books"},
+	} {
+		if _, err := listingService.CreateListing(ctx, listing); err != nil {
+			t.Fatalf("CreateListing failed: %v", err)
+		}
+	}
+
+	// Test searching a category includes its descendants
+	resp, err := listingService.GetListings(ctx, &pb.ListingsRequest{Category: "electronics"})
+	if err != nil {
+		t.Fatalf("GetListings failed: %v", err)
+	}
+	if len(resp.Listings) != 2 {
+		t.Errorf("Expected 2 listings under electronics, got %d", len(resp.Listings))
+	}
+	resp, err = listingService.GetListings(ctx, &pb.ListingsRequest{Category: "phones"})
+	if err != nil {
+		t.Fatalf("GetListings failed: %v", err)
+	}
+	if len(resp.Listings) != 1 || resp.Listings[0].Title != "Pixel" {
+		t.Errorf("Expected only Pixel under phones, got %v", resp.Listings)
+	}
+
+	// Test UpdateCategory rejects cycles
+	_, err = service.UpdateCategory(ctx, &pb.UpdateCategoryRequest{
+		Id:       electronics.Id,
+		Category: &pb.CategoryUpdate{ParentId: smartphones.Id},
+	})
+	if status.Code(err) != codes.InvalidArgument {
+		t.Errorf("Expected InvalidArgument error for cyclic parent, got: %v", err)
+	}
+
+	// Test moving a subtree changes search results
+	_, err = service.UpdateCategory(ctx, &pb.UpdateCategoryRequest{
+		Id:       phones.Id,
+		Category: &pb.CategoryUpdate{ParentId: books.Id},
+	})
+	if err != nil {
+		t.Fatalf("UpdateCategory failed: %v", err)
+	}
+	resp, err = listingService.GetListings(ctx, &pb.ListingsRequest{Category: "books"})
+	if err != nil {
+		t.Fatalf("GetListings failed: %v", err)
+	}
+	if len(resp.Listings) != 2 {
+		t.Errorf("Expected 2 listings under books after move, got %d", len(resp.Listings))
+	}
+
+	// Test a masked parent_id of 0 moves a category back to the top level
+	moved, err := service.UpdateCategory(ctx, &pb.UpdateCategoryRequest{
+		Id:         phones.Id,
+		Category:   &pb.CategoryUpdate{},
+		UpdateMask: &fieldmaskpb.FieldMask{Paths: []string{"parent_id"}},
+	})
+	if err != nil {
+		t.Fatalf("UpdateCategory failed: %v", err)
+	}
+	if moved.ParentId != 0 || moved.Name != "Phones" {
+		t.Errorf("Expected Phones at the top level, got %v", moved)
+	}
+	roots, err := service.GetCategories(ctx, &pb.CategoriesRequest{})
+	if err != nil {
+		t.Fatalf("GetCategories failed: %v", err)
+	}
+	if len(roots.Categories) != 3 {
+		t.Errorf("Expected 3 top-level categories after move, got %d", len(roots.Categories))
+	}
+	_, err = service.UpdateCategory(ctx, &pb.UpdateCategoryRequest{
+		Id:         phones.Id,
+		Category:   &pb.CategoryUpdate{},
+		UpdateMask: &fieldmaskpb.FieldMask{Paths: []string{"name"}},
+	})
+	if status.Code(err) != codes.InvalidArgument {
+		t.Errorf("Expected InvalidArgument error for clearing the name, got: %v", err)
+	}
+	_, err = service.UpdateCategory(ctx, &pb.UpdateCategoryRequest{
+		Id:         phones.Id,
+		Category:   &pb.CategoryUpdate{},
+		UpdateMask: &fieldmaskpb.FieldMask{Paths: []string{"slug"}},
+	})
+	if status.Code(err) != codes.InvalidArgument {
+		t.Errorf("Expected InvalidArgument error for unknown mask path, got: %v", err)
+	}
+	_, err = service.UpdateCategory(ctx, &pb.UpdateCategoryRequest{Id: phones.Id})
+	if status.Code(err) != codes.InvalidArgument {
+		t.Errorf("Expected InvalidArgument error for missing category, got: %v", err)
+	}
+
+	// Test error cases
+	_, err = service.CreateCategory(ctx, &pb.CategoryCreate{Slug: "phones", Name: "Duplicate"})
+	if status.Code(err) != codes.AlreadyExists {
+		t.Errorf("Expected AlreadyExists error for duplicate slug, got: %v", err)
+	}
+	_, err = service.CreateCategory(ctx, &pb.CategoryCreate{Slug: "Bad Slug", Name: "Bad"})
+	if status.Code(err) != codes.InvalidArgument {
+		t.Errorf("Expected InvalidArgument error for invalid slug, got: %v", err)
+	}
+	_, err = service.DeleteCategory(ctx, &pb.DeleteCategoryRequest{Id: phones.Id})
+	if status.Code(err) != codes.FailedPrecondition {
+		t.Errorf("Expected FailedPrecondition error for category with children, got: %v", err)
+	}
+	_, err = service.DeleteCategory(ctx, &pb.DeleteCategoryRequest{Id: smartphones.Id})
+	if status.Code(err) != codes.FailedPrecondition {
+		t.Errorf("Expected FailedPrecondition error for category with listings, got: %v", err)
+	}
+}
+
+func TestListingServiceAttributes(t *testing.T) {
+	store := storage.NewInMemoryStorage()
+	categoryService := NewCategoryService(store)
+	service := newTestListingService(t, store)
+	ctx := userContext(t, 1)
+
+	phones, err := categoryService.CreateCategory(ctx, &pb.CategoryCreate{
+		Slug: "phones",
+		Name: "Phones",
+		Attributes: []*pb.AttributeSchema{
+			{Name: "brand", Type: pb.AttributeType_ATTRIBUTE_TYPE_STRING, Required: true},
+			{Name: "storage_gb", Type: pb.AttributeType_ATTRIBUTE_TYPE_NUMBER},
+			{Name: "color", Type: pb.AttributeType_ATTRIBUTE_TYPE_STRING, AllowedValues: []string{"black", "white"}},
+		},
+	})
+	if err != nil {
+		t.Fatalf("CreateCategory failed: %v", err)
+	}
+	_, err = categoryService.CreateCategory(ctx, &pb.CategoryCreate{
+		ParentId:   phones.Id,
+		Slug:       "refurbished-phones",
+		Name:       "Refurbished Phones",
+		Attributes: []*pb.AttributeSchema{{Name: "certified", Type: pb.AttributeType_ATTRIBUTE_TYPE_BOOLEAN}},
+	})
+	if err != nil {
+		t.Fatalf("CreateCategory failed: %v", err)
+	}
+
+	stringAttr := func(name, value string) *pb.AttributeValue {
+		return &pb.AttributeValue{Name: name, Value: &pb.AttributeValue_StringValue{StringValue: value}}
+	}
+	numberAttr := func(name string, value float64) *pb.AttributeValue {
+		return &pb.AttributeValue{Name: name, Value: &pb.AttributeValue_NumberValue{NumberValue: value}}
+	}
+
+	// Test CreateListing with valid attributes, including inherited ones
+	listing, err := service.CreateListing(ctx, &pb.ListingCreate{
+		Title:       "Pixel 8",
+		Description: "Phone",
+		Price:       usd(50000),
+		Category:    "phones",
+		Attributes:  []*pb.AttributeValue{stringAttr("brand", "Google"), numberAttr("storage_gb", 128), stringAttr("color", "black")},
+	})
+	if err != nil {
+		t.Fatalf("CreateListing failed: %v", err)
+	}
+	_, err = service.CreateListing(ctx, &pb.ListingCreate{
+		Title:       "iPhone 12",
+		Description: "Refurbished phone",
+		Price:       usd(30000),
+		Category:    "refurbished-phones",
+		Attributes: []*pb.AttributeValue{
+			stringAttr("brand", "Apple"),
+			numberAttr("storage_gb", 64),
+			{Name: "certified", Value: &pb.AttributeValue_BoolValue{BoolValue: true}},
+		},
+	})
+	if err != nil {
+		t.Fatalf("CreateListing with inherited attributes failed: %v", err)
+	}
+
+	// Test attribute filters
+	min := 100.0
+	resp, err := service.GetListings(ctx, &pb.ListingsRequest{
+		Attributes: []*pb.AttributeFilter{{Name: "storage_gb", Min: &min}},
+	})
+	if err != nil {
+		t.Fatalf("GetListings failed: %v", err)
+	}
+	if len(resp.Listings) != 1 || resp.Listings[0].Id != listing.Id {
+		t.Errorf("Expected only Pixel 8 with storage >= 100, got %v", resp.Listings)
+	}
+	resp, err = service.GetListings(ctx, &pb.ListingsRequest{
+		Category:   "phones",
+		Attributes: []*pb.AttributeFilter{{Name: "brand", Values: []string{"apple", "samsung"}}},
+	})
+	if err != nil {
+		t.Fatalf("GetListings failed: %v", err)
+	}
+	if len(resp.Listings) != 1 || resp.Listings[0].Title != "iPhone 12" {
+		t.Errorf("Expected only iPhone 12 for brand filter, got %v", resp.Listings)
+	}
+	resp, err = service.GetListings(ctx, &pb.ListingsRequest{
+		Attributes: []*pb.AttributeFilter{{Name: "certified", Values: []string{"true"}}},
+	})
+	if err != nil {
+		t.Fatalf("GetListings failed: %v", err)
+	}
+	if len(resp.Listings) != 1 {
+		t.Errorf("Expected 1 certified listing, got %d", len(resp.Listings))
+	}
+
+	// Test validation errors
+	invalid := map[string][]*pb.AttributeValue{
+		"missing required": {numberAttr("storage_gb", 64)},
+		"wrong type":       {stringAttr("brand", "Google"), stringAttr("storage_gb", "64")},
+		"not allowed":      {stringAttr("brand", "Google"), stringAttr("color", "purple")},
+		"unknown":          {stringAttr("brand", "Google"), stringAttr("size", "M")},
+		"duplicate":        {stringAttr("brand", "Google"), stringAttr("brand", "Apple")},
+	}
+	for name, attributes := range invalid {
+		_, err = service.CreateListing(ctx, &pb.ListingCreate{
+			Title:       "Phone",
+			Description: name,
+			Price:       usd(10000),
+			Category:    "phones",
+			Attributes:  attributes,
+		})
+		if status.Code(err) != codes.InvalidArgument {
+			t.Errorf("Expected InvalidArgument error for %s attribute, got: %v", name, err)
+		}
+	}
+
+	// Test UpdateListing revalidates attributes
+	_, err = service.UpdateListing(ctx, &pb.UpdateListingRequest{
+		Id:      listing.Id,
+		Listing: &pb.ListingUpdate{Attributes: []*pb.AttributeValue{numberAttr("storage_gb", 256)}},
+	})
+	if status.Code(err) != codes.InvalidArgument {
+		t.Errorf("Expected InvalidArgument error for update without required attribute, got: %v", err)
+	}
+	updated, err := service.UpdateListing(ctx, &pb.UpdateListingRequest{
+		Id:      listing.Id,
+		Listing: &pb.ListingUpdate{Attributes: []*pb.AttributeValue{stringAttr("brand", "Google"), numberAttr("storage_gb", 256)}},
+	})
+	if err != nil {
+		t.Fatalf("UpdateListing failed: %v", err)
+	}
+	if len(updated.Attributes) != 2 {
+		t.Errorf("Expected 2 attributes after update, got %d", len(updated.Attributes))
+	}
+
+	// Test invalid schemas
+	_, err = categoryService.CreateCategory(ctx, &pb.CategoryCreate{
+		Slug:       "clothes",
+		Name:       "Clothes",
+		Attributes: []*pb.AttributeSchema{{Name: "size", Type: pb.AttributeType_ATTRIBUTE_TYPE_NUMBER, AllowedValues: []string{"S"}}},
+	})
+	if status.Code(err) != codes.InvalidArgument {
+		t.Errorf("Expected InvalidArgument error for allowed values on number attribute, got: %v", err)
+	}
+}
+
+func TestOrderServiceStock(t *testing.T) {
+	store := storage.NewInMemoryStorage()
+	listingService := newTestListingService(t, store)
+	orderService := NewOrderService(store, newTestRates(t))
+	ctx := userContext(t, 1)
+
+	listing, err := listingService.CreateListing(ctx, &pb.ListingCreate{
+		Title:       "Limited sneakers",
+		Description: "Only three pairs",
+		Price:       usd(12000),
+		Quantity:    3,
+	})
+	if err != nil {
+		t.Fatalf("CreateListing failed: %v", err)
+	}
+
+	address := &pb.Address{Street: "1 Main St", City: "Springfield", Country: "USA"}
+
+	// Test ordering more than available
+	_, err = orderService.CreateOrder(ctx, &pb.OrderCreate{ListingId: listing.Id, Quantity: 4, ShippingAddress: address})
+	if status.Code(err) != codes.FailedPrecondition {
+		t.Errorf("Expected FailedPrecondition error for oversized order, got: %v", err)
+	}
+
+	// Test ordering the remaining stock sells the listing out
+	first, err := orderService.CreateOrder(ctx, &pb.OrderCreate{ListingId: listing.Id, Quantity: 1, ShippingAddress: address})
+	if err != nil {
+		t.Fatalf("CreateOrder failed: %v", err)
+	}
+	_, err = orderService.CreateOrder(ctx, &pb.OrderCreate{ListingId: listing.Id, Quantity: 2, ShippingAddress: address})
+	if err != nil {
+		t.Fatalf("CreateOrder failed: %v", err)
+	}
+	soldOut, err := listingService.GetListing(ctx, &pb.GetListingRequest{Id: listing.Id})
+	if err != nil {
+		t.Fatalf("GetListing failed: %v", err)
+	}
+	if soldOut.Quantity != 0 || soldOut.Status != pb.ListingStatus_LISTING_STATUS_SOLD {
+		t.Errorf("Expected sold out listing, got quantity %d status %v", soldOut.Quantity, soldOut.Status)
+	}
+	_, err = orderService.CreateOrder(ctx, &pb.OrderCreate{ListingId: listing.Id, Quantity: 1, ShippingAddress: address})
+	if status.Code(err) != codes.FailedPrecondition {
+		t.Errorf("Expected FailedPrecondition error for sold out listing, got: %v", err)
+	}
+
+	// Test editing the listing does not touch reserved stock
+	_, err = listingService.UpdateListing(ctx, &pb.UpdateListingRequest{
+		Id:      listing.Id,
+		Listing: &pb.ListingUpdate{Title: "Limited sneakers (sold out)"},
+	})
+	if err != nil {
+		t.Fatalf("UpdateListing failed: %v", err)
+	}
+
+	// Test cancelling restores stock exactly once
+	_, err = orderService.CancelOrder(ctx, &pb.CancelOrderRequest{Id: first.Id, CancelReason: "Wrong size"})
+	if err != nil {
+		t.Fatalf("CancelOrder failed: %v", err)
+	}
+	_, err = orderService.CancelOrder(ctx, &pb.CancelOrderRequest{Id: first.Id})
+	if status.Code(err) != codes.FailedPrecondition {
+		t.Errorf("Expected FailedPrecondition error for cancelling twice, got: %v", err)
+	}
+	restocked, err := listingService.GetListing(ctx, &pb.GetListingRequest{Id: listing.Id})
+	if err != nil {
+		t.Fatalf("GetListing failed: %v", err)
+	}
+	if restocked.Quantity != 1 || restocked.Status != pb.ListingStatus_LISTING_STATUS_ACTIVE {
+		t.Errorf("Expected 1 item back in stock, got quantity %d status %v", restocked.Quantity, restocked.Status)
+	}
+	_, err = orderService.UpdateOrderStatus(ctx, &pb.UpdateOrderStatusRequest{Id: first.Id, Status: pb.OrderStatus_ORDER_STATUS_PENDING})
+	if status.Code(err) != codes.FailedPrecondition {
+		t.Errorf("Expected FailedPrecondition error for reviving a cancelled order, got: %v", err)
+	}
+
+	// Test restocking through UpdateListing
+	updated, err := listingService.UpdateListing(ctx, &pb.UpdateListingRequest{
+		Id:      listing.Id,
+		Listing: &pb.ListingUpdate{Quantity: 10},
+	})
+	if err != nil {
+		t.Fatalf("UpdateListing failed: %v", err)
+	}
+	if updated.Quantity != 10 {
+		t.Errorf("Expected quantity 10 after restock, got %d", updated.Quantity)
+	}
+}
+
+func TestOrderServiceLineItems(t *testing.T) {
+	store := storage.NewInMemoryStorage()
+	listingService := newTestListingService(t, store)
+	orderService := NewOrderService(store, newTestRates(t))
+	seller, otherSeller, buyer := userContext(t, 1), userContext(t, 2), userContext(t, 3)
+	address := &pb.Address{Street: "1 Main St", City: "Springfield", Country: "USA"}
+
+	create := func(ctx context.Context, title string, price *pb.Money, quantity int32) *pb.Listing {
+		listing, err := listingService.CreateListing(ctx, &pb.ListingCreate{Title: title, Description: title, Price: price, Quantity: quantity})
+		if err != nil {
+			t.Fatalf("CreateListing failed: %v", err)
+		}
+		return listing
+	}
+	desk := create(seller, "Desk", usd(10000), 2)
+	lamp := create(seller, "Lamp", usd(3000), 3)
+	chair := create(otherSeller, "Chair", usd(5000), 1)
+	quantities := func() (int32, int32) {
+		d, _ := store.GetListing(desk.Id)
+		l, _ := store.GetListing(lamp.Id)
+		return d.Quantity, l.Quantity
+	}
+
+	// Test ordering several items from one seller
+	order, err := orderService.CreateOrder(buyer, &pb.OrderCreate{
+		Items:           []*pb.OrderItemCreate{{ListingId: desk.Id, Quantity: 1}, {ListingId: lamp.Id, Quantity: 2}},
+		ShippingAddress: address,
+	})
+	if err != nil {
+		t.Fatalf("CreateOrder failed: %v", err)
+	}
+	if len(order.Items) != 2 || order.Items[1].UnitPrice.MinorUnits != 3000 || order.Items[1].Subtotal.MinorUnits != 6000 {
+		t.Errorf("Expected 2 lamps at 30.00 USD each, got %v", order.Items)
+	}
+	if order.TotalPrice.MinorUnits != 16000 || order.SellerId != 1 {
+		t.Errorf("Expected total of 160.00 USD from seller 1, got %v from seller %d", order.TotalPrice, order.SellerId)
+	}
+	if d, l := quantities(); d != 1 || l != 1 {
+		t.Errorf("Expected 1 desk and 1 lamp left, got %d and %d", d, l)
+	}
+
+	// Test invalid orders reserve nothing
+	_, err = orderService.CreateOrder(buyer, &pb.OrderCreate{
+		ListingId:       desk.Id,
+		Quantity:        1,
+		Items:           []*pb.OrderItemCreate{{ListingId: lamp.Id, Quantity: 1}},
+		ShippingAddress: address,
+	})
+	if status.Code(err) != codes.InvalidArgument {
+		t.Errorf("Expected InvalidArgument error for listing_id with items, got: %v", err)
+	}
+	_, err = orderService.CreateOrder(buyer, &pb.OrderCreate{
+		Items:           []*pb.OrderItemCreate{{ListingId: desk.Id, Quantity: 1}, {ListingId: chair.Id, Quantity: 1}},
+		ShippingAddress: address,
+	})
+	if status.Code(err) != codes.InvalidArgument {
+		t.Errorf("Expected InvalidArgument error for items from two sellers, got: %v", err)
+	}
+	_, err = orderService.CreateOrder(buyer, &pb.OrderCreate{
+		Items:           []*pb.OrderItemCreate{{ListingId: desk.Id, Quantity: 1}, {ListingId: lamp.Id, Quantity: 2}},
+		ShippingAddress: address,
+	})
+	if status.Code(err) != codes.FailedPrecondition {
+		t.Errorf("Expected FailedPrecondition error for an item out of stock, got: %v", err)
+	}
+	if d, l := quantities(); d != 1 || l != 1 {
+		t.Errorf("Expected failed orders to leave 1 desk and 1 lamp, got %d and %d", d, l)
+	}
+
+	// Test items kept or reduced keep their price while new and increased
+	// ones take the current one
+	_, err = listingService.UpdateListing(seller, &pb.UpdateListingRequest{Id: lamp.Id, Listing: &pb.ListingUpdate{Price: usd(3500)}})
+	if err != nil {
+		t.Fatalf("UpdateListing failed: %v", err)
+	}
+	_, err = listingService.UpdateListing(seller, &pb.UpdateListingRequest{Id: desk.Id, Listing: &pb.ListingUpdate{Price: usd(12000)}})
+	if err != nil {
+		t.Fatalf("UpdateListing failed: %v", err)
+	}
+	updated, err := orderService.UpdateOrder(buyer, &pb.UpdateOrderRequest{
+		Id:         order.Id,
+		Order:      &pb.OrderUpdate{Items: []*pb.OrderItemCreate{{ListingId: lamp.Id, Quantity: 1}}},
+		UpdateMask: &fieldmaskpb.FieldMask{Paths: []string{"items"}},
+	})
+	if err != nil {
+		t.Fatalf("UpdateOrder failed: %v", err)
+	}
+	if len(updated.Items) != 1 || updated.Items[0].UnitPrice.MinorUnits != 3000 || updated.TotalPrice.MinorUnits != 3000 {
+		t.Errorf("Expected 1 lamp at the ordered 30.00 USD, got %v", updated)
+	}
+	if d, l := quantities(); d != 2 || l != 2 {
+		t.Errorf("Expected the desk and a lamp back in stock, got %d and %d", d, l)
+	}
+	updated, err = orderService.UpdateOrder(buyer, &pb.UpdateOrderRequest{
+		Id:         order.Id,
+		Order:      &pb.OrderUpdate{Items: []*pb.OrderItemCreate{{ListingId: lamp.Id, Quantity: 3}}},
+		UpdateMask: &fieldmaskpb.FieldMask{Paths: []string{"items"}},
+	})
+	if err != nil {
+		t.Fatalf("UpdateOrder failed: %v", err)
+	}
+	if updated.Items[0].UnitPrice.MinorUnits != 3500 || updated.TotalPrice.MinorUnits != 10500 {
+		t.Errorf("Expected 3 lamps at the current 35.00 USD, got %v", updated)
+	}
+	if d, l := quantities(); d != 2 || l != 0 {
+		t.Errorf("Expected 2 desks and no lamps left, got %d and %d", d, l)
+	}
+	updated, err = orderService.UpdateOrder(buyer, &pb.UpdateOrderRequest{
+		Id: order.Id,
+		Order: &pb.OrderUpdate{
+			Items:        []*pb.OrderItemCreate{{ListingId: lamp.Id, Quantity: 3}, {ListingId: desk.Id, Quantity: 1}},
+			ShippingCost: usd(1500),
+			Tax:          usd(2400),
+		},
+	})
+	if err != nil {
+		t.Fatalf("UpdateOrder failed: %v", err)
+	}
+	if updated.Items[1].UnitPrice.MinorUnits != 12000 || updated.TotalPrice.MinorUnits != 26400 {
+		t.Errorf("Expected a desk at 120.00 USD and a total of 264.00 USD, got %v", updated)
+	}
+	_, err = orderService.UpdateOrder(buyer, &pb.UpdateOrderRequest{
+		Id:    order.Id,
+		Order: &pb.OrderUpdate{Items: []*pb.OrderItemCreate{{ListingId: chair.Id, Quantity: 1}, {ListingId: desk.Id, Quantity: 1}}},
+	})
+	if status.Code(err) != codes.InvalidArgument {
+		t.Errorf("Expected InvalidArgument error for adding another seller's item, got: %v", err)
+	}
+	_, err = orderService.UpdateOrder(buyer, &pb.UpdateOrderRequest{Id: order.Id, Order: &pb.OrderUpdate{Tax: usd(-1)}})
+	if status.Code(err) != codes.InvalidArgument {
+		t.Errorf("Expected InvalidArgument error for negative tax, got: %v", err)
+	}
+
+	// Test filtering orders by any of their listings
+	if _, err := orderService.CreateOrder(buyer, &pb.OrderCreate{ListingId: desk.Id, Quantity: 1, ShippingAddress: address}); err != nil {
+		t.Fatalf("CreateOrder failed: %v", err)
+	}
+	for _, tc := range []struct {
+		listingID int32
+		want      int32
+	}{{desk.Id, 2}, {lamp.Id, 1}, {chair.Id, 0}} {
+		resp, err := orderService.GetOrders(buyer, &pb.OrdersRequest{ListingId: tc.listingID})
+		if err != nil {
+			t.Fatalf("GetOrders failed: %v", err)
+		}
+		if resp.Pagination.Total != tc.want {
+			t.Errorf("Expected %d orders with listing %d, got %d", tc.want, tc.listingID, resp.Pagination.Total)
+		}
+	}
+
+	// Test cancelling returns every item to stock
+	if _, err := orderService.CancelOrder(buyer, &pb.CancelOrderRequest{Id: order.Id}); err != nil {
+		t.Fatalf("CancelOrder failed: %v", err)
+	}
+	if d, l := quantities(); d != 1 || l != 3 {
+		t.Errorf("Expected 1 desk and 3 lamps back in stock, got %d and %d", d, l)
+	}
+}
+
+func TestOrderServiceStatus(t *testing.T) {
+	store := storage.NewInMemoryStorage()
+	listingService := newTestListingService(t, store)
+	orderService := NewOrderService(store, newTestRates(t))
+	seller, buyer, stranger := userContext(t, 1), userContext(t, 2), userContext(t, 3)
+	address := &pb.Address{Street: "1 Main St", City: "Springfield", Country: "USA"}
+
+	listing, err := listingService.CreateListing(seller, &pb.ListingCreate{Title: "Kettle", Description: "Electric kettle", Price: usd(3000), Quantity: 5})
+	if err != nil {
+		t.Fatalf("CreateListing failed: %v", err)
+	}
+	order, err := orderService.CreateOrder(buyer, &pb.OrderCreate{ListingId: listing.Id, Quantity: 1, ShippingAddress: address})
+	if err != nil {
+		t.Fatalf("CreateOrder failed: %v", err)
+	}
+	if order.Status != pb.OrderStatus_ORDER_STATUS_PENDING || len(order.StatusHistory) != 1 || order.StatusHistory[0].Actor != pb.OrderActor_ORDER_ACTOR_BUYER {
+		t.Errorf("Expected a pending order placed by the buyer, got %v", order.StatusHistory)
+	}
+
+	move := func(ctx context.Context, to pb.OrderStatus) (*pb.Order, error) {
+		return orderService.UpdateOrderStatus(ctx, &pb.UpdateOrderStatusRequest{Id: order.Id, Status: to})
+	}
+
+	// Test each move is made by the right party
+	for _, tc := range []struct {
+		ctx  context.Context
+		to   pb.OrderStatus
+		code codes.Code
+		want string
+	}{
+		{buyer, pb.OrderStatus_ORDER_STATUS_CONFIRMED, codes.PermissionDenied, "the buyer confirming"},
+		{stranger, pb.OrderStatus_ORDER_STATUS_CANCELLED, codes.PermissionDenied, "a stranger cancelling"},
+		{seller, pb.OrderStatus_ORDER_STATUS_SHIPPED, codes.FailedPrecondition, "shipping before confirming"},
+		{seller, pb.OrderStatus_ORDER_STATUS_CONFIRMED, codes.OK, ""},
+		{buyer, pb.OrderStatus_ORDER_STATUS_CANCELLED, codes.PermissionDenied, "the buyer cancelling a confirmed order"},
+		{seller, pb.OrderStatus_ORDER_STATUS_SHIPPED, codes.OK, ""},
+		{seller, pb.OrderStatus_ORDER_STATUS_DELIVERED, codes.PermissionDenied, "the seller confirming delivery"},
+		{buyer, pb.OrderStatus_ORDER_STATUS_DELIVERED, codes.OK, ""},
+		{seller, pb.OrderStatus_ORDER_STATUS_PENDING, codes.FailedPrecondition, "reopening a delivered order"},
+		{buyer, pb.OrderStatus_ORDER_STATUS_DELIVERED, codes.FailedPrecondition, "delivering twice"},
+		{seller, pb.OrderStatus_ORDER_STATUS_UNSPECIFIED, codes.InvalidArgument, "an unspecified status"},
+	} {
+		_, err := move(tc.ctx, tc.to)
+		if status.Code(err) != tc.code {
+			if tc.code == codes.OK {
+				t.Fatalf("UpdateOrderStatus to %v failed: %v", tc.to, err)
+			}
+			t.Errorf("Expected %v error for %s, got: %v", tc.code, tc.want, err)
+		}
+	}
+
+	// Test delivered orders cannot be cancelled, changed or deleted and keep
+	// their stock
+	_, err = orderService.CancelOrder(seller, &pb.CancelOrderRequest{Id: order.Id})
+	if status.Code(err) != codes.FailedPrecondition {
+		t.Errorf("Expected FailedPrecondition error for cancelling a delivered order, got: %v", err)
+	}
+	_, err = orderService.UpdateOrder(buyer, &pb.UpdateOrderRequest{
+		Id:    order.Id,
+		Order: &pb.OrderUpdate{Items: []*pb.OrderItemCreate{{ListingId: listing.Id, Quantity: 3}}},
+	})
+	if status.Code(err) != codes.FailedPrecondition {
+		t.Errorf("Expected FailedPrecondition error for changing the items of a delivered order, got: %v", err)
+	}
+	_, err = orderService.DeleteOrder(seller, &pb.DeleteOrderRequest{Id: order.Id})
+	if status.Code(err) != codes.FailedPrecondition {
+		t.Errorf("Expected FailedPrecondition error for deleting a delivered order, got: %v", err)
+	}
+	if got, _ := store.GetListing(listing.Id); got.Quantity != 4 {
+		t.Errorf("Expected 4 kettles left, got %d", got.Quantity)
+	}
+
+	// Test the history records every change in order
+	delivered, err := orderService.GetOrder(buyer, &pb.GetOrderRequest{Id: order.Id})
+	if err != nil {
+		t.Fatalf("GetOrder failed: %v", err)
+	}
+	want := []struct {
+		status pb.OrderStatus
+		userID int32
+	}{
+		{pb.OrderStatus_ORDER_STATUS_PENDING, 2},
+		{pb.OrderStatus_ORDER_STATUS_CONFIRMED, 1},
+		{pb.OrderStatus_ORDER_STATUS_SHIPPED, 1},
+		{pb.OrderStatus_ORDER_STATUS_DELIVERED, 2},
+	}
+	if len(delivered.StatusHistory) != len(want) {
+		t.Fatalf("Expected %d status changes, got %v", len(want), delivered.StatusHistory)
+	}
+	for i, change := range delivered.StatusHistory {
+		if change.Status != want[i].status || change.UserId != want[i].userID || change.ChangedAt == nil {
+			t.Errorf("Expected change %d to %v by user %d, got %v", i, want[i].status, want[i].userID, change)
+		}
+	}
+
+	// Test buyers can cancel pending orders, once
+	second, err := orderService.CreateOrder(buyer, &pb.OrderCreate{ListingId: listing.Id, Quantity: 2, ShippingAddress: address})
+	if err != nil {
+		t.Fatalf("CreateOrder failed: %v", err)
+	}
+	cancelled, err := orderService.CancelOrder(buyer, &pb.CancelOrderRequest{Id: second.Id, CancelReason: "Found it cheaper"})
+	if err != nil {
+		t.Fatalf("CancelOrder failed: %v", err)
+	}
+	last := cancelled.Order.StatusHistory[len(cancelled.Order.StatusHistory)-1]
+	if last.Status != pb.OrderStatus_ORDER_STATUS_CANCELLED || last.Actor != pb.OrderActor_ORDER_ACTOR_BUYER || cancelled.Order.CancelledAt == nil {
+		t.Errorf("Expected the buyer's cancellation in the history, got %v", cancelled.Order)
+	}
+	_, err = orderService.CancelOrder(seller, &pb.CancelOrderRequest{Id: second.Id})
+	if status.Code(err) != codes.FailedPrecondition {
+		t.Errorf("Expected FailedPrecondition error for cancelling twice, got: %v", err)
+	}
+	if got, _ := store.GetListing(listing.Id); got.Quantity != 4 {
+		t.Errorf("Expected stock restored to 4 kettles, got %d", got.Quantity)
+	}
+
+	// Test filtering by status
+	resp, err := orderService.GetOrders(buyer, &pb.OrdersRequest{Status: pb.OrderStatus_ORDER_STATUS_DELIVERED})
+	if err != nil {
+		t.Fatalf("GetOrders failed: %v", err)
+	}
+	if resp.Pagination.Total != 1 || resp.Orders[0].Id != order.Id {
+		t.Errorf("Expected only the delivered order, got %v", resp.Orders)
+	}
+}
+
+func TestOrderServiceConcurrentOrders(t *testing.T) {
+	store := storage.NewInMemoryStorage()
+	listingService := newTestListingService(t, store)
+	orderService := NewOrderService(store, newTestRates(t))
+	ctx := userContext(t, 1)
+
+	const stock = 10
+	const buyers = 50
+
+	listing, err := listingService.CreateListing(ctx, &pb.ListingCreate{
+		Title:       "Concert ticket",
+		Description: "General admission",
+		Price:       usd(5000),
+		Quantity:    stock,
+	})
+	if err != nil {
+		t.Fatalf("CreateListing failed: %v", err)
+	}
+
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	succeeded, rejected := 0, 0
+	for i := 0; i < buyers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := orderService.CreateOrder(ctx, &pb.OrderCreate{
+				ListingId:       listing.Id,
+				Quantity:        1,
+				ShippingAddress: &pb.Address{Street: "1 Main St", City: "Springfield", Country: "USA"},
+			})
+			mu.Lock()
+			defer mu.Unlock()
+			switch status.Code(err) {
+			case codes.OK:
+				succeeded++
+			case codes.FailedPrecondition:
+				rejected++
+			default:
+				t.Errorf("Unexpected CreateOrder error: %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if succeeded != stock || rejected != buyers-stock {
+		t.Errorf("Expected %d orders and %d rejections, got %d and %d", stock, buyers-stock, succeeded, rejected)
+	}
+	final, err := listingService.GetListing(ctx, &pb.GetListingRequest{Id: listing.Id})
+	if err != nil {
+		t.Fatalf("GetListing failed: %v", err)
+	}
+	if final.Quantity != 0 || final.Status != pb.ListingStatus_LISTING_STATUS_SOLD {
+		t.Errorf("Expected listing sold out, got quantity %d status %v", final.Quantity, final.Status)
+	}
+	orders, err := orderService.GetOrders(ctx, &pb.OrdersRequest{Limit: buyers})
+	if err != nil {
+		t.Fatalf("GetOrders failed: %v", err)
+	}
+	if orders.Pagination.Total != stock {
+		t.Errorf("Expected %d stored orders, got %d", stock, orders.Pagination.Total)
+	}
+}
+
+func TestListingServiceLifecycle(t *testing.T) {
+	store := storage.NewInMemoryStorage()
+	service := newTestListingService(t, store)
+	orderService := NewOrderService(store, newTestRates(t))
+	ctx := userContext(t, 1)
+
+	address := &pb.Address{Street: "1 Main St", City: "Springfield", Country: "USA"}
+
+	// Test drafts are hidden and cannot be ordered
+	draft, err := service.CreateListing(ctx, &pb.ListingCreate{
+		Title:       "Vintage camera",
+		Description: "Still writing the description",
+		Price:       usd(8000),
+		Draft:       true,
+	})
+	if err != nil {
+		t.Fatalf("CreateListing failed: %v", err)
+	}
+	if draft.Status != pb.ListingStatus_LISTING_STATUS_DRAFT {
+		t.Errorf("Expected draft status, got %v", draft.Status)
+	}
+	resp, err := service.GetListings(ctx, &pb.ListingsRequest{Search: "camera"})
+	if err != nil {
+		t.Fatalf("GetListings failed: %v", err)
+	}
+	if len(resp.Listings) != 0 {
+		t.Errorf("Expected drafts to be hidden by default, got %d listings", len(resp.Listings))
+	}
+	resp, err = service.GetListings(ctx, &pb.ListingsRequest{
+		Search:   "camera",
+		Statuses: []pb.ListingStatus{pb.ListingStatus_LISTING_STATUS_DRAFT},
+	})
+	if err != nil {
+		t.Fatalf("GetListings failed: %v", err)
+	}
+	if len(resp.Listings) != 1 {
+		t.Errorf("Expected draft when filtering by draft status, got %d listings", len(resp.Listings))
+	}
+	_, err = orderService.CreateOrder(ctx, &pb.OrderCreate{ListingId: draft.Id, Quantity: 1, ShippingAddress: address})
+	if status.Code(err) != codes.FailedPrecondition {
+		t.Errorf("Expected FailedPrecondition error for ordering a draft, got: %v", err)
+	}
+	_, err = service.PauseListing(ctx, &pb.ListingActionRequest{Id: draft.Id})
+	if status.Code(err) != codes.FailedPrecondition {
+		t.Errorf("Expected FailedPrecondition error for pausing a draft, got: %v", err)
+	}
+
+	// Test only the seller can change the status
+	callers := map[codes.Code]context.Context{
+		codes.Unauthenticated:  context.Background(),
+		codes.PermissionDenied: userContext(t, 2),
+	}
+	for code, other := range callers {
+		_, err = service.PublishListing(other, &pb.ListingActionRequest{Id: draft.Id})
+		if status.Code(err) != code {
+			t.Errorf("Expected %v error for publishing another user's listing, got: %v", code, err)
+		}
+		_, err = service.EndListing(other, &pb.ListingActionRequest{Id: draft.Id})
+		if status.Code(err) != code {
+			t.Errorf("Expected %v error for ending another user's listing, got: %v", code, err)
+		}
+	}
+	if got, _ := store.GetListing(draft.Id); got.Status != pb.ListingStatus_LISTING_STATUS_DRAFT {
+		t.Errorf("Expected the draft to be left alone, got %v", got.Status)
+	}
+
+	// Test publish, pause, resume, end
+	active, err := service.PublishListing(ctx, &pb.ListingActionRequest{Id: draft.Id})
+	if err != nil {
+		t.Fatalf("PublishListing failed: %v", err)
+	}
+	if active.Status != pb.ListingStatus_LISTING_STATUS_ACTIVE {
+		t.Errorf("Expected active status, got %v", active.Status)
+	}
+	paused, err := service.PauseListing(ctx, &pb.ListingActionRequest{Id: draft.Id})
+	if err != nil {
+		t.Fatalf("PauseListing failed: %v", err)
+	}
+	if paused.Status != pb.ListingStatus_LISTING_STATUS_PAUSED {
+		t.Errorf("Expected paused status, got %v", paused.Status)
+	}
+	_, err = orderService.CreateOrder(ctx, &pb.OrderCreate{ListingId: draft.Id, Quantity: 1, ShippingAddress: address})
+	if status.Code(err) != codes.FailedPrecondition {
+		t.Errorf("Expected FailedPrecondition error for ordering a paused listing, got: %v", err)
+	}
+
+	// Test editing a paused listing keeps it paused
+	edited, err := service.UpdateListing(ctx, &pb.UpdateListingRequest{
+		Id:      draft.Id,
+		Listing: &pb.ListingUpdate{Description: "Fully working"},
+	})
+	if err != nil {
+		t.Fatalf("UpdateListing failed: %v", err)
+	}
+	if edited.Status != pb.ListingStatus_LISTING_STATUS_PAUSED {
+		t.Errorf("Expected UpdateListing to keep paused status, got %v", edited.Status)
+	}
+
+	if _, err := service.PublishListing(ctx, &pb.ListingActionRequest{Id: draft.Id}); err != nil {
+		t.Fatalf("PublishListing failed: %v", err)
+	}
+	if _, err := orderService.CreateOrder(ctx, &pb.OrderCreate{ListingId: draft.Id, Quantity: 1, ShippingAddress: address}); err != nil {
+		t.Fatalf("CreateOrder failed: %v", err)
+	}
+	sold, err := service.GetListing(ctx, &pb.GetListingRequest{Id: draft.Id})
+	if err != nil {
+		t.Fatalf("GetListing failed: %v", err)
+	}
+	if sold.Status != pb.ListingStatus_LISTING_STATUS_SOLD {
+		t.Errorf("Expected sold status after last item ordered, got %v", sold.Status)
+	}
+	_, err = service.PublishListing(ctx, &pb.ListingActionRequest{Id: draft.Id})
+	if status.Code(err) != codes.FailedPrecondition {
+		t.Errorf("Expected FailedPrecondition error for publishing without stock, got: %v", err)
+	}
+
+	ended, err := service.EndListing(ctx, &pb.ListingActionRequest{Id: draft.Id})
+	if err != nil {
+		t.Fatalf("EndListing failed: %v", err)
+	}
+	if ended.Status != pb.ListingStatus_LISTING_STATUS_ENDED {
+		t.Errorf("Expected ended status, got %v", ended.Status)
+	}
+	_, err = service.PublishListing(ctx, &pb.ListingActionRequest{Id: draft.Id})
+	if status.Code(err) != codes.FailedPrecondition {
+		t.Errorf("Expected FailedPrecondition error for publishing an ended listing, got: %v", err)
+	}
+
+	_, err = service.EndListing(ctx, &pb.ListingActionRequest{Id: 999})
+	if status.Code(err) != codes.NotFound {
+		t.Errorf("Expected NotFound error for non-existent listing, got: %v", err)
+	}
+}
+
+func TestListingServiceDuration(t *testing.T) {
+	store := storage.NewInMemoryStorage()
+	service := newTestListingService(t, store)
+	ctx := userContext(t, 1)
+
+	// Test a duration sets the end time
+	listing, err := service.CreateListing(ctx, &pb.ListingCreate{
+		Title:       "Garden chairs",
+		Description: "Set of four",
+		Price:       usd(6000),
+		Duration:    durationpb.New(7 * 24 * time.Hour),
+		AutoRelist:  true,
+	})
+	if err != nil {
+		t.Fatalf("CreateListing failed: %v", err)
+	}
+	if listing.EndsAt == nil || !listing.AutoRelist {
+		t.Fatalf("Expected end time and auto-relist, got %+v", listing)
+	}
+	if remaining := time.Until(listing.EndsAt.AsTime()); remaining < 6*24*time.Hour || remaining > 7*24*time.Hour {
+		t.Errorf("Expected listing to end in about a week, got %v", remaining)
+	}
+
+	// Test an end time derives the run length used for relisting
+	endsAt := time.Now().Add(48 * time.Hour)
+	listing, err = service.CreateListing(ctx, &pb.ListingCreate{
+		Title:       "Garden table",
+		Description: "Teak",
+		Price:       usd(12000),
+		EndsAt:      timestamppb.New(endsAt),
+	})
+	if err != nil {
+		t.Fatalf("CreateListing failed: %v", err)
+	}
+	if listing.Duration.AsDuration() < 47*time.Hour {
+		t.Errorf("Expected a run of about 48h, got %v", listing.Duration.AsDuration())
+	}
+
+	// Test drafts start their run when published
+	draft, err := service.CreateListing(ctx, &pb.ListingCreate{
+		Title:       "Parasol",
+		Description: "Blue",
+		Price:       usd(3000),
+		Draft:       true,
+		Duration:    durationpb.New(24 * time.Hour),
+	})
+	if err != nil {
+		t.Fatalf("CreateListing failed: %v", err)
+	}
+	if draft.EndsAt != nil {
+		t.Errorf("Expected draft without end time, got %v", draft.EndsAt)
+	}
+	published, err := service.PublishListing(ctx, &pb.ListingActionRequest{Id: draft.Id})
+	if err != nil {
+		t.Fatalf("PublishListing failed: %v", err)
+	}
+	if published.EndsAt == nil {
+		t.Error("Expected published listing to get an end time")
+	}
+
+	// Test drafts given an end time keep only the run length
+	draft, err = service.CreateListing(ctx, &pb.ListingCreate{
+		Title:       "Hammock",
+		Description: "Striped",
+		Price:       usd(4000),
+		Draft:       true,
+		EndsAt:      timestamppb.New(endsAt),
+	})
+	if err != nil {
+		t.Fatalf("CreateListing failed: %v", err)
+	}
+	if draft.EndsAt != nil || draft.Duration.AsDuration() < 47*time.Hour {
+		t.Errorf("Expected draft with a run of about 48h and no end time, got %v and %v", draft.Duration, draft.EndsAt)
+	}
+
+	// Test error cases
+	invalid := []*pb.ListingCreate{
+		{Duration: durationpb.New(time.Hour), EndsAt: timestamppb.New(endsAt)},
+		{Duration: durationpb.New(-time.Hour)},
+		{EndsAt: timestamppb.New(time.Now().Add(-time.Hour))},
+		{AutoRelist: true},
+	}
+	for _, req := range invalid {
+		req.Title, req.Description, req.Price = "Invalid", "Invalid run", usd(1000)
+		_, err = service.CreateListing(ctx, req)
+		if status.Code(err) != codes.InvalidArgument {
+			t.Errorf("Expected InvalidArgument error for %+v, got: %v", req, err)
+		}
+	}
+}
+
+func TestListingServiceScheduledStart(t *testing.T) {
+	store := storage.NewInMemoryStorage()
+	service := newTestListingService(t, store)
+	orderService := NewOrderService(store, newTestRates(t))
+	ctx := userContext(t, 1)
+
+	start := time.Now().Add(24 * time.Hour)
+	listing, err := service.CreateListing(ctx, &pb.ListingCreate{
+		Title:          "Limited edition print",
+		Description:    "Drops tomorrow",
+		Price:          usd(25000),
+		ScheduledStart: timestamppb.New(start),
+		EndsAt:         timestamppb.New(start.Add(72 * time.Hour)),
+	})
+	if err != nil {
+		t.Fatalf("CreateListing failed: %v", err)
+	}
+	if listing.Status != pb.ListingStatus_LISTING_STATUS_SCHEDULED {
+		t.Errorf("Expected scheduled status, got %v", listing.Status)
+	}
+	if listing.EndsAt != nil || listing.Duration.AsDuration() != 72*time.Hour {
+		t.Errorf("Expected a 72h run starting at go-live, got ends_at %v duration %v", listing.EndsAt, listing.Duration.AsDuration())
+	}
+	draft, err := service.CreateListing(ctx, &pb.ListingCreate{
+		Title:       "Second print",
+		Description: "Not scheduled yet",
+		Price:       usd(20000),
+		Draft:       true,
+	})
+	if err != nil {
+		t.Fatalf("CreateListing failed: %v", err)
+	}
+
+	// Test scheduled listings are hidden and cannot be ordered
+	resp, err := service.GetListings(ctx, &pb.ListingsRequest{Search: "print"})
+	if err != nil {
+		t.Fatalf("GetListings failed: %v", err)
+	}
+	if len(resp.Listings) != 0 {
+		t.Errorf("Expected scheduled listing to be hidden, got %d listings", len(resp.Listings))
+	}
+	_, err = orderService.CreateOrder(ctx, &pb.OrderCreate{
+		ListingId:       listing.Id,
+		Quantity:        1,
+		ShippingAddress: &pb.Address{Street: "1 Main St", City: "Springfield", Country: "USA"},
+	})
+	if status.Code(err) != codes.FailedPrecondition {
+		t.Errorf("Expected FailedPrecondition error for ordering a scheduled listing, got: %v", err)
+	}
+
+	// Test scheduling a draft and listing pending listings in start order
+	_, err = service.RescheduleListing(ctx, &pb.RescheduleListingRequest{
+		Id:             draft.Id,
+		ScheduledStart: timestamppb.New(start.Add(-time.Hour)),
+	})
+	if err != nil {
+		t.Fatalf("RescheduleListing failed: %v", err)
+	}
+	pending, err := service.GetScheduledListings(ctx, &pb.ScheduledListingsRequest{})
+	if err != nil {
+		t.Fatalf("GetScheduledListings failed: %v", err)
+	}
+	if len(pending.Listings) != 2 || pending.Listings[0].Id != draft.Id {
+		t.Errorf("Expected draft first among 2 pending listings, got %v", pending.Listings)
+	}
+
+	// Test rescheduling moves the start time
+	later := start.Add(48 * time.Hour)
+	rescheduled, err := service.RescheduleListing(ctx, &pb.RescheduleListingRequest{
+		Id:             listing.Id,
+		ScheduledStart: timestamppb.New(later),
+	})
+	if err != nil {
+		t.Fatalf("RescheduleListing failed: %v", err)
+	}
+	if !rescheduled.ScheduledStart.AsTime().Equal(later) {
+		t.Errorf("Expected new start %v, got %v", later, rescheduled.ScheduledStart.AsTime())
+	}
+
+	// Test a draft with an end time runs its full length from the new start
+	ending := &pb.Listing{
+		Title:    "Poster",
+		Price:    usd(1500),
+		Quantity: 1,
+		Status:   pb.ListingStatus_LISTING_STATUS_DRAFT,
+		EndsAt:   timestamppb.New(start.Add(time.Hour)),
+		Duration: durationpb.New(2 * time.Hour),
+		UserId:   1,
+	}
+	if err := store.CreateListing(ending); err != nil {
+		t.Fatalf("CreateListing failed: %v", err)
+	}
+	rescheduled, err = service.RescheduleListing(ctx, &pb.RescheduleListingRequest{
+		Id:             ending.Id,
+		ScheduledStart: timestamppb.New(later),
+	})
+	if err != nil {
+		t.Fatalf("RescheduleListing failed: %v", err)
+	}
+	if rescheduled.EndsAt != nil || rescheduled.Duration.AsDuration() != 2*time.Hour {
+		t.Errorf("Expected a 2h run without an end time, got %v and %v", rescheduled.Duration, rescheduled.EndsAt)
+	}
+
+	// Test publishing early
+	published, err := service.PublishListing(ctx, &pb.ListingActionRequest{Id: listing.Id})
+	if err != nil {
+		t.Fatalf("PublishListing failed: %v", err)
+	}
+	if published.EndsAt == nil {
+		t.Error("Expected run to start when published early")
+	}
+
+	// Test error cases
+	_, err = service.RescheduleListing(ctx, &pb.RescheduleListingRequest{Id: listing.Id, ScheduledStart: timestamppb.New(later)})
+	if status.Code(err) != codes.FailedPrecondition {
+		t.Errorf("Expected FailedPrecondition error for rescheduling a live listing, got: %v", err)
+	}
+	_, err = service.RescheduleListing(ctx, &pb.RescheduleListingRequest{Id: draft.Id, ScheduledStart: timestamppb.New(time.Now().Add(-time.Hour))})
+	if status.Code(err) != codes.InvalidArgument {
+		t.Errorf("Expected InvalidArgument error for start in the past, got: %v", err)
+	}
+	_, err = service.CreateListing(ctx, &pb.ListingCreate{
+		Title:          "Invalid",
+		Description:    "Draft and scheduled",
+		Price:          usd(1000),
+		Draft:          true,
+		ScheduledStart: timestamppb.New(start),
+	})
+	if status.Code(err) != codes.InvalidArgument {
+		t.Errorf("Expected InvalidArgument error for scheduled draft, got: %v", err)
+	}
+}
+
+func TestListingServiceVariations(t *testing.T) {
+	store := storage.NewInMemoryStorage()
+	categoryService := NewCategoryService(store)
+	listingService := newTestListingService(t, store)
+	orderService := NewOrderService(store, newTestRates(t))
+	ctx := userContext(t, 1)
+
+	_, err := categoryService.CreateCategory(ctx, &pb.CategoryCreate{
+		Slug: "t-shirts",
+		Name: "T-Shirts",
+		Attributes: []*pb.AttributeSchema{
+			{Name: "brand", Type: pb.AttributeType_ATTRIBUTE_TYPE_STRING},
+			{Name: "size", Type: pb.AttributeType_ATTRIBUTE_TYPE_STRING, Required: true, AllowedValues: []string{"S", "M", "L"}},
+			{Name: "color", Type: pb.AttributeType_ATTRIBUTE_TYPE_STRING},
+		},
+	})
+	if err != nil {
+		t.Fatalf("CreateCategory failed: %v", err)
+	}
+
+	attr := func(name, value string) *pb.AttributeValue {
+		return &pb.AttributeValue{Name: name, Value: &pb.AttributeValue_StringValue{StringValue: value}}
+	}
+	variation := func(size, color string, price int64, quantity int32) *pb.ListingVariationCreate {
+		return &pb.ListingVariationCreate{
+			Attributes: []*pb.AttributeValue{attr("size", size), attr("color", color)},
+			Price:      usd(price * 100),
+			Quantity:   quantity,
+		}
+	}
+	create := func(variations ...*pb.ListingVariationCreate) (*pb.Listing, error) {
+		return listingService.CreateListing(ctx, &pb.ListingCreate{
+			Title:       "Logo tee",
+			Description: "Cotton T-shirt",
+			Category:    "t-shirts",
+			Attributes:  []*pb.AttributeValue{attr("brand", "Acme")},
+			Variations:  variations,
+		})
+	}
+
+	// Test CreateListing derives price and stock from the variations
+	listing, err := create(variation("S", "red", 15, 2), variation("M", "red", 15, 0), variation("L", "blue", 18, 3))
+	if err != nil {
+		t.Fatalf("CreateListing failed: %v", err)
+	}
+	if listing.Price.MinorUnits != 1500 || listing.Quantity != 5 || len(listing.Variations) != 3 {
+		t.Errorf("Expected price 15 and quantity 5 across 3 variations, got %v, %d, %d", listing.Price, listing.Quantity, len(listing.Variations))
+	}
+
+	// Test invalid variations
+	if _, err := create(variation("S", "red", 15, 1), variation("s", "Red", 16, 1)); status.Code(err) != codes.InvalidArgument {
+		t.Errorf("Expected InvalidArgument error for duplicate combination, got: %v", err)
+	}
+	if _, err := create(variation("XL", "red", 15, 1)); status.Code(err) != codes.InvalidArgument {
+		t.Errorf("Expected InvalidArgument error for disallowed size, got: %v", err)
+	}
+	if _, err := create(variation("S", "red", 15, 1), &pb.ListingVariationCreate{Attributes: []*pb.AttributeValue{attr("size", "M")}, Price: usd(1500), Quantity: 1}); status.Code(err) != codes.InvalidArgument {
+		t.Errorf("Expected InvalidArgument error for mismatched attributes, got: %v", err)
+	}
+	if _, err := create(&pb.ListingVariationCreate{Attributes: []*pb.AttributeValue{attr("brand", "Other"), attr("size", "S")}, Price: usd(1500), Quantity: 1}); status.Code(err) != codes.InvalidArgument {
+		t.Errorf("Expected InvalidArgument error for attribute set on listing and variation, got: %v", err)
+	}
+	if _, err := create(variation("S", "red", 0, 1)); status.Code(err) != codes.InvalidArgument {
+		t.Errorf("Expected InvalidArgument error for variation without price, got: %v", err)
+	}
+	if _, err := create(variation("S", "red", 15, 0)); status.Code(err) != codes.InvalidArgument {
+		t.Errorf("Expected InvalidArgument error for variations without stock, got: %v", err)
+	}
+
+	// Test searching matches variation attributes
+	resp, err := listingService.GetListings(ctx, &pb.ListingsRequest{
+		Attributes: []*pb.AttributeFilter{{Name: "size", Values: []string{"L"}}, {Name: "color", Values: []string{"blue"}}},
+	})
+	if err != nil {
+		t.Fatalf("GetListings failed: %v", err)
+	}
+	if len(resp.Listings) != 1 {
+		t.Errorf("Expected listing to match its L/blue variation, got %d listings", len(resp.Listings))
+	}
+	resp, err = listingService.GetListings(ctx, &pb.ListingsRequest{
+		Attributes: []*pb.AttributeFilter{{Name: "size", Values: []string{"L"}}, {Name: "color", Values: []string{"red"}}},
+	})
+	if err != nil {
+		t.Fatalf("GetListings failed: %v", err)
+	}
+	if len(resp.Listings) != 0 {
+		t.Errorf("Expected no match for a combination no variation has, got %d listings", len(resp.Listings))
+	}
+
+	address := &pb.Address{Street: "1 Main St", City: "Springfield", Country: "USA"}
+	large := listing.Variations[2]
+
+	// Test ordering requires an existing variation
+	_, err = orderService.CreateOrder(ctx, &pb.OrderCreate{ListingId: listing.Id, Quantity: 1, ShippingAddress: address})
+	if status.Code(err) != codes.InvalidArgument {
+		t.Errorf("Expected InvalidArgument error for missing variation, got: %v", err)
+	}
+	_, err = orderService.CreateOrder(ctx, &pb.OrderCreate{ListingId: listing.Id, VariationId: 99, Quantity: 1, ShippingAddress: address})
+	if status.Code(err) != codes.NotFound {
+		t.Errorf("Expected NotFound error for unknown variation, got: %v", err)
+	}
+
+	// Test stock is tracked per variation
+	_, err = orderService.CreateOrder(ctx, &pb.OrderCreate{ListingId: listing.Id, VariationId: listing.Variations[1].Id, Quantity: 1, ShippingAddress: address})
+	if status.Code(err) != codes.FailedPrecondition {
+		t.Errorf("Expected FailedPrecondition error for sold out variation, got: %v", err)
+	}
+	order, err := orderService.CreateOrder(ctx, &pb.OrderCreate{ListingId: listing.Id, VariationId: large.Id, Quantity: 2, ShippingAddress: address})
+	if err != nil {
+		t.Fatalf("CreateOrder failed: %v", err)
+	}
+	if order.TotalPrice.MinorUnits != 3600 || order.Items[0].VariationId != large.Id {
+		t.Errorf("Expected total 36 for variation %d, got %v for variation %d", large.Id, order.TotalPrice, order.Items[0].VariationId)
+	}
+	got, _ := listingService.GetListing(ctx, &pb.GetListingRequest{Id: listing.Id})
+	if got.Quantity != 3 || got.Variations[2].Quantity != 1 {
+		t.Errorf("Expected 3 left in total and 1 in L/blue, got %d and %d", got.Quantity, got.Variations[2].Quantity)
+	}
+
+	// Test cancelling returns stock to the variation
+	if _, err := orderService.CancelOrder(ctx, &pb.CancelOrderRequest{Id: order.Id}); err != nil {
+		t.Fatalf("CancelOrder failed: %v", err)
+	}
+	got, _ = listingService.GetListing(ctx, &pb.GetListingRequest{Id: listing.Id})
+	if got.Quantity != 5 || got.Variations[2].Quantity != 3 {
+		t.Errorf("Expected stock restored to 5 and 3, got %d and %d", got.Quantity, got.Variations[2].Quantity)
+	}
+
+	// Test restocking a variation
+	_, err = listingService.UpdateListing(ctx, &pb.UpdateListingRequest{Id: listing.Id, Listing: &pb.ListingUpdate{Quantity: 4}})
+	if status.Code(err) != codes.InvalidArgument {
+		t.Errorf("Expected InvalidArgument error for listing-level quantity, got: %v", err)
+	}
+	_, err = listingService.UpdateListing(ctx, &pb.UpdateListingRequest{Id: listing.Id, Listing: &pb.ListingUpdate{Price: usd(2000)}})
+	if status.Code(err) != codes.InvalidArgument {
+		t.Errorf("Expected InvalidArgument error for listing-level price, got: %v", err)
+	}
+	updated, err := listingService.UpdateListing(ctx, &pb.UpdateListingRequest{
+		Id:      listing.Id,
+		Listing: &pb.ListingUpdate{VariationId: listing.Variations[1].Id, Quantity: 4},
+	})
+	if err != nil {
+		t.Fatalf("UpdateListing failed: %v", err)
+	}
+	if updated.Quantity != 9 || updated.Variations[1].Quantity != 4 {
+		t.Errorf("Expected total 9 with 4 in M/red, got %d and %d", updated.Quantity, updated.Variations[1].Quantity)
+	}
+}
+
+// testImage encodes a w×h image; transparent images are PNGs, others JPEGs.
+// EXIF orientation is covered by the imageproc tests.
+func testImage(t *testing.T, w, h int, transparent bool) []byte {
+	img := image.NewNRGBA(image.Rect(0, 0, w, h))
+	for i := range img.Pix {
+		img.Pix[i] = 0xff
+	}
+	var buf bytes.Buffer
+	if transparent {
+		img.Pix[3] = 0
+		if err := png.Encode(&buf, img); err != nil {
+			t.Fatalf("png.Encode failed: %v", err)
+		}
+		return buf.Bytes()
+	}
+	if err := jpeg.Encode(&buf, img, nil); err != nil {
+		t.Fatalf("jpeg.Encode failed: %v", err)
+	}
+	return buf.Bytes()
+}
+
+func TestImageService(t *testing.T) {
+	store := storage.NewInMemoryStorage()
+	images, pipeline := newTestImages(t, store)
+	listingService := NewListingService(store, images, pipeline, newTestRates(t))
+	imageService := NewImageService(store, images, pipeline)
+	ctx := userContext(t, 1)
+
+	banner := testImage(t, 1000, 500, true)
+	photo := testImage(t, 300, 100, false)
+
+	// Test CreateListing stores image ids instead of image bytes
+	listing, err := listingService.CreateListing(ctx, &pb.ListingCreate{
+		Title:       "Camera",
+		Description: "Film camera",
+		Price:       usd(8000),
+		Images:      [][]byte{banner, photo},
+	})
+	if err != nil {
+		t.Fatalf("CreateListing failed: %v", err)
+	}
+	if len(listing.Images) != 2 {
+		t.Fatalf("Expected 2 image ids, got %d", len(listing.Images))
+	}
+
+	// Wait for the pipeline to render both images
+	pipeline.Wait()
+
+	// Test the listing exposes every rendered size
+	listing, err = listingService.GetListing(ctx, &pb.GetListingRequest{Id: listing.Id})
+	if err != nil {
+		t.Fatalf("GetListing failed: %v", err)
+	}
+	if len(listing.ImageDetails) != 2 {
+		t.Fatalf("Expected details for 2 images, got %d", len(listing.ImageDetails))
+	}
+	sizes := func(details *pb.ListingImage) map[pb.ImageSize]*pb.ImageVariant {
+		if details.Status != pb.ImageStatus_IMAGE_STATUS_READY {
+			t.Fatalf("Expected image %s to be ready, got %v", details.Id, details.Status)
+		}
+		bySize := make(map[pb.ImageSize]*pb.ImageVariant)
+		for _, variant := range details.Variants {
+			bySize[variant.Size] = variant
+		}
+		return bySize
+	}
+	bannerSizes := sizes(listing.ImageDetails[0])
+	if thumb := bannerSizes[pb.ImageSize_IMAGE_SIZE_THUMBNAIL]; thumb.Width != 200 || thumb.Height != 100 || thumb.ContentType != "image/png" {
+		t.Errorf("Expected 200x100 PNG thumbnail, got %dx%d %s", thumb.Width, thumb.Height, thumb.ContentType)
+	}
+	if full := bannerSizes[pb.ImageSize_IMAGE_SIZE_FULL]; full.Width != 1000 || full.ImageId != listing.Images[0] {
+		t.Errorf("Expected full size to keep 1000px under the original id, got %dpx as %s", full.Width, full.ImageId)
+	}
+	photoSizes := sizes(listing.ImageDetails[1])
+	if full := photoSizes[pb.ImageSize_IMAGE_SIZE_FULL]; full.Width != 300 || full.Height != 100 || full.ContentType != "image/jpeg" {
+		t.Errorf("Expected 300x100 JPEG, got %dx%d %s", full.Width, full.Height, full.ContentType)
+	}
+
+	// Test GetImage serves the rendered sizes
+	image, err := imageService.GetImage(ctx, &pb.GetImageRequest{Id: listing.Images[1]})
+	if err != nil {
+		t.Fatalf("GetImage failed: %v", err)
+	}
+	if image.ContentType != "image/jpeg" {
+		t.Errorf("Expected JPEG, got %s", image.ContentType)
+	}
+	thumbnail := photoSizes[pb.ImageSize_IMAGE_SIZE_THUMBNAIL].ImageId
+	if _, err := imageService.GetImage(ctx, &pb.GetImageRequest{Id: thumbnail}); err != nil {
+		t.Errorf("GetImage failed for thumbnail: %v", err)
+	}
+
+	// Test GetImage with unknown and malformed ids
+	_, err = imageService.GetImage(ctx, &pb.GetImageRequest{Id: "00000000000000000000000000000000"})
+	if status.Code(err) != codes.NotFound {
+		t.Errorf("Expected NotFound error for unknown image, got: %v", err)
+	}
+	_, err = imageService.GetImage(ctx, &pb.GetImageRequest{Id: "../listings"})
+	if status.Code(err) != codes.NotFound {
+		t.Errorf("Expected NotFound error for malformed image id, got: %v", err)
+	}
+
+	// Test too many images are rejected before anything is stored
+	_, err = listingService.CreateListing(ctx, &pb.ListingCreate{
+		Title:       "Camera",
+		Description: "Film camera",
+		Price:       usd(8000),
+		Images:      [][]byte{photo, photo, photo, photo, photo, photo},
+	})
+	if status.Code(err) != codes.InvalidArgument {
+		t.Errorf("Expected InvalidArgument error for 6 images, got: %v", err)
+	}
+
+	// Test DeleteListing removes its images and their sizes
+	_, err = listingService.DeleteListing(ctx, &pb.DeleteListingRequest{Id: listing.Id})
+	if err != nil {
+		t.Fatalf("DeleteListing failed: %v", err)
+	}
+	for _, id := range []string{listing.Images[0], thumbnail} {
+		_, err = imageService.GetImage(ctx, &pb.GetImageRequest{Id: id})
+		if status.Code(err) != codes.NotFound {
+			t.Errorf("Expected NotFound error for image of deleted listing, got: %v", err)
+		}
+	}
+}
+
+// fakeUploadStream feeds chunks to UploadImage without a network connection.
+type fakeUploadStream struct {
+	grpc.ServerStream
+	ctx    context.Context
+	chunks [][]byte
+	resp   *pb.UploadImageResponse
+}
+
+func (f *fakeUploadStream) Context() context.Context {
+	return f.ctx
+}
+
+func (f *fakeUploadStream) Recv() (*pb.UploadImageRequest, error) {
+	if len(f.chunks) == 0 {
+		return nil, io.EOF
+	}
+	chunk := f.chunks[0]
+	f.chunks = f.chunks[1:]
+	return &pb.UploadImageRequest{Chunk: chunk}, nil
+}
+
+func (f *fakeUploadStream) SendAndClose(resp *pb.UploadImageResponse) error {
+	f.resp = resp
+	return nil
+}
+
+func TestImageServiceUpload(t *testing.T) {
+	store := storage.NewInMemoryStorage()
+	images, pipeline := newTestImages(t, store)
+	listingService := NewListingService(store, images, pipeline, newTestRates(t))
+	imageService := NewImageService(store, images, pipeline)
+	ctx := userContext(t, 1)
+
+	upload := func(chunks ...[]byte) (*fakeUploadStream, error) {
+		stream := &fakeUploadStream{ctx: ctx, chunks: chunks}
+		return stream, imageService.UploadImage(stream)
+	}
+
+	// Test uploads need a user to own them
+	if err := imageService.UploadImage(&fakeUploadStream{ctx: context.Background()}); status.Code(err) != codes.Unauthenticated {
+		t.Errorf("Expected Unauthenticated error for an anonymous upload, got: %v", err)
+	}
+
+	// Test a chunked upload, with the format header split across chunks
+	photo := testImage(t, 640, 480, true)
+	stream, err := upload(photo[:6], photo[6:100], photo[100:])
+	if err != nil {
+		t.Fatalf("UploadImage failed: %v", err)
+	}
+	if stream.resp.ContentType != "image/png" || stream.resp.Size != int64(len(photo)) {
+		t.Errorf("Expected %d byte PNG image, got %d bytes of %s", len(photo), stream.resp.Size, stream.resp.ContentType)
+	}
+	uploaded := stream.resp.Id
+	pipeline.Wait()
+	image, err := imageService.GetImage(ctx, &pb.GetImageRequest{Id: uploaded})
+	if err != nil {
+		t.Fatalf("GetImage failed: %v", err)
+	}
+	if image.ContentType != "image/png" {
+		t.Errorf("Expected image/png, got %s", image.ContentType)
+	}
+
+	// Test WebP is recognised by its RIFF header
+	webp := append([]byte("RIFF\x00\x00\x00\x00WEBPVP8 "), bytes.Repeat([]byte{0}, 1024)...)
+	stream, err = upload(webp[:10], webp[10:])
+	if err != nil {
+		t.Fatalf("UploadImage failed: %v", err)
+	}
+	if stream.resp.ContentType != "image/webp" {
+		t.Errorf("Expected image/webp, got %s", stream.resp.ContentType)
+	}
+
+	// Test uploads that cannot be decoded are not served or attached
+	broken := stream.resp.Id
+	pipeline.Wait()
+	_, err = imageService.GetImage(ctx, &pb.GetImageRequest{Id: broken})
+	if status.Code(err) != codes.FailedPrecondition {
+		t.Errorf("Expected FailedPrecondition error for undecodable image, got: %v", err)
+	}
+	_, err = listingService.CreateListing(ctx, &pb.ListingCreate{
+		Title:       "Camera",
+		Description: "Film camera",
+		Price:       usd(8000),
+		ImageIds:    []string{broken},
+	})
+	if status.Code(err) != codes.InvalidArgument {
+		t.Errorf("Expected InvalidArgument error for undecodable image, got: %v", err)
+	}
+
+	// Test other content is rejected as soon as the header arrives
+	stream, err = upload([]byte("GIF89a\x01\x00\x01\x00\x00\x00"), []byte("rest"))
+	if status.Code(err) != codes.InvalidArgument {
+		t.Errorf("Expected InvalidArgument error for GIF upload, got: %v", err)
+	}
+	if len(stream.chunks) != 1 {
+		t.Errorf("Expected upload to stop after the first chunk, %d chunks left", len(stream.chunks))
+	}
+	if _, err = upload([]byte("<svg")); status.Code(err) != codes.InvalidArgument {
+		t.Errorf("Expected InvalidArgument error for short non-image upload, got: %v", err)
+	}
+	if _, err = upload(); status.Code(err) != codes.InvalidArgument {
+		t.Errorf("Expected InvalidArgument error for empty upload, got: %v", err)
+	}
+
+	// Test the size limit is enforced while streaming
+	chunk := make([]byte, 1024*1024)
+	copy(chunk, webp)
+	stream, err = upload(chunk, chunk, chunk, chunk, chunk, chunk, chunk)
+	if status.Code(err) != codes.InvalidArgument {
+		t.Errorf("Expected InvalidArgument error for oversized upload, got: %v", err)
+	}
+	if len(stream.chunks) != 1 {
+		t.Errorf("Expected upload to stop at the 6th chunk, %d chunks left", len(stream.chunks))
+	}
+
+	// Test CreateListing references uploaded images
+	listing, err := listingService.CreateListing(ctx, &pb.ListingCreate{
+		Title:       "Camera",
+		Description: "Film camera",
+		Price:       usd(8000),
+		ImageIds:    []string{uploaded},
+	})
+	if err != nil {
+		t.Fatalf("CreateListing failed: %v", err)
+	}
+	if len(listing.Images) != 1 || listing.Images[0] != uploaded {
+		t.Errorf("Expected listing to reference uploaded image, got %v", listing.Images)
+	}
+	if len(listing.ImageDetails) != 1 || listing.ImageDetails[0].Status != pb.ImageStatus_IMAGE_STATUS_READY {
+		t.Errorf("Expected processed image details on the listing, got %v", listing.ImageDetails)
+	}
+	_, err = listingService.CreateListing(ctx, &pb.ListingCreate{
+		Title:       "Camera",
+		Description: "Film camera",
+		Price:       usd(8000),
+		ImageIds:    []string{"00000000000000000000000000000000"},
+	})
+	if status.Code(err) != codes.InvalidArgument {
+		t.Errorf("Expected InvalidArgument error for unknown image id, got: %v", err)
+	}
+	_, err = listingService.CreateListing(ctx, &pb.ListingCreate{
+		Title:       "Camera",
+		Description: "Film camera",
+		Price:       usd(8000),
+		Images:      [][]byte{[]byte("not an image")},
+	})
+	if status.Code(err) != codes.InvalidArgument {
+		t.Errorf("Expected InvalidArgument error for inline non-image, got: %v", err)
+	}
+
+	// Test uploaded images belong to their uploader and to one listing
+	_, err = listingService.CreateListing(userContext(t, 2), &pb.ListingCreate{
+		Title:       "Camera",
+		Description: "Film camera",
+		Price:       usd(8000),
+		ImageIds:    []string{uploaded},
+	})
+	if status.Code(err) != codes.PermissionDenied {
+		t.Errorf("Expected PermissionDenied error for another user's image, got: %v", err)
+	}
+	_, err = listingService.CreateListing(ctx, &pb.ListingCreate{
+		Title:       "Camera",
+		Description: "Film camera",
+		Price:       usd(8000),
+		ImageIds:    []string{uploaded},
+	})
+	if status.Code(err) != codes.FailedPrecondition {
+		t.Errorf("Expected FailedPrecondition error for an image on another listing, got: %v", err)
+	}
+	lens, err := listingService.CreateListing(ctx, &pb.ListingCreate{Title: "Lens", Description: "50mm lens", Price: usd(3000)})
+	if err != nil {
+		t.Fatalf("CreateListing failed: %v", err)
+	}
+	_, err = listingService.AddListingImages(ctx, &pb.AddListingImagesRequest{Id: lens.Id, ImageIds: []string{uploaded}})
+	if status.Code(err) != codes.FailedPrecondition {
+		t.Errorf("Expected FailedPrecondition error for adding an image on another listing, got: %v", err)
+	}
+	_, err = listingService.UpdateListing(ctx, &pb.UpdateListingRequest{Id: lens.Id, Listing: &pb.ListingUpdate{ImageIds: []string{uploaded}}})
+	if status.Code(err) != codes.FailedPrecondition {
+		t.Errorf("Expected FailedPrecondition error for updating to an image on another listing, got: %v", err)
+	}
+	if _, err := listingService.DeleteListing(ctx, &pb.DeleteListingRequest{Id: lens.Id}); err != nil {
+		t.Fatalf("DeleteListing failed: %v", err)
+	}
+	if _, err := imageService.GetImage(ctx, &pb.GetImageRequest{Id: uploaded}); err != nil {
+		t.Errorf("Expected deleting another listing to keep the image, got: %v", err)
+	}
+
+	// Test UpdateListing replaces images and drops the old ones
+	stream, err = upload(photo)
+	if err != nil {
+		t.Fatalf("UploadImage failed: %v", err)
+	}
+	pipeline.Wait()
+	updated, err := listingService.UpdateListing(ctx, &pb.UpdateListingRequest{
+		Id:      listing.Id,
+		Listing: &pb.ListingUpdate{ImageIds: []string{stream.resp.Id}},
+	})
+	if err != nil {
+		t.Fatalf("UpdateListing failed: %v", err)
+	}
+	if len(updated.Images) != 1 || updated.Images[0] != stream.resp.Id {
+		t.Errorf("Expected listing images to be replaced, got %v", updated.Images)
+	}
+	_, err = imageService.GetImage(ctx, &pb.GetImageRequest{Id: uploaded})
+	if status.Code(err) != codes.NotFound {
+		t.Errorf("Expected NotFound error for replaced image, got: %v", err)
+	}
+}
+
+func TestListingServiceImages(t *testing.T) {
+	store := storage.NewInMemoryStorage()
+	images, pipeline := newTestImages(t, store)
+	listingService := NewListingService(store, images, pipeline, newTestRates(t))
+	imageService := NewImageService(store, images, pipeline)
+	ctx := userContext(t, 1)
+
+	photo := testImage(t, 40, 30, false)
+	listing, err := listingService.CreateListing(ctx, &pb.ListingCreate{
+		Title:       "Bike",
+		Description: "Road bike",
+		Price:       usd(30000),
+		Images:      [][]byte{photo, photo},
+	})
+	if err != nil {
+		t.Fatalf("CreateListing failed: %v", err)
+	}
+	first, second := listing.Images[0], listing.Images[1]
+
+	// Test adding images up to the limit
+	listing, err = listingService.AddListingImages(ctx, &pb.AddListingImagesRequest{Id: listing.Id, Images: [][]byte{photo, photo}})
+	if err != nil {
+		t.Fatalf("AddListingImages failed: %v", err)
+	}
+	if len(listing.Images) != 4 || listing.Images[0] != first {
+		t.Errorf("Expected 4 images after the existing ones, got %v", listing.Images)
+	}
+	_, err = listingService.AddListingImages(ctx, &pb.AddListingImagesRequest{Id: listing.Id, Images: [][]byte{photo, photo}})
+	if status.Code(err) != codes.InvalidArgument {
+		t.Errorf("Expected InvalidArgument error for 6 images, got: %v", err)
+	}
+	_, err = listingService.AddListingImages(ctx, &pb.AddListingImagesRequest{Id: listing.Id, ImageIds: []string{second}})
+	if status.Code(err) != codes.InvalidArgument {
+		t.Errorf("Expected InvalidArgument error for adding an image twice, got: %v", err)
+	}
+	_, err = listingService.AddListingImages(ctx, &pb.AddListingImagesRequest{Id: 999, Images: [][]byte{photo}})
+	if status.Code(err) != codes.NotFound {
+		t.Errorf("Expected NotFound error for unknown listing, got: %v", err)
+	}
+
+	// Test setting the primary image
+	third := listing.Images[2]
+	listing, err = listingService.SetPrimaryImage(ctx, &pb.SetPrimaryImageRequest{Id: listing.Id, ImageId: third})
+	if err != nil {
+		t.Fatalf("SetPrimaryImage failed: %v", err)
+	}
+	if listing.Images[0] != third || listing.Images[1] != first || listing.Images[2] != second {
+		t.Errorf("Expected %s to move first, got %v", third, listing.Images)
+	}
+	if listing.ImageDetails[0].Id != third {
+		t.Errorf("Expected image details to follow the new order")
+	}
+	_, err = listingService.SetPrimaryImage(ctx, &pb.SetPrimaryImageRequest{Id: listing.Id, ImageId: "00000000000000000000000000000000"})
+	if status.Code(err) != codes.NotFound {
+		t.Errorf("Expected NotFound error for image not on the listing, got: %v", err)
+	}
+
+	// Test reordering requires every image exactly once
+	reversed := []string{listing.Images[3], listing.Images[2], listing.Images[1], listing.Images[0]}
+	_, err = listingService.ReorderListingImages(ctx, &pb.ReorderListingImagesRequest{Id: listing.Id, ImageIds: reversed[:3]})
+	if status.Code(err) != codes.InvalidArgument {
+		t.Errorf("Expected InvalidArgument error for missing image, got: %v", err)
+	}
+	_, err = listingService.ReorderListingImages(ctx, &pb.ReorderListingImagesRequest{Id: listing.Id, ImageIds: []string{reversed[0], reversed[0], reversed[1], reversed[2]}})
+	if status.Code(err) != codes.InvalidArgument {
+		t.Errorf("Expected InvalidArgument error for repeated image, got: %v", err)
+	}
+	listing, err = listingService.ReorderListingImages(ctx, &pb.ReorderListingImagesRequest{Id: listing.Id, ImageIds: reversed})
+	if err != nil {
+		t.Fatalf("ReorderListingImages failed: %v", err)
+	}
+	for i, id := range reversed {
+		if listing.Images[i] != id {
+			t.Errorf("Expected image %d to be %s, got %s", i, id, listing.Images[i])
+		}
+	}
+
+	// Test removing an image deletes it
+	listing, err = listingService.RemoveListingImage(ctx, &pb.RemoveListingImageRequest{Id: listing.Id, ImageId: first})
+	if err != nil {
+		t.Fatalf("RemoveListingImage failed: %v", err)
+	}
+	if len(listing.Images) != 3 || containsString(listing.Images, first) {
+		t.Errorf("Expected image to be removed, got %v", listing.Images)
+	}
+	pipeline.Wait()
+	_, err = imageService.GetImage(ctx, &pb.GetImageRequest{Id: first})
+	if status.Code(err) != codes.NotFound {
+		t.Errorf("Expected NotFound error for removed image, got: %v", err)
+	}
+	_, err = listingService.RemoveListingImage(ctx, &pb.RemoveListingImageRequest{Id: listing.Id, ImageId: first})
+	if status.Code(err) != codes.NotFound {
+		t.Errorf("Expected NotFound error for removing an image twice, got: %v", err)
+	}
+
+	// Test image details keep a placeholder for images without a record
+	if err := store.DeleteImage(second); err != nil {
+		t.Fatalf("DeleteImage failed: %v", err)
+	}
+	listing, err = listingService.GetListing(ctx, &pb.GetListingRequest{Id: listing.Id})
+	if err != nil {
+		t.Fatalf("GetListing failed: %v", err)
+	}
+	if len(listing.ImageDetails) != len(listing.Images) {
+		t.Fatalf("Expected %d image details, got %d", len(listing.Images), len(listing.ImageDetails))
+	}
+	for i, detail := range listing.ImageDetails {
+		missing := detail.Status == pb.ImageStatus_IMAGE_STATUS_MISSING
+		if detail.Id != listing.Images[i] || missing != (detail.Id == second) {
+			t.Errorf("Expected details of %s with only %s missing, got %v", listing.Images[i], second, detail)
+		}
+	}
+
+	// Test only the seller can change images
+	other := &pb.Listing{Title: "Someone else's", UserId: 2}
+	if err := store.CreateListing(other); err != nil {
+		t.Fatalf("CreateListing failed: %v", err)
+	}
+	_, err = listingService.RemoveListingImage(ctx, &pb.RemoveListingImageRequest{Id: other.Id, ImageId: second})
+	if status.Code(err) != codes.PermissionDenied {
+		t.Errorf("Expected PermissionDenied error for another seller's listing, got: %v", err)
+	}
+
+	// Test a failed update leaves its images free for other listings
+	unused := &pb.ListingImage{Id: "11111111111111111111111111111111", Status: pb.ImageStatus_IMAGE_STATUS_READY, OwnerId: 1}
+	if err := store.CreateImage(unused); err != nil {
+		t.Fatalf("CreateImage failed: %v", err)
+	}
+	auction := &pb.Listing{Title: "Lens", UserId: 1, Auction: &pb.Auction{}}
+	if err := store.CreateListing(auction); err != nil {
+		t.Fatalf("CreateListing failed: %v", err)
+	}
+	update := &pb.Listing{Title: "Lens", UserId: 1, Images: []string{unused.Id}}
+	if err := store.UpdateListingBestOffer(auction.Id, update, 1, &pb.BestOffer{}); err == nil {
+		t.Fatalf("Expected Best Offer on an auction to fail")
+	}
+	if got, _ := store.GetImage(unused.Id); got.ListingId != 0 {
+		t.Errorf("Expected the image to stay unclaimed, got listing %d", got.ListingId)
+	}
+}
+
+func TestUpdateMasks(t *testing.T) {
+	store := storage.NewInMemoryStorage()
+	listingService := newTestListingService(t, store)
+	userService := NewUserService(store)
+	orderService := NewOrderService(store, newTestRates(t))
+	ctx := userContext(t, 1)
+
+	listing, err := listingService.CreateListing(ctx, &pb.ListingCreate{
+		Title:       "Lamp",
+		Description: "Desk lamp",
+		Price:       usd(2000),
+		Location:    "Berlin",
+		Coordinates: &pb.GeoPoint{Latitude: 52.52, Longitude: 13.405},
+		Quantity:    3,
+	})
+	if err != nil {
+		t.Fatalf("CreateListing failed: %v", err)
+	}
+
+	// Test masked fields are cleared and other fields are left alone
+	updated, err := listingService.UpdateListing(ctx, &pb.UpdateListingRequest{
+		Id:         listing.Id,
+		Listing:    &pb.ListingUpdate{Title: "Ignored", Price: usd(2500)},
+		UpdateMask: &fieldmaskpb.FieldMask{Paths: []string{"description", "location", "coordinates", "price"}},
+	})
+	if err != nil {
+		t.Fatalf("UpdateListing failed: %v", err)
+	}
+	if updated.Description != "" || updated.Location != "" || updated.Coordinates != nil {
+		t.Errorf("Expected description, location and coordinates to be cleared, got %+v", updated)
+	}
+	if updated.Title != "Lamp" || updated.Price.MinorUnits != 2500 {
+		t.Errorf("Expected only masked fields to change, got title %q and price %v", updated.Title, updated.Price)
+	}
+
+	// Test without a mask empty fields are still ignored
+	updated, err = listingService.UpdateListing(ctx, &pb.UpdateListingRequest{
+		Id:      listing.Id,
+		Listing: &pb.ListingUpdate{Description: "Brass desk lamp"},
+	})
+	if err != nil {
+		t.Fatalf("UpdateListing failed: %v", err)
+	}
+	if updated.Description != "Brass desk lamp" || updated.Title != "Lamp" {
+		t.Errorf("Expected only the description to change, got %+v", updated)
+	}
+
+	// Test a masked zero quantity marks the listing sold out
+	updated, err = listingService.UpdateListing(ctx, &pb.UpdateListingRequest{
+		Id:         listing.Id,
+		Listing:    &pb.ListingUpdate{},
+		UpdateMask: &fieldmaskpb.FieldMask{Paths: []string{"quantity"}},
+	})
+	if err != nil {
+		t.Fatalf("UpdateListing failed: %v", err)
+	}
+	if updated.Quantity != 0 || updated.Status != pb.ListingStatus_LISTING_STATUS_SOLD {
+		t.Errorf("Expected sold out listing, got quantity %d and status %v", updated.Quantity, updated.Status)
+	}
+
+	// Test required fields cannot be cleared and unknown paths are rejected
+	_, err = listingService.UpdateListing(ctx, &pb.UpdateListingRequest{
+		Id:         listing.Id,
+		Listing:    &pb.ListingUpdate{},
+		UpdateMask: &fieldmaskpb.FieldMask{Paths: []string{"title"}},
+	})
+	if status.Code(err) != codes.InvalidArgument {
+		t.Errorf("Expected InvalidArgument error for clearing the title, got: %v", err)
+	}
+	_, err = listingService.UpdateListing(ctx, &pb.UpdateListingRequest{
+		Id:         listing.Id,
+		Listing:    &pb.ListingUpdate{},
+		UpdateMask: &fieldmaskpb.FieldMask{Paths: []string{"user_id"}},
+	})
+	if status.Code(err) != codes.InvalidArgument {
+		t.Errorf("Expected InvalidArgument error for unknown path, got: %v", err)
+	}
+
+	// Test user updates
+	user, err := userService.CreateUser(ctx, &pb.UserCreate{Username: "masked", Email: "masked@example.com", Password: "secret"})
+	if err != nil {
+		t.Fatalf("CreateUser failed: %v", err)
+	}
+	updatedUser, err := userService.UpdateUser(ctx, &pb.UpdateUserRequest{
+		Id:         user.Id,
+		User:       &pb.UserUpdate{Username: "renamed", Email: "ignored@example.com"},
+		UpdateMask: &fieldmaskpb.FieldMask{Paths: []string{"username"}},
+	})
+	if err != nil {
+		t.Fatalf("UpdateUser failed: %v", err)
+	}
+	if updatedUser.Username != "renamed" || updatedUser.Email != "masked@example.com" {
+		t.Errorf("Expected only the username to change, got %+v", updatedUser)
+	}
+	_, err = userService.UpdateUser(ctx, &pb.UpdateUserRequest{
+		Id:         user.Id,
+		User:       &pb.UserUpdate{},
+		UpdateMask: &fieldmaskpb.FieldMask{Paths: []string{"email"}},
+	})
+	if status.Code(err) != codes.InvalidArgument {
+		t.Errorf("Expected InvalidArgument error for clearing the email, got: %v", err)
+	}
+	_, err = userService.UpdateUser(ctx, &pb.UpdateUserRequest{
+		Id:         user.Id,
+		User:       &pb.UserUpdate{},
+		UpdateMask: &fieldmaskpb.FieldMask{Paths: []string{"id"}},
+	})
+	if status.Code(err) != codes.InvalidArgument {
+		t.Errorf("Expected InvalidArgument error for unknown path, got: %v", err)
+	}
+
+	// Test order updates
+	other, err := listingService.CreateListing(ctx, &pb.ListingCreate{Title: "Chair", Description: "Office chair", Price: usd(5000), Quantity: 2})
+	if err != nil {
+		t.Fatalf("CreateListing failed: %v", err)
+	}
+	order, err := orderService.CreateOrder(ctx, &pb.OrderCreate{
+		ListingId:       other.Id,
+		Quantity:        1,
+		ShippingAddress: &pb.Address{Street: "1 Main St", City: "Berlin", Country: "Germany"},
+	})
+	if err != nil {
+		t.Fatalf("CreateOrder failed: %v", err)
+	}
+	updatedOrder, err := orderService.UpdateOrder(ctx, &pb.UpdateOrderRequest{
+		Id:         order.Id,
+		Order:      &pb.OrderUpdate{Items: []*pb.OrderItemCreate{{ListingId: other.Id, Quantity: 2}}, ShippingCost: usd(500)},
+		UpdateMask: &fieldmaskpb.FieldMask{Paths: []string{"shipping_cost"}},
+	})
+	if err != nil {
+		t.Fatalf("UpdateOrder failed: %v", err)
+	}
+	if updatedOrder.TotalPrice.MinorUnits != 5500 || updatedOrder.Items[0].Quantity != 1 {
+		t.Errorf("Expected only the shipping cost to be added, got %+v", updatedOrder)
+	}
+	_, err = orderService.UpdateOrder(ctx, &pb.UpdateOrderRequest{
+		Id:         order.Id,
+		Order:      &pb.OrderUpdate{},
+		UpdateMask: &fieldmaskpb.FieldMask{Paths: []string{"items"}},
+	})
+	if status.Code(err) != codes.InvalidArgument {
+		t.Errorf("Expected InvalidArgument error for clearing the items, got: %v", err)
+	}
+	_, err = orderService.UpdateOrder(ctx, &pb.UpdateOrderRequest{
+		Id:         order.Id,
+		Order:      &pb.OrderUpdate{},
+		UpdateMask: &fieldmaskpb.FieldMask{Paths: []string{"status"}},
+	})
+	if status.Code(err) != codes.InvalidArgument {
+		t.Errorf("Expected InvalidArgument error for unknown path, got: %v", err)
+	}
+
+	// Test variation_id only selects what quantity restocks
+	_, err = listingService.UpdateListing(ctx, &pb.UpdateListingRequest{
+		Id:         listing.Id,
+		Listing:    &pb.ListingUpdate{},
+		UpdateMask: &fieldmaskpb.FieldMask{Paths: []string{"variation_id"}},
+	})
+	if status.Code(err) != codes.InvalidArgument {
+		t.Errorf("Expected InvalidArgument error for variation_id path, got: %v", err)
+	}
+
+	// Test masks without the update itself are rejected
+	mask := &fieldmaskpb.FieldMask{Paths: []string{"title", "username", "user_id"}}
+	_, err = listingService.UpdateListing(ctx, &pb.UpdateListingRequest{Id: listing.Id, UpdateMask: mask})
+	if status.Code(err) != codes.InvalidArgument {
+		t.Errorf("Expected InvalidArgument error for missing listing, got: %v", err)
+	}
+	_, err = userService.UpdateUser(ctx, &pb.UpdateUserRequest{Id: user.Id, UpdateMask: mask})
+	if status.Code(err) != codes.InvalidArgument {
+		t.Errorf("Expected InvalidArgument error for missing user, got: %v", err)
+	}
+	_, err = userService.ReplaceUser(ctx, &pb.UpdateUserRequest{Id: user.Id})
+	if status.Code(err) != codes.InvalidArgument {
+		t.Errorf("Expected InvalidArgument error for replacing with no user, got: %v", err)
+	}
+	_, err = orderService.UpdateOrder(ctx, &pb.UpdateOrderRequest{Id: order.Id, UpdateMask: mask})
+	if status.Code(err) != codes.InvalidArgument {
+		t.Errorf("Expected InvalidArgument error for missing order, got: %v", err)
+	}
+}
+
+func TestOptimisticConcurrency(t *testing.T) {
+	store := storage.NewInMemoryStorage()
+	listingService := newTestListingService(t, store)
+	userService := NewUserService(store)
+	orderService := NewOrderService(store, newTestRates(t))
+	ctx := userContext(t, 1)
+
+	listing, err := listingService.CreateListing(ctx, &pb.ListingCreate{Title: "Desk", Description: "Oak desk", Price: usd(12000), Quantity: 5})
+	if err != nil {
+		t.Fatalf("CreateListing failed: %v", err)
+	}
+	if listing.Version != 1 {
+		t.Errorf("Expected new listing at version 1, got %d", listing.Version)
+	}
+
+	// Test an update based on the current version succeeds and bumps it
+	updated, err := listingService.UpdateListing(ctx, &pb.UpdateListingRequest{
+		Id:              listing.Id,
+		Listing:         &pb.ListingUpdate{Price: usd(11000)},
+		ExpectedVersion: 1,
+	})
+	if err != nil {
+		t.Fatalf("UpdateListing failed: %v", err)
+	}
+	if updated.Version != 2 {
+		t.Errorf("Expected version 2 after update, got %d", updated.Version)
+	}
+
+	// Test a stale update is rejected and leaves the listing alone
+	_, err = listingService.UpdateListing(ctx, &pb.UpdateListingRequest{
+		Id:              listing.Id,
+		Listing:         &pb.ListingUpdate{Price: usd(10000)},
+		ExpectedVersion: 1,
+	})
+	if status.Code(err) != codes.Aborted {
+		t.Errorf("Expected Aborted error for stale version, got: %v", err)
+	}
+	if current, _ := store.GetListing(listing.Id); current.Price.MinorUnits != 11000 {
+		t.Errorf("Expected stale update to be discarded, got price %v", current.Price)
+	}
+
+	// Test sales and status changes also move the version
+	order, err := orderService.CreateOrder(ctx, &pb.OrderCreate{
+		ListingId:       listing.Id,
+		Quantity:        1,
+		ShippingAddress: &pb.Address{Street: "1 Main St", City: "Berlin", Country: "Germany"},
+	})
+	if err != nil {
+		t.Fatalf("CreateOrder failed: %v", err)
+	}
+	paused, err := listingService.PauseListing(ctx, &pb.ListingActionRequest{Id: listing.Id})
+	if err != nil {
+		t.Fatalf("PauseListing failed: %v", err)
+	}
+	if paused.Version != 4 {
+		t.Errorf("Expected version 4 after a sale and a pause, got %d", paused.Version)
+	}
+
+	// Test only one of two concurrent updates from the same version wins
+	var wg sync.WaitGroup
+	errs := make(chan error, 2)
+	for _, title := range []string{"Walnut desk", "Pine desk"} {
+		wg.Add(1)
+		go func(title string) {
+			defer wg.Done()
+			_, err := listingService.UpdateListing(ctx, &pb.UpdateListingRequest{
+				Id:              listing.Id,
+				Listing:         &pb.ListingUpdate{Title: title},
+				ExpectedVersion: paused.Version,
+			})
+			errs <- err
+		}(title)
+	}
+	wg.Wait()
+	close(errs)
+	aborted := 0
+	for err := range errs {
+		if status.Code(err) == codes.Aborted {
+			aborted++
+		} else if err != nil {
+			t.Errorf("Unexpected error: %v", err)
+		}
+	}
+	if aborted != 1 {
+		t.Errorf("Expected exactly one concurrent update to be aborted, got %d", aborted)
+	}
+
+	// Test orders
+	if order.Version != 1 {
+		t.Errorf("Expected new order at version 1, got %d", order.Version)
+	}
+	_, err = orderService.UpdateOrder(ctx, &pb.UpdateOrderRequest{
+		Id:              order.Id,
+		Order:           &pb.OrderUpdate{ShippingCost: usd(500)},
+		ExpectedVersion: 2,
+	})
+	if status.Code(err) != codes.Aborted {
+		t.Errorf("Expected Aborted error for stale order version, got: %v", err)
+	}
+	updatedOrder, err := orderService.UpdateOrder(ctx, &pb.UpdateOrderRequest{
+		Id:              order.Id,
+		Order:           &pb.OrderUpdate{ShippingCost: usd(500)},
+		ExpectedVersion: 1,
+	})
+	if err != nil {
+		t.Fatalf("UpdateOrder failed: %v", err)
+	}
+	if updatedOrder.Version != 2 {
+		t.Errorf("Expected order version 2, got %d", updatedOrder.Version)
+	}
+
+	// Test users
+	user, err := userService.CreateUser(ctx, &pb.UserCreate{Username: "versioned", Email: "versioned@example.com", Password: "secret"})
+	if err != nil {
+		t.Fatalf("CreateUser failed: %v", err)
+	}
+	_, err = userService.UpdateUser(ctx, &pb.UpdateUserRequest{
+		Id:              user.Id,
+		User:            &pb.UserUpdate{Username: "first"},
+		ExpectedVersion: user.Version,
+	})
+	if err != nil {
+		t.Fatalf("UpdateUser failed: %v", err)
+	}
+	_, err = userService.ReplaceUser(ctx, &pb.UpdateUserRequest{
+		Id:              user.Id,
+		User:            &pb.UserUpdate{Username: "second", Email: "versioned@example.com"},
+		ExpectedVersion: user.Version,
+	})
+	if status.Code(err) != codes.Aborted {
+		t.Errorf("Expected Aborted error for stale user version, got: %v", err)
+	}
+}
+
+func TestListingServiceHistory(t *testing.T) {
+	store := storage.NewInMemoryStorage()
+	listingService := newTestListingService(t, store)
+	orderService := NewOrderService(store, newTestRates(t))
+	ctx := userContext(t, 1)
+
+	listing, err := listingService.CreateListing(ctx, &pb.ListingCreate{
+		Title:       "Camera",
+		Description: "Mirrorless camera",
+		Price:       usd(50000),
+		Condition:   "new",
+		Quantity:    3,
+	})
+	if err != nil {
+		t.Fatalf("CreateListing failed: %v", err)
+	}
+	if listing.Revision != 1 {
+		t.Errorf("Expected new listing at revision 1, got %d", listing.Revision)
+	}
+
+	// Test orders snapshot the revision they were placed against
+	order, err := orderService.CreateOrder(ctx, &pb.OrderCreate{
+		ListingId:       listing.Id,
+		Quantity:        1,
+		ShippingAddress: &pb.Address{Street: "1 Main St", City: "Berlin", Country: "Germany"},
+	})
+	if err != nil {
+		t.Fatalf("CreateOrder failed: %v", err)
+	}
+	if order.Items[0].ListingRevision != 1 {
+		t.Errorf("Expected order placed against revision 1, got %d", order.Items[0].ListingRevision)
+	}
+
+	// Test edits record which fields changed
+	updated, err := listingService.UpdateListing(ctx, &pb.UpdateListingRequest{
+		Id:      listing.Id,
+		Listing: &pb.ListingUpdate{Condition: "used", Price: usd(45000)},
+	})
+	if err != nil {
+		t.Fatalf("UpdateListing failed: %v", err)
+	}
+	if updated.Revision != 2 {
+		t.Errorf("Expected revision 2 after edit, got %d", updated.Revision)
+	}
+
+	// Test restocking is not a revision
+	updated, err = listingService.UpdateListing(ctx, &pb.UpdateListingRequest{
+		Id:      listing.Id,
+		Listing: &pb.ListingUpdate{Quantity: 10},
+	})
+	if err != nil {
+		t.Fatalf("UpdateListing failed: %v", err)
+	}
+	if updated.Revision != 2 {
+		t.Errorf("Expected restock to keep revision 2, got %d", updated.Revision)
+	}
+
+	// Test image changes are revisions too
+	_, err = listingService.AddListingImages(ctx, &pb.AddListingImagesRequest{Id: listing.Id, Images: [][]byte{testImage(t, 20, 20, false)}})
+	if err != nil {
+		t.Fatalf("AddListingImages failed: %v", err)
+	}
+
+	history, err := listingService.GetListingHistory(ctx, &pb.GetListingHistoryRequest{Id: listing.Id})
+	if err != nil {
+		t.Fatalf("GetListingHistory failed: %v", err)
+	}
+	if len(history.Revisions) != 3 {
+		t.Fatalf("Expected 3 revisions, got %d", len(history.Revisions))
+	}
+	wantChanged := [][]string{nil, {"price", "condition"}, {"images"}}
+	for i, revision := range history.Revisions {
+		if revision.Revision != int32(i+1) || revision.UserId != 1 || revision.CreatedAt == nil {
+			t.Errorf("Revision %d: unexpected %+v", i+1, revision)
+		}
+		if len(revision.ChangedFields) != len(wantChanged[i]) {
+			t.Errorf("Revision %d: expected changed fields %v, got %v", i+1, wantChanged[i], revision.ChangedFields)
+			continue
+		}
+		for j, field := range wantChanged[i] {
+			if revision.ChangedFields[j] != field {
+				t.Errorf("Revision %d: expected changed fields %v, got %v", i+1, wantChanged[i], revision.ChangedFields)
+			}
+		}
+	}
+
+	// Test the order's revision shows what the listing said at purchase
+	if _, err := listingService.DeleteListing(ctx, &pb.DeleteListingRequest{Id: listing.Id}); err != nil {
+		t.Fatalf("DeleteListing failed: %v", err)
+	}
+	history, err = listingService.GetListingHistory(ctx, &pb.GetListingHistoryRequest{Id: listing.Id, Revision: order.Items[0].ListingRevision})
+	if err != nil {
+		t.Fatalf("GetListingHistory failed: %v", err)
+	}
+	if len(history.Revisions) != 1 || history.Revisions[0].Listing.Condition != "new" || history.Revisions[0].Listing.Price.MinorUnits != 50000 {
+		t.Errorf("Expected revision 1 to show the listing as new for 500, got %+v", history.Revisions)
+	}
+
+	_, err = listingService.GetListingHistory(ctx, &pb.GetListingHistoryRequest{Id: listing.Id, Revision: 9})
+	if status.Code(err) != codes.NotFound {
+		t.Errorf("Expected NotFound error for unknown revision, got: %v", err)
+	}
+	_, err = listingService.GetListingHistory(ctx, &pb.GetListingHistoryRequest{Id: 999})
+	if status.Code(err) != codes.NotFound {
+		t.Errorf("Expected NotFound error for unknown listing, got: %v", err)
+	}
+}
+
+func TestListingServiceMoney(t *testing.T) {
+	store := storage.NewInMemoryStorage()
+	listingService := newTestListingService(t, store)
+	orderService := NewOrderService(store, newTestRates(t))
+	ctx := userContext(t, 1)
+
+	// Test prices keep their currency and totals are exact
+	pen, err := listingService.CreateListing(ctx, &pb.ListingCreate{Title: "Pen", Description: "Ballpoint pen", Price: money.New("EUR", 10), Quantity: 10})
+	if err != nil {
+		t.Fatalf("CreateListing failed: %v", err)
+	}
+	order, err := orderService.CreateOrder(ctx, &pb.OrderCreate{
+		ListingId:       pen.Id,
+		Quantity:        3,
+		ShippingAddress: &pb.Address{Street: "1 Main St", City: "Paris", Country: "France"},
+	})
+	if err != nil {
+		t.Fatalf("CreateOrder failed: %v", err)
+	}
+	if order.TotalPrice.MinorUnits != 30 || order.TotalPrice.CurrencyCode != "EUR" {
+		t.Errorf("Expected total of 0.30 EUR, got %v", order.TotalPrice)
+	}
+	if _, err := listingService.CreateListing(ctx, &pb.ListingCreate{Title: "Tea", Description: "Green tea", Price: money.New("JPY", 1200)}); err != nil {
+		t.Errorf("Expected JPY listing to be accepted, got: %v", err)
+	}
+
+	// Test invalid prices
+	invalid := []*pb.Money{nil, money.New("XYZ", 100), money.New("", 100), money.New("USD", 0), money.New("USD", -5)}
+	for _, price := range invalid {
+		_, err := listingService.CreateListing(ctx, &pb.ListingCreate{Title: "Invalid", Description: "Invalid price", Price: price})
+		if status.Code(err) != codes.InvalidArgument {
+			t.Errorf("Expected InvalidArgument error for price %v, got: %v", price, err)
+		}
+	}
+
+	// Test variations share one currency
+	_, err = listingService.CreateListing(ctx, &pb.ListingCreate{
+		Title:       "Socks",
+		Description: "Wool socks",
+		Variations: []*pb.ListingVariationCreate{
+			{Attributes: []*pb.AttributeValue{{Name: "size", Value: &pb.AttributeValue_StringValue{StringValue: "S"}}}, Price: usd(500), Quantity: 1},
+			{Attributes: []*pb.AttributeValue{{Name: "size", Value: &pb.AttributeValue_StringValue{StringValue: "L"}}}, Price: money.New("EUR", 500), Quantity: 1},
+		},
+	})
+	if status.Code(err) != codes.InvalidArgument {
+		t.Errorf("Expected InvalidArgument error for variations in different currencies, got: %v", err)
+	}
+
+	// Test updates cannot change the currency
+	_, err = listingService.UpdateListing(ctx, &pb.UpdateListingRequest{Id: pen.Id, Listing: &pb.ListingUpdate{Price: usd(20)}})
+	if status.Code(err) != codes.InvalidArgument {
+		t.Errorf("Expected InvalidArgument error for changing the currency, got: %v", err)
+	}
+	_, err = orderService.UpdateOrder(ctx, &pb.UpdateOrderRequest{Id: order.Id, Order: &pb.OrderUpdate{ShippingCost: usd(30)}})
+	if status.Code(err) != codes.InvalidArgument {
+		t.Errorf("Expected InvalidArgument error for changing the order currency, got: %v", err)
+	}
+
+	// Test price filters in EUR
+	resp, err := listingService.GetListings(ctx, &pb.ListingsRequest{PriceMin: money.New("EUR", 5), PriceMax: money.New("EUR", 10)})
+	if err != nil {
+		t.Fatalf("GetListings failed: %v", err)
+	}
+	if len(resp.Listings) != 1 || resp.Listings[0].Id != pen.Id {
+		t.Errorf("Expected only the pen to match, got %d listings", len(resp.Listings))
+	}
+	_, err = listingService.GetListings(ctx, &pb.ListingsRequest{PriceMin: money.New("EUR", 5), PriceMax: usd(10)})
+	if status.Code(err) != codes.InvalidArgument {
+		t.Errorf("Expected InvalidArgument error for bounds in different currencies, got: %v", err)
+	}
+}
+
+func TestListingServiceCurrencyConversion(t *testing.T) {
+	store := storage.NewInMemoryStorage()
+	listingService := newTestListingService(t, store)
+	orderService := NewOrderService(store, newTestRates(t))
+	ctx := userContext(t, 1)
+
+	lamp, err := listingService.CreateListing(ctx, &pb.ListingCreate{Title: "Lamp", Description: "Desk lamp", Price: money.New("EUR", 1800), Quantity: 5})
+	if err != nil {
+		t.Fatalf("CreateListing failed: %v", err)
+	}
+	chair, err := listingService.CreateListing(ctx, &pb.ListingCreate{Title: "Chair", Description: "Office chair", Price: usd(5000), Quantity: 5})
+	if err != nil {
+		t.Fatalf("CreateListing failed: %v", err)
+	}
+	watch, err := listingService.CreateListing(ctx, &pb.ListingCreate{Title: "Watch", Description: "Swiss watch", Price: money.New("CHF", 9000), Quantity: 1})
+	if err != nil {
+		t.Fatalf("CreateListing failed: %v", err)
+	}
+
+	// Test price filters convert listing prices: 18.00 EUR is 20.00 USD
+	resp, err := listingService.GetListings(ctx, &pb.ListingsRequest{Currency: "USD", PriceMin: usd(1500), PriceMax: usd(2500)})
+	if err != nil {
+		t.Fatalf("GetListings failed: %v", err)
+	}
+	if len(resp.Listings) != 1 || resp.Listings[0].Id != lamp.Id {
+		t.Errorf("Expected only the lamp to match, got %d listings", len(resp.Listings))
+	}
+	if price := resp.ConvertedPrices[lamp.Id]; price == nil || price.CurrencyCode != "USD" || price.MinorUnits != 2000 {
+		t.Errorf("Expected lamp at 20.00 USD, got %v", price)
+	}
+	if resp.Listings[0].Price.CurrencyCode != "EUR" {
+		t.Errorf("Expected the listing to keep its EUR price, got %v", resp.Listings[0].Price)
+	}
+
+	// Test listings without a rate are left out of the converted prices
+	resp, err = listingService.GetListings(ctx, &pb.ListingsRequest{Currency: "GBP"})
+	if err != nil {
+		t.Fatalf("GetListings failed: %v", err)
+	}
+	if price := resp.ConvertedPrices[chair.Id]; price == nil || price.MinorUnits != 4000 {
+		t.Errorf("Expected chair at 40.00 GBP, got %v", price)
+	}
+	if _, ok := resp.ConvertedPrices[watch.Id]; ok {
+		t.Errorf("Expected no converted price for a CHF listing")
+	}
+
+	// Test bounds must be in the requested currency
+	_, err = listingService.GetListings(ctx, &pb.ListingsRequest{Currency: "USD", PriceMin: money.New("EUR", 100)})
+	if status.Code(err) != codes.InvalidArgument {
+		t.Errorf("Expected InvalidArgument error for bounds in another currency, got: %v", err)
+	}
+	_, err = listingService.GetListings(ctx, &pb.ListingsRequest{Currency: "XYZ"})
+	if status.Code(err) != codes.InvalidArgument {
+		t.Errorf("Expected InvalidArgument error for unknown currency, got: %v", err)
+	}
+
+	// Test orders record the converted total and the rate
+	address := &pb.Address{Street: "1 Main St", City: "London", Country: "UK"}
+	order, err := orderService.CreateOrder(ctx, &pb.OrderCreate{ListingId: chair.Id, Quantity: 2, ShippingAddress: address, Currency: "JPY"})
+	if err != nil {
+		t.Fatalf("CreateOrder failed: %v", err)
+	}
+	if order.TotalPrice.MinorUnits != 10000 || order.TotalPrice.CurrencyCode != "USD" {
+		t.Errorf("Expected total of 100.00 USD, got %v", order.TotalPrice)
+	}
+	if order.ConvertedTotal.GetMinorUnits() != 15000 || order.ConvertedTotal.GetCurrencyCode() != "JPY" {
+		t.Errorf("Expected converted total of 15000 JPY, got %v", order.ConvertedTotal)
+	}
+	if order.ExchangeRate.GetRate() != "150" || order.ExchangeRate.GetFromCurrency() != "USD" {
+		t.Errorf("Expected rate of 150 from USD, got %v", order.ExchangeRate)
+	}
+
+	// Test charges added later are converted at the recorded rate
+	updated, err := orderService.UpdateOrder(ctx, &pb.UpdateOrderRequest{Id: order.Id, Order: &pb.OrderUpdate{Tax: usd(1000)}})
+	if err != nil {
+		t.Fatalf("UpdateOrder failed: %v", err)
+	}
+	if updated.ConvertedTotal.GetMinorUnits() != 16500 {
+		t.Errorf("Expected converted total of 16500 JPY, got %v", updated.ConvertedTotal)
+	}
+
+	// Test orders in the listing's currency are not converted
+	order, err = orderService.CreateOrder(ctx, &pb.OrderCreate{ListingId: chair.Id, Quantity: 1, ShippingAddress: address, Currency: "USD"})
+	if err != nil {
+		t.Fatalf("CreateOrder failed: %v", err)
+	}
+	if order.ConvertedTotal != nil || order.ExchangeRate != nil {
+		t.Errorf("Expected no conversion for a USD order, got %v at %v", order.ConvertedTotal, order.ExchangeRate)
+	}
+
+	// Test missing rates and unknown currencies
+	_, err = orderService.CreateOrder(ctx, &pb.OrderCreate{ListingId: watch.Id, Quantity: 1, ShippingAddress: address, Currency: "USD"})
+	if status.Code(err) != codes.FailedPrecondition {
+		t.Errorf("Expected FailedPrecondition error for missing rate, got: %v", err)
+	}
+	_, err = orderService.CreateOrder(ctx, &pb.OrderCreate{ListingId: chair.Id, Quantity: 1, ShippingAddress: address, Currency: "XYZ"})
+	if status.Code(err) != codes.InvalidArgument {
+		t.Errorf("Expected InvalidArgument error for unknown currency, got: %v", err)
+	}
+}
+
+// userContext returns a context authenticated as the given user, as if the
+// request carried a token from Login.
+func userContext(t *testing.T, userID int32) context.Context {
+	token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{"user_id": userID}).SignedString(jwtSecret)
+	if err != nil {
+		t.Fatalf("SignedString failed: %v", err)
+	}
+	return metadata.NewIncomingContext(context.Background(), metadata.Pairs("authorization", "Bearer "+token))
+}
+
+func TestBiddingService(t *testing.T) {
+	store := storage.NewInMemoryStorage()
+	listingService := newTestListingService(t, store)
+	orderService := NewOrderService(store, newTestRates(t))
+	biddingService := NewBiddingService(store)
+	seller, alice, bob := userContext(t, 1), userContext(t, 2), userContext(t, 3)
+	address := &pb.Address{Street: "1 Main St", City: "Springfield", Country: "USA"}
+
+	// Test creating an auction
+	listing, err := listingService.CreateListing(seller, &pb.ListingCreate{
+		Title:       "Vintage camera",
+		Description: "Film camera from 1975",
+		Format:      pb.ListingFormat_LISTING_FORMAT_AUCTION,
+		Auction:     &pb.AuctionCreate{StartPrice: usd(1000), ReservePrice: usd(2000)},
+		Duration:    durationpb.New(time.Hour),
+	})
+	if err != nil {
+		t.Fatalf("CreateListing failed: %v", err)
+	}
+	if listing.Price.MinorUnits != 1000 || listing.Quantity != 1 || listing.Auction.MinimumBid.MinorUnits != 1000 {
+		t.Errorf("Expected auction starting at 10.00 USD, got price %v auction %v", listing.Price, listing.Auction)
+	}
+	if listing.Auction.ReserveMet {
+		t.Errorf("Expected reserve not to be met before bidding")
+	}
+
+	// Test invalid auctions
+	invalid := []*pb.ListingCreate{
+		{Title: "A", Description: "No terms", Format: pb.ListingFormat_LISTING_FORMAT_AUCTION, Duration: durationpb.New(time.Hour)},
+		{Title: "A", Description: "No end", Format: pb.ListingFormat_LISTING_FORMAT_AUCTION, Auction: &pb.AuctionCreate{StartPrice: usd(100)}},
+		{Title: "A", Description: "Price", Format: pb.ListingFormat_LISTING_FORMAT_AUCTION, Auction: &pb.AuctionCreate{StartPrice: usd(100)}, Price: usd(100), Duration: durationpb.New(time.Hour)},
+		{Title: "A", Description: "Low reserve", Format: pb.ListingFormat_LISTING_FORMAT_AUCTION, Auction: &pb.AuctionCreate{StartPrice: usd(100), ReservePrice: usd(50)}, Duration: durationpb.New(time.Hour)},
+		{Title: "A", Description: "Relist", Format: pb.ListingFormat_LISTING_FORMAT_AUCTION, Auction: &pb.AuctionCreate{StartPrice: usd(100)}, Duration: durationpb.New(time.Hour), AutoRelist: true},
+		{Title: "A", Description: "Fixed price", Price: usd(100), Auction: &pb.AuctionCreate{StartPrice: usd(100)}},
+	}
+	for _, req := range invalid {
+		if _, err := listingService.CreateListing(seller, req); status.Code(err) != codes.InvalidArgument {
+			t.Errorf("Expected InvalidArgument error for %q, got: %v", req.Description, err)
+		}
+	}
+
+	// Test bidding
+	resp, err := biddingService.PlaceBid(alice, &pb.PlaceBidRequest{ListingId: listing.Id, Amount: usd(1000), ShippingAddress: address})
+	if err != nil {
+		t.Fatalf("PlaceBid failed: %v", err)
+	}
+	if resp.Bid.UserId != 2 || resp.Listing.Price.MinorUnits != 1000 || resp.Listing.Auction.HighBidderId != 2 {
+		t.Errorf("Expected user 2 to lead at 10.00 USD, got %v", resp.Listing.Auction)
+	}
+	_, err = biddingService.PlaceBid(bob, &pb.PlaceBidRequest{ListingId: listing.Id, Amount: usd(1049), ShippingAddress: address})
+	if status.Code(err) != codes.FailedPrecondition {
+		t.Errorf("Expected FailedPrecondition error for bid below the increment, got: %v", err)
+	}
+	resp, err = biddingService.PlaceBid(bob, &pb.PlaceBidRequest{ListingId: listing.Id, Amount: usd(2500), ShippingAddress: address})
+	if err != nil {
+		t.Fatalf("PlaceBid failed: %v", err)
+	}
+	if !resp.Listing.Auction.ReserveMet || resp.Listing.Auction.MinimumBid.MinorUnits != 2600 {
+		t.Errorf("Expected reserve met and minimum bid of 26.00 USD, got %v", resp.Listing.Auction)
+	}
+
+	// Test invalid bids
+	_, err = biddingService.PlaceBid(seller, &pb.PlaceBidRequest{ListingId: listing.Id, Amount: usd(5000), ShippingAddress: address})
+	if status.Code(err) != codes.PermissionDenied {
+		t.Errorf("Expected PermissionDenied error for seller bidding, got: %v", err)
+	}
+	_, err = biddingService.PlaceBid(alice, &pb.PlaceBidRequest{ListingId: listing.Id, Amount: money.New("EUR", 5000), ShippingAddress: address})
+	if status.Code(err) != codes.InvalidArgument {
+		t.Errorf("Expected InvalidArgument error for bid in another currency, got: %v", err)
+	}
+	_, err = biddingService.PlaceBid(alice, &pb.PlaceBidRequest{ListingId: listing.Id, Amount: usd(5000)})
+	if status.Code(err) != codes.InvalidArgument {
+		t.Errorf("Expected InvalidArgument error for missing address, got: %v", err)
+	}
+	_, err = biddingService.PlaceBid(alice, &pb.PlaceBidRequest{ListingId: 999, Amount: usd(5000), ShippingAddress: address})
+	if status.Code(err) != codes.NotFound {
+		t.Errorf("Expected NotFound error for missing listing, got: %v", err)
+	}
+
+	fixed, err := listingService.CreateListing(seller, &pb.ListingCreate{Title: "Lens", Description: "50mm lens", Price: usd(8000)})
+	if err != nil {
+		t.Fatalf("CreateListing failed: %v", err)
+	}
+	_, err = biddingService.PlaceBid(alice, &pb.PlaceBidRequest{ListingId: fixed.Id, Amount: usd(8000), ShippingAddress: address})
+	if status.Code(err) != codes.FailedPrecondition {
+		t.Errorf("Expected FailedPrecondition error for bid on a fixed-price listing, got: %v", err)
+	}
+
+	bids, err := biddingService.GetBids(alice, &pb.GetBidsRequest{ListingId: listing.Id})
+	if err != nil {
+		t.Fatalf("GetBids failed: %v", err)
+	}
+	if len(bids.Bids) != 2 || bids.Bids[0].UserId != 2 || bids.Bids[1].Amount.MinorUnits != 2500 {
+		t.Errorf("Expected 2 bids oldest first, got %v", bids.Bids)
+	}
+	if _, err := biddingService.GetBids(alice, &pb.GetBidsRequest{ListingId: 999}); status.Code(err) != codes.NotFound {
+		t.Errorf("Expected NotFound error for missing listing, got: %v", err)
+	}
+
+	// Test auctions are only sold through bidding
+	_, err = orderService.CreateOrder(alice, &pb.OrderCreate{ListingId: listing.Id, Quantity: 1, ShippingAddress: address})
+	if status.Code(err) != codes.FailedPrecondition {
+		t.Errorf("Expected FailedPrecondition error for ordering an auction, got: %v", err)
+	}
+	_, err = listingService.UpdateListing(seller, &pb.UpdateListingRequest{Id: listing.Id, Listing: &pb.ListingUpdate{Price: usd(100)}})
+	if status.Code(err) != codes.InvalidArgument {
+		t.Errorf("Expected InvalidArgument error for changing an auction's price, got: %v", err)
+	}
+	if _, err := listingService.PauseListing(seller, &pb.ListingActionRequest{Id: listing.Id}); status.Code(err) != codes.FailedPrecondition {
+		t.Errorf("Expected FailedPrecondition error for pausing an auction, got: %v", err)
+	}
+
+	// Test edits keep the bidding state
+	updated, err := listingService.UpdateListing(seller, &pb.UpdateListingRequest{Id: listing.Id, Listing: &pb.ListingUpdate{Title: "Vintage film camera"}})
+	if err != nil {
+		t.Fatalf("UpdateListing failed: %v", err)
+	}
+	if updated.Price.MinorUnits != 2500 || updated.Auction.HighBidderId != 3 {
+		t.Errorf("Expected edit to keep the high bid, got price %v auction %v", updated.Price, updated.Auction)
+	}
+
+	// Test filtering by format
+	found, err := listingService.GetListings(alice, &pb.ListingsRequest{Format: pb.ListingFormat_LISTING_FORMAT_AUCTION})
+	if err != nil {
+		t.Fatalf("GetListings failed: %v", err)
+	}
+	if len(found.Listings) != 1 || found.Listings[0].Id != listing.Id {
+		t.Errorf("Expected only the auction, got %d listings", len(found.Listings))
+	}
+}
+
+func TestBiddingServiceProxyBids(t *testing.T) {
+	store := storage.NewInMemoryStorage()
+	listingService := newTestListingService(t, store)
+	biddingService := NewBiddingService(store)
+	seller, alice, bob := userContext(t, 1), userContext(t, 2), userContext(t, 3)
+	address := &pb.Address{Street: "1 Main St", City: "Springfield", Country: "USA"}
+
+	listing, err := listingService.CreateListing(seller, &pb.ListingCreate{
+		Title:       "Pocket watch",
+		Description: "Silver pocket watch",
+		Format:      pb.ListingFormat_LISTING_FORMAT_AUCTION,
+		Auction:     &pb.AuctionCreate{StartPrice: usd(2000)},
+		Duration:    durationpb.New(time.Hour),
+	})
+	if err != nil {
+		t.Fatalf("CreateListing failed: %v", err)
+	}
+
+	// Test a maximum alone bids the minimum
+	resp, err := biddingService.PlaceBid(alice, &pb.PlaceBidRequest{ListingId: listing.Id, MaxAmount: usd(10000), ShippingAddress: address})
+	if err != nil {
+		t.Fatalf("PlaceBid failed: %v", err)
+	}
+	if resp.Bid.Amount.MinorUnits != 2000 || resp.Listing.Price.MinorUnits != 2000 {
+		t.Errorf("Expected bid at the 20.00 USD start price, got %v", resp.Bid.Amount)
+	}
+
+	// Test the proxy responds to a lower maximum
+	resp, err = biddingService.PlaceBid(bob, &pb.PlaceBidRequest{ListingId: listing.Id, Amount: usd(3000), MaxAmount: usd(4000), ShippingAddress: address})
+	if err != nil {
+		t.Fatalf("PlaceBid failed: %v", err)
+	}
+	if resp.Listing.Auction.HighBidderId != 2 || resp.Listing.Price.MinorUnits != 4100 {
+		t.Errorf("Expected user 2 to lead at 41.00 USD, got user %d at %v", resp.Listing.Auction.HighBidderId, resp.Listing.Price)
+	}
+
+	// Test invalid maximums
+	_, err = biddingService.PlaceBid(bob, &pb.PlaceBidRequest{ListingId: listing.Id, Amount: usd(6000), MaxAmount: usd(5000), ShippingAddress: address})
+	if status.Code(err) != codes.InvalidArgument {
+		t.Errorf("Expected InvalidArgument error for max below amount, got: %v", err)
+	}
+	_, err = biddingService.PlaceBid(alice, &pb.PlaceBidRequest{ListingId: listing.Id, MaxAmount: usd(9000), ShippingAddress: address})
+	if status.Code(err) != codes.FailedPrecondition {
+		t.Errorf("Expected FailedPrecondition error for lowering a maximum, got: %v", err)
+	}
+
+	// Test maximums are only shown to their bidder
+	bids, err := biddingService.GetBids(bob, &pb.GetBidsRequest{ListingId: listing.Id})
+	if err != nil {
+		t.Fatalf("GetBids failed: %v", err)
+	}
+	if len(bids.Bids) != 3 || !bids.Bids[2].Automatic {
+		t.Fatalf("Expected 3 bids ending with an automatic one, got %v", bids.Bids)
+	}
+	for _, bid := range bids.Bids {
+		if (bid.MaxAmount != nil) != (bid.UserId == 3) {
+			t.Errorf("Expected only user 3's maximum to be shown, got %v on a bid by user %d", bid.MaxAmount, bid.UserId)
+		}
+	}
+	bids, _ = biddingService.GetBids(alice, &pb.GetBidsRequest{ListingId: listing.Id})
+	if bids.Bids[0].MaxAmount.GetMinorUnits() != 10000 {
+		t.Errorf("Expected user 2 to see their maximum, got %v", bids.Bids[0].MaxAmount)
+	}
+}
+
+func TestBiddingServiceBuyItNow(t *testing.T) {
+	store := storage.NewInMemoryStorage()
+	listingService := newTestListingService(t, store)
+	biddingService := NewBiddingService(store)
+	seller, alice, bob := userContext(t, 1), userContext(t, 2), userContext(t, 3)
+	address := &pb.Address{Street: "1 Main St", City: "Springfield", Country: "USA"}
+
+	create := func(terms *pb.AuctionCreate) (*pb.Listing, error) {
+		return listingService.CreateListing(seller, &pb.ListingCreate{
+			Title:       "Film camera",
+			Description: "35mm rangefinder",
+			Format:      pb.ListingFormat_LISTING_FORMAT_AUCTION,
+			Auction:     terms,
+			Duration:    durationpb.New(time.Hour),
+		})
+	}
+
+	// Test invalid terms
+	invalid := []*pb.AuctionCreate{
+		{StartPrice: usd(2000), BuyItNowPrice: usd(2000)},
+		{StartPrice: usd(2000), ReservePrice: usd(6000), BuyItNowPrice: usd(5000)},
+		{StartPrice: usd(2000), BuyItNowPrice: money.New("EUR", 5000)},
+		{StartPrice: usd(2000), ExtensionWindow: durationpb.New(-time.Minute)},
+	}
+	for _, terms := range invalid {
+		if _, err := create(terms); status.Code(err) != codes.InvalidArgument {
+			t.Errorf("Expected InvalidArgument error for %v, got: %v", terms, err)
+		}
+	}
+
+	listing, err := create(&pb.AuctionCreate{StartPrice: usd(2000), ReservePrice: usd(4000), BuyItNowPrice: usd(6000)})
+	if err != nil {
+		t.Fatalf("CreateListing failed: %v", err)
+	}
+	if !listing.Auction.BuyItNowAvailable {
+		t.Errorf("Expected Buy It Now to be available")
+	}
+
+	// Test sellers cannot buy their own listing
+	_, err = biddingService.BuyItNow(seller, &pb.BuyItNowRequest{ListingId: listing.Id, ShippingAddress: address})
+	if status.Code(err) != codes.PermissionDenied {
+		t.Errorf("Expected PermissionDenied error for the seller, got: %v", err)
+	}
+
+	// Test Buy It Now survives bids below the reserve
+	if _, err := biddingService.PlaceBid(alice, &pb.PlaceBidRequest{ListingId: listing.Id, Amount: usd(2000), ShippingAddress: address}); err != nil {
+		t.Fatalf("PlaceBid failed: %v", err)
+	}
+	order, err := biddingService.BuyItNow(bob, &pb.BuyItNowRequest{ListingId: listing.Id, ShippingAddress: address})
+	if err != nil {
+		t.Fatalf("BuyItNow failed: %v", err)
+	}
+	if order.UserId != 3 || order.TotalPrice.MinorUnits != 6000 || order.Status != pb.OrderStatus_ORDER_STATUS_PENDING {
+		t.Errorf("Expected pending order for user 3 at 60.00 USD, got %v", order)
+	}
+
+	// Test the auction has ended
+	sold, _ := store.GetListing(listing.Id)
+	if sold.Status != pb.ListingStatus_LISTING_STATUS_SOLD || sold.Auction.OrderId != order.Id || sold.Auction.BuyItNowAvailable {
+		t.Errorf("Expected listing to be sold through order %d, got %v", order.Id, sold)
+	}
+	_, err = biddingService.PlaceBid(alice, &pb.PlaceBidRequest{ListingId: listing.Id, Amount: usd(3000), ShippingAddress: address})
+	if status.Code(err) != codes.FailedPrecondition {
+		t.Errorf("Expected FailedPrecondition error for bidding on a sold auction, got: %v", err)
+	}
+
+	// Test Buy It Now goes once the reserve is met
+	listing, err = create(&pb.AuctionCreate{StartPrice: usd(2000), ReservePrice: usd(4000), BuyItNowPrice: usd(6000)})
+	if err != nil {
+		t.Fatalf("CreateListing failed: %v", err)
+	}
+	if _, err := biddingService.PlaceBid(alice, &pb.PlaceBidRequest{ListingId: listing.Id, Amount: usd(4000), ShippingAddress: address}); err != nil {
+		t.Fatalf("PlaceBid failed: %v", err)
+	}
+	_, err = biddingService.BuyItNow(bob, &pb.BuyItNowRequest{ListingId: listing.Id, ShippingAddress: address})
+	if status.Code(err) != codes.FailedPrecondition {
+		t.Errorf("Expected FailedPrecondition error once the reserve is met, got: %v", err)
+	}
+
+	// Test fixed-price listings have no Buy It Now
+	fixed, err := listingService.CreateListing(seller, &pb.ListingCreate{Title: "Lens", Description: "50mm lens", Price: usd(5000), Quantity: 1})
+	if err != nil {
+		t.Fatalf("CreateListing failed: %v", err)
+	}
+	_, err = biddingService.BuyItNow(bob, &pb.BuyItNowRequest{ListingId: fixed.Id, ShippingAddress: address})
+	if status.Code(err) != codes.FailedPrecondition {
+		t.Errorf("Expected FailedPrecondition error for a fixed-price listing, got: %v", err)
+	}
+}
+
+// fakeWatchStream collects the events WatchListing sends.
+type fakeWatchStream struct {
+	grpc.ServerStream
+	ctx    context.Context
+	events chan *pb.ListingEvent
+}
+
+func (f *fakeWatchStream) Context() context.Context {
+	return f.ctx
+}
+
+func (f *fakeWatchStream) Send(event *pb.ListingEvent) error {
+	f.events <- event
+	return nil
+}
+
+func TestBiddingServiceWatchListing(t *testing.T) {
+	store := storage.NewInMemoryStorage()
+	listingService := newTestListingService(t, store)
+	biddingService := NewBiddingService(store)
+	seller, alice, bob := userContext(t, 1), userContext(t, 2), userContext(t, 3)
+	address := &pb.Address{Street: "1 Main St", City: "Springfield", Country: "USA"}
+
+	listing, err := listingService.CreateListing(seller, &pb.ListingCreate{
+		Title:       "Mantel clock",
+		Description: "Oak mantel clock",
+		Format:      pb.ListingFormat_LISTING_FORMAT_AUCTION,
+		Auction:     &pb.AuctionCreate{StartPrice: usd(1000), ExtensionWindow: durationpb.New(2 * time.Hour), BuyItNowPrice: usd(9000)},
+		Duration:    durationpb.New(time.Hour),
+	})
+	if err != nil {
+		t.Fatalf("CreateListing failed: %v", err)
+	}
+
+	watch := func(ctx context.Context, id int32) (*fakeWatchStream, chan error) {
+		stream := &fakeWatchStream{ctx: ctx, events: make(chan *pb.ListingEvent, 100)}
+		done := make(chan error, 1)
+		go func() { done <- biddingService.WatchListing(&pb.WatchListingRequest{ListingId: id}, stream) }()
+		return stream, done
+	}
+	next := func(stream *fakeWatchStream, want pb.ListingEventType) *pb.ListingEvent {
+		t.Helper()
+		select {
+		case event := <-stream.events:
+			if event.Type != want {
+				t.Fatalf("Expected %v event, got %v", want, event)
+			}
+			return event
+		case <-time.After(time.Second):
+			t.Fatalf("Timed out waiting for %v event", want)
+			return nil
+		}
+	}
+
+	// Test the watch starts with a snapshot
+	ctx, cancel := context.WithCancel(context.Background())
+	stream, done := watch(ctx, listing.Id)
+	if event := next(stream, pb.ListingEventType_LISTING_EVENT_TYPE_SNAPSHOT); event.Listing.Price.MinorUnits != 1000 {
+		t.Errorf("Expected snapshot at 10.00 USD, got %v", event.Listing.Price)
+	}
+
+	// Test a proxy battle streams every bid, then the price and the extension
+	if _, err := biddingService.PlaceBid(alice, &pb.PlaceBidRequest{ListingId: listing.Id, MaxAmount: usd(3000), ShippingAddress: address}); err != nil {
+		t.Fatalf("PlaceBid failed: %v", err)
+	}
+	next(stream, pb.ListingEventType_LISTING_EVENT_TYPE_BID_PLACED)
+	next(stream, pb.ListingEventType_LISTING_EVENT_TYPE_EXTENDED)
+	if _, err := biddingService.PlaceBid(bob, &pb.PlaceBidRequest{ListingId: listing.Id, Amount: usd(2000), ShippingAddress: address}); err != nil {
+		t.Fatalf("PlaceBid failed: %v", err)
+	}
+	next(stream, pb.ListingEventType_LISTING_EVENT_TYPE_BID_PLACED)
+	if event := next(stream, pb.ListingEventType_LISTING_EVENT_TYPE_BID_PLACED); !event.Bid.Automatic || event.Bid.MaxAmount != nil {
+		t.Errorf("Expected an automatic bid without its maximum, got %v", event.Bid)
+	}
+	if event := next(stream, pb.ListingEventType_LISTING_EVENT_TYPE_PRICE_CHANGED); event.Listing.Price.MinorUnits != 2050 {
+		t.Errorf("Expected price of 20.50 USD, got %v", event.Listing.Price)
+	}
+	next(stream, pb.ListingEventType_LISTING_EVENT_TYPE_EXTENDED)
+
+	// Test a client going away ends the watch
+	cancel()
+	if err := <-done; status.Code(err) != codes.Canceled {
+		t.Errorf("Expected Canceled error after the client left, got: %v", err)
+	}
+	if _, err := biddingService.PlaceBid(bob, &pb.PlaceBidRequest{ListingId: listing.Id, Amount: usd(5000), ShippingAddress: address}); err != nil {
+		t.Fatalf("PlaceBid failed: %v", err)
+	}
+
+	// Test the end of the auction ends the watch
+	stream, done = watch(context.Background(), listing.Id)
+	next(stream, pb.ListingEventType_LISTING_EVENT_TYPE_SNAPSHOT)
+	if _, _, err := store.CloseAuction(listing.Id, time.Now().Add(3*time.Hour)); err != nil {
+		t.Fatalf("CloseAuction failed: %v", err)
+	}
+	if event := next(stream, pb.ListingEventType_LISTING_EVENT_TYPE_ENDED); event.Listing.Status != pb.ListingStatus_LISTING_STATUS_SOLD {
+		t.Errorf("Expected the auction to be sold, got %v", event.Listing.Status)
+	}
+	if err := <-done; err != nil {
+		t.Errorf("Expected watch to end cleanly, got: %v", err)
+	}
+
+	// Test ended auctions only send a snapshot
+	stream, done = watch(context.Background(), listing.Id)
+	next(stream, pb.ListingEventType_LISTING_EVENT_TYPE_SNAPSHOT)
+	if err := <-done; err != nil {
+		t.Errorf("Expected watch of an ended auction to end cleanly, got: %v", err)
+	}
+
+	// Test a watcher that stops reading is dropped without holding up bids
+	listing, err = listingService.CreateListing(seller, &pb.ListingCreate{
+		Title:       "Carriage clock",
+		Description: "Brass carriage clock",
+		Format:      pb.ListingFormat_LISTING_FORMAT_AUCTION,
+		Auction:     &pb.AuctionCreate{StartPrice: usd(100)},
+		Duration:    durationpb.New(time.Hour),
+	})
+	if err != nil {
+		t.Fatalf("CreateListing failed: %v", err)
+	}
+	stalled := &fakeWatchStream{ctx: context.Background(), events: make(chan *pb.ListingEvent)}
+	done = make(chan error, 1)
+	go func() { done <- biddingService.WatchListing(&pb.WatchListingRequest{ListingId: listing.Id}, stalled) }()
+	<-stalled.events
+	for i := 0; i < 100; i++ {
+		user := alice
+		if i%2 == 1 {
+			user = bob
+		}
+		if _, err := biddingService.PlaceBid(user, &pb.PlaceBidRequest{ListingId: listing.Id, Amount: usd(int64(100 + 100*i)), ShippingAddress: address}); err != nil {
+			t.Fatalf("PlaceBid failed: %v", err)
+		}
+	}
+	go func() {
+		for range stalled.events {
+		}
+	}()
+	if err := <-done; status.Code(err) != codes.ResourceExhausted {
+		t.Errorf("Expected ResourceExhausted error for a stalled watcher, got: %v", err)
+	}
+
+	// Test errors
+	_, done = watch(context.Background(), 999)
+	if err := <-done; status.Code(err) != codes.NotFound {
+		t.Errorf("Expected NotFound error for missing listing, got: %v", err)
+	}
+	fixed, err := listingService.CreateListing(seller, &pb.ListingCreate{Title: "Wall clock", Description: "Wall clock", Price: usd(5000), Quantity: 1})
+	if err != nil {
+		t.Fatalf("CreateListing failed: %v", err)
+	}
+	_, done = watch(context.Background(), fixed.Id)
+	if err := <-done; status.Code(err) != codes.FailedPrecondition {
+		t.Errorf("Expected FailedPrecondition error for a fixed-price listing, got: %v", err)
+	}
+}
+
+func TestOfferService(t *testing.T) {
+	store := storage.NewInMemoryStorage()
+	listingService := newTestListingService(t, store)
+	offerService := NewOfferService(store)
+	seller, alice, bob := userContext(t, 1), userContext(t, 2), userContext(t, 3)
+	address := &pb.Address{Street: "1 Main St", City: "Springfield", Country: "USA"}
+
+	// Test invalid terms
+	invalid := []*pb.BestOffer{
+		{AutoAcceptPrice: usd(10000)},
+		{AutoDeclinePrice: money.New("EUR", 5000)},
+		{AutoAcceptPrice: usd(6000), AutoDeclinePrice: usd(6000)},
+	}
+	for _, terms := range invalid {
+		_, err := listingService.CreateListing(seller, &pb.ListingCreate{Title: "Desk", Description: "Oak desk", Price: usd(10000), Quantity: 3, BestOffer: terms})
+		if status.Code(err) != codes.InvalidArgument {
+			t.Errorf("Expected InvalidArgument error for %v, got: %v", terms, err)
+		}
+	}
+
+	listing, err := listingService.CreateListing(seller, &pb.ListingCreate{
+		Title:       "Desk",
+		Description: "Oak desk",
+		Price:       usd(10000),
+		Quantity:    3,
+		BestOffer:   &pb.BestOffer{AutoAcceptPrice: usd(9000), AutoDeclinePrice: usd(6000)},
+	})
+	if err != nil {
+		t.Fatalf("CreateListing failed: %v", err)
+	}
+	if !listing.AcceptsOffers {
+		t.Errorf("Expected listing to accept offers")
+	}
+	makeOffer := func(ctx context.Context, amount int64) (*pb.Offer, error) {
+		return offerService.MakeOffer(ctx, &pb.MakeOfferRequest{ListingId: listing.Id, Amount: usd(amount), Message: "Would you take this?", ShippingAddress: address})
+	}
+
+	// Test offers below the auto-decline price are declined at once
+	offer, err := makeOffer(alice, 5000)
+	if err != nil {
+		t.Fatalf("MakeOffer failed: %v", err)
+	}
+	if offer.Status != pb.OfferStatus_OFFER_STATUS_DECLINED || offer.SellerId != 1 {
+		t.Errorf("Expected offer to be declined, got %v", offer.Status)
+	}
+
+	// Test offers at the auto-accept price are accepted at once
+	offer, err = makeOffer(bob, 9000)
+	if err != nil {
+		t.Fatalf("MakeOffer failed: %v", err)
+	}
+	if offer.Status != pb.OfferStatus_OFFER_STATUS_ACCEPTED || offer.OrderId == 0 {
+		t.Fatalf("Expected offer to be accepted with an order, got %v", offer)
+	}
+	order, _ := store.GetOrder(offer.OrderId)
+	if order.UserId != 3 || order.TotalPrice.MinorUnits != 9000 || order.OfferId != offer.Id {
+		t.Errorf("Expected order for user 3 at 90.00 USD, got %v", order)
+	}
+	_, err = NewOrderService(store, newTestRates(t)).UpdateOrder(bob, &pb.UpdateOrderRequest{
+		Id:    order.Id,
+		Order: &pb.OrderUpdate{Items: []*pb.OrderItemCreate{{ListingId: listing.Id, Quantity: 2}}},
+	})
+	if status.Code(err) != codes.FailedPrecondition {
+		t.Errorf("Expected FailedPrecondition error for changing the quantity of an offer order, got: %v", err)
+	}
+	if got, _ := store.GetOrder(order.Id); got.TotalPrice.MinorUnits != 9000 {
+		t.Errorf("Expected the offer order to keep its 90.00 USD price, got %v", got.TotalPrice)
+	}
+
+	// Test negotiating in between
+	offer, err = offerService.MakeOffer(alice, &pb.MakeOfferRequest{ListingId: listing.Id, Quantity: 2, Amount: usd(7000), ShippingAddress: address})
+	if err != nil {
+		t.Fatalf("MakeOffer failed: %v", err)
+	}
+	if offer.Status != pb.OfferStatus_OFFER_STATUS_PENDING || offer.ExpiresAt == nil {
+		t.Fatalf("Expected pending offer with an expiry, got %v", offer)
+	}
+	_, err = offerService.AcceptOffer(alice, &pb.OfferActionRequest{OfferId: offer.Id})
+	if status.Code(err) != codes.PermissionDenied {
+		t.Errorf("Expected PermissionDenied error for the buyer accepting their own offer, got: %v", err)
+	}
+	_, err = offerService.GetOffer(bob, &pb.GetOfferRequest{Id: offer.Id})
+	if status.Code(err) != codes.PermissionDenied {
+		t.Errorf("Expected PermissionDenied error for another user's offer, got: %v", err)
+	}
+	for _, amount := range []int64{6500, 7000, 10500} {
+		_, err = offerService.CounterOffer(seller, &pb.CounterOfferRequest{OfferId: offer.Id, Amount: usd(amount)})
+		if status.Code(err) != codes.InvalidArgument {
+			t.Errorf("Expected InvalidArgument error for a counter of %d, got: %v", amount, err)
+		}
+	}
+	countered, err := offerService.CounterOffer(seller, &pb.CounterOfferRequest{OfferId: offer.Id, Amount: usd(8000), Message: "Meet me at 80"})
+	if err != nil {
+		t.Fatalf("CounterOffer failed: %v", err)
+	}
+	if countered.Status != pb.OfferStatus_OFFER_STATUS_COUNTERED || countered.CounterCount != 1 || countered.Amount.MinorUnits != 8000 {
+		t.Errorf("Expected offer countered at 80.00 USD, got %v", countered)
+	}
+	_, err = offerService.CounterOffer(seller, &pb.CounterOfferRequest{OfferId: offer.Id, Amount: usd(8500)})
+	if status.Code(err) != codes.PermissionDenied {
+		t.Errorf("Expected PermissionDenied error for countering out of turn, got: %v", err)
+	}
+	order, err = offerService.AcceptOffer(alice, &pb.OfferActionRequest{OfferId: offer.Id})
+	if err != nil {
+		t.Fatalf("AcceptOffer failed: %v", err)
+	}
+	if order.Items[0].Quantity != 2 || order.TotalPrice.MinorUnits != 16000 || order.Status != pb.OrderStatus_ORDER_STATUS_PENDING {
+		t.Errorf("Expected pending order for 2 at 160.00 USD, got %v", order)
+	}
+	if got, _ := store.GetListing(listing.Id); got.Status != pb.ListingStatus_LISTING_STATUS_SOLD {
+		t.Errorf("Expected accepted offers to reserve stock, got %v with %d left", got.Status, got.Quantity)
+	}
+	_, err = offerService.DeclineOffer(alice, &pb.OfferActionRequest{OfferId: offer.Id})
+	if status.Code(err) != codes.FailedPrecondition {
+		t.Errorf("Expected FailedPrecondition error for an accepted offer, got: %v", err)
+	}
+
+	// Test offers on a sold out listing
+	_, err = makeOffer(bob, 8000)
+	if status.Code(err) != codes.FailedPrecondition {
+		t.Errorf("Expected FailedPrecondition error for a sold listing, got: %v", err)
+	}
+
+	// Test a buyer's counter is subject to the terms, and withdrawing
+	listing, err = listingService.CreateListing(seller, &pb.ListingCreate{Title: "Chair", Description: "Oak chair", Price: usd(5000), Quantity: 1, BestOffer: &pb.BestOffer{AutoDeclinePrice: usd(2000)}})
+	if err != nil {
+		t.Fatalf("CreateListing failed: %v", err)
+	}
+	offer, err = makeOffer(alice, 3000)
+	if err != nil {
+		t.Fatalf("MakeOffer failed: %v", err)
+	}
+	if _, err := offerService.CounterOffer(seller, &pb.CounterOfferRequest{OfferId: offer.Id, Amount: usd(4500)}); err != nil {
+		t.Fatalf("CounterOffer failed: %v", err)
+	}
+	countered, err = offerService.CounterOffer(alice, &pb.CounterOfferRequest{OfferId: offer.Id, Amount: usd(1500)})
+	if err != nil {
+		t.Fatalf("CounterOffer failed: %v", err)
+	}
+	if countered.Status != pb.OfferStatus_OFFER_STATUS_DECLINED {
+		t.Errorf("Expected a counter below the auto-decline price to be declined, got %v", countered.Status)
+	}
+	offer, _ = makeOffer(alice, 3500)
+	_, err = offerService.WithdrawOffer(seller, &pb.OfferActionRequest{OfferId: offer.Id})
+	if status.Code(err) != codes.PermissionDenied {
+		t.Errorf("Expected PermissionDenied error for the seller withdrawing, got: %v", err)
+	}
+	withdrawn, err := offerService.WithdrawOffer(alice, &pb.OfferActionRequest{OfferId: offer.Id})
+	if err != nil || withdrawn.Status != pb.OfferStatus_OFFER_STATUS_WITHDRAWN {
+		t.Errorf("Expected offer to be withdrawn, got %v: %v", withdrawn, err)
+	}
+	offers, err := offerService.GetOffers(seller, &pb.OffersRequest{ListingId: listing.Id})
+	if err != nil {
+		t.Fatalf("GetOffers failed: %v", err)
+	}
+	if len(offers.Offers) != 2 || offers.Offers[0].Id != offer.Id {
+		t.Errorf("Expected the seller to see 2 offers newest first, got %v", offers.Offers)
+	}
+
+	// Test only the seller can change the listing or its terms
+	before, _ := store.GetListing(listing.Id)
+	_, err = listingService.UpdateListing(alice, &pb.UpdateListingRequest{
+		Id:         listing.Id,
+		Listing:    &pb.ListingUpdate{BestOffer: &pb.BestOffer{AutoAcceptPrice: usd(1)}},
+		UpdateMask: &fieldmaskpb.FieldMask{Paths: []string{"best_offer"}},
+	})
+	if status.Code(err) != codes.PermissionDenied {
+		t.Errorf("Expected PermissionDenied error for another user's terms, got: %v", err)
+	}
+	_, err = listingService.UpdateListing(alice, &pb.UpdateListingRequest{Id: listing.Id, Listing: &pb.ListingUpdate{Title: "Mine"}})
+	if status.Code(err) != codes.PermissionDenied {
+		t.Errorf("Expected PermissionDenied error for another user's listing, got: %v", err)
+	}
+	if got, _ := store.GetListing(listing.Id); got.Version != before.Version {
+		t.Errorf("Expected the listing to be left alone, got version %d", got.Version)
+	}
+
+	// Test turning offers off
+	_, err = listingService.UpdateListing(seller, &pb.UpdateListingRequest{
+		Id:         listing.Id,
+		Listing:    &pb.ListingUpdate{},
+		UpdateMask: &fieldmaskpb.FieldMask{Paths: []string{"best_offer"}},
+	})
+	if err != nil {
+		t.Fatalf("UpdateListing failed: %v", err)
+	}
+	_, err = makeOffer(alice, 4000)
+	if status.Code(err) != codes.FailedPrecondition {
+		t.Errorf("Expected FailedPrecondition error for a listing without offers, got: %v", err)
+	}
+
+	// Test auctions cannot accept offers
+	_, err = listingService.CreateListing(seller, &pb.ListingCreate{
+		Title:       "Lamp",
+		Description: "Brass lamp",
+		Format:      pb.ListingFormat_LISTING_FORMAT_AUCTION,
+		Auction:     &pb.AuctionCreate{StartPrice: usd(1000)},
+		Duration:    durationpb.New(time.Hour),
+		BestOffer:   &pb.BestOffer{},
+	})
+	if status.Code(err) != codes.InvalidArgument {
+		t.Errorf("Expected InvalidArgument error for an auction with Best Offer, got: %v", err)
+	}
+	auction, err := listingService.CreateListing(seller, &pb.ListingCreate{
+		Title:       "Lamp",
+		Description: "Brass lamp",
+		Format:      pb.ListingFormat_LISTING_FORMAT_AUCTION,
+		Auction:     &pb.AuctionCreate{StartPrice: usd(1000)},
+		Duration:    durationpb.New(time.Hour),
+	})
+	if err != nil {
+		t.Fatalf("CreateListing failed: %v", err)
+	}
+	for _, terms := range []*pb.BestOffer{{}, nil} {
+		_, err = listingService.UpdateListing(seller, &pb.UpdateListingRequest{
+			Id:         auction.Id,
+			Listing:    &pb.ListingUpdate{Title: "Brass lamp", BestOffer: terms},
+			UpdateMask: &fieldmaskpb.FieldMask{Paths: []string{"title", "best_offer"}},
+		})
+		if status.Code(err) != codes.InvalidArgument {
+			t.Errorf("Expected InvalidArgument error for Best Offer %v on an auction, got: %v", terms, err)
+		}
+	}
+	if got, _ := store.GetListing(auction.Id); got.Title != "Lamp" || got.Version != auction.Version {
+		t.Errorf("Expected the auction to be left alone, got %q at version %d", got.Title, got.Version)
+	}
+}
+
+func TestCartService(t *testing.T) {
+	store := storage.NewInMemoryStorage()
+	listingService := newTestListingService(t, store)
+	cartService := NewCartService(store)
+	alice, bob, buyer := userContext(t, 1), userContext(t, 2), userContext(t, 3)
+	address := &pb.Address{Street: "1 Main St", City: "Springfield", Country: "USA"}
+
+	create := func(ctx context.Context, title string, price *pb.Money, quantity int32) *pb.Listing {
+		listing, err := listingService.CreateListing(ctx, &pb.ListingCreate{Title: title, Description: title, Price: price, Quantity: quantity})
+		if err != nil {
+			t.Fatalf("CreateListing failed: %v", err)
+		}
+		return listing
+	}
+	mug := create(bob, "Mug", usd(1200), 5)
+	lamp := create(alice, "Lamp", usd(4000), 1)
+	print := create(bob, "Print", money.New("EUR", 3000), 2)
+
+	// Test adding merges quantities of the same item
+	for _, req := range []*pb.AddCartItemRequest{
+		{ListingId: mug.Id},
+		{ListingId: lamp.Id},
+		{ListingId: mug.Id, Quantity: 2},
+		{ListingId: print.Id},
+	} {
+		if _, err := cartService.AddCartItem(buyer, req); err != nil {
+			t.Fatalf("AddCartItem failed: %v", err)
+		}
+	}
+	cart, err := cartService.GetCart(buyer, &emptypb.Empty{})
+	if err != nil {
+		t.Fatalf("GetCart failed: %v", err)
+	}
+	if len(cart.Items) != 3 || cart.Items[0].Quantity != 3 || cart.Items[0].Subtotal.MinorUnits != 3600 {
+		t.Fatalf("Expected 3 items starting with 3 mugs at 36.00 USD, got %v", cart.Items)
+	}
+	if !cart.Purchasable || len(cart.Totals) != 2 || cart.Totals[0].MinorUnits != 7600 || cart.Totals[1].CurrencyCode != "EUR" {
+		t.Errorf("Expected totals of 76.00 USD and 30.00 EUR, got %v", cart.Totals)
+	}
+	if cart, _ := cartService.GetCart(alice, &emptypb.Empty{}); len(cart.Items) != 0 {
+		t.Errorf("Expected carts to be per user, got %v", cart.Items)
+	}
+
+	// Test updating and removing items
+	cart, err = cartService.UpdateCartItem(buyer, &pb.UpdateCartItemRequest{ListingId: mug.Id, Quantity: 2})
+	if err != nil || cart.Items[0].Quantity != 2 {
+		t.Errorf("Expected 2 mugs, got %v: %v", cart, err)
+	}
+	cart, err = cartService.RemoveCartItem(buyer, &pb.RemoveCartItemRequest{ListingId: print.Id})
+	if err != nil || len(cart.Items) != 2 {
+		t.Errorf("Expected 2 items after removing the print, got %v: %v", cart, err)
+	}
+	_, err = cartService.RemoveCartItem(buyer, &pb.RemoveCartItemRequest{ListingId: print.Id})
+	if status.Code(err) != codes.NotFound {
+		t.Errorf("Expected NotFound error for an item not in the cart, got: %v", err)
+	}
+
+	// Test the cart shows items that became unavailable, and checkout
+	// orders nothing while one is
+	if _, err := cartService.AddCartItem(bob, &pb.AddCartItemRequest{ListingId: lamp.Id}); err != nil {
+		t.Fatalf("AddCartItem failed: %v", err)
+	}
+	if _, err := cartService.Checkout(bob, &pb.CheckoutRequest{ShippingAddress: address}); err != nil {
+		t.Fatalf("Checkout failed: %v", err)
+	}
+	cart, _ = cartService.GetCart(buyer, &emptypb.Empty{})
+	if cart.Purchasable || cart.Items[1].Purchasable || cart.Items[1].UnavailableReason == "" {
+		t.Errorf("Expected the sold lamp to be unavailable, got %v", cart.Items[1])
+	}
+	_, err = cartService.Checkout(buyer, &pb.CheckoutRequest{ShippingAddress: address})
+	if status.Code(err) != codes.FailedPrecondition {
+		t.Errorf("Expected FailedPrecondition error for an unavailable item, got: %v", err)
+	}
+	if got, _ := store.GetListing(mug.Id); got.Quantity != 5 {
+		t.Errorf("Expected failed checkout to reserve nothing, got %d mugs left", got.Quantity)
+	}
+
+	// Test checkout orders everything at current prices, with one order per
+	// seller and currency
+	if _, err := cartService.RemoveCartItem(buyer, &pb.RemoveCartItemRequest{ListingId: lamp.Id}); err != nil {
+		t.Fatalf("RemoveCartItem failed: %v", err)
+	}
+	lampTwo := create(alice, "Lamp", usd(4500), 1)
+	bowl := create(bob, "Bowl", usd(800), 1)
+	for _, id := range []int32{print.Id, lampTwo.Id, bowl.Id} {
+		if _, err := cartService.AddCartItem(buyer, &pb.AddCartItemRequest{ListingId: id}); err != nil {
+			t.Fatalf("AddCartItem failed: %v", err)
+		}
+	}
+	_, err = listingService.UpdateListing(bob, &pb.UpdateListingRequest{Id: mug.Id, Listing: &pb.ListingUpdate{Price: usd(1000)}})
+	if err != nil {
+		t.Fatalf("UpdateListing failed: %v", err)
+	}
+	resp, err := cartService.Checkout(buyer, &pb.CheckoutRequest{ShippingAddress: address, BuyerNotes: "Gift wrap"})
+	if err != nil {
+		t.Fatalf("Checkout failed: %v", err)
+	}
+	if len(resp.Sellers) != 2 || resp.Sellers[0].SellerId != 1 || resp.Sellers[1].SellerId != 2 || len(resp.Sellers[1].Orders) != 2 {
+		t.Fatalf("Expected orders for sellers 1 and 2, got %v", resp.Sellers)
+	}
+	usdOrder := resp.Sellers[1].Orders[0]
+	if len(usdOrder.Items) != 2 || usdOrder.Items[0].ListingId != mug.Id || usdOrder.Items[1].ListingId != bowl.Id {
+		t.Fatalf("Expected mugs and a bowl in one order, got %v", usdOrder.Items)
+	}
+	if usdOrder.Items[0].UnitPrice.MinorUnits != 1000 || usdOrder.TotalPrice.MinorUnits != 2800 || usdOrder.BuyerNotes != "Gift wrap" || usdOrder.UserId != 3 {
+		t.Errorf("Expected 2 mugs at the new 10.00 USD price and a bowl, got %v", usdOrder)
+	}
+	if eurOrder := resp.Sellers[1].Orders[1]; eurOrder.TotalPrice.CurrencyCode != "EUR" || len(eurOrder.Items) != 1 {
+		t.Errorf("Expected the print in its own EUR order, got %v", eurOrder)
+	}
+	if cart, _ := cartService.GetCart(buyer, &emptypb.Empty{}); len(cart.Items) != 0 {
+		t.Errorf("Expected checkout to empty the cart, got %v", cart.Items)
+	}
+	_, err = cartService.Checkout(buyer, &pb.CheckoutRequest{ShippingAddress: address})
+	if status.Code(err) != codes.FailedPrecondition {
+		t.Errorf("Expected FailedPrecondition error for an empty cart, got: %v", err)
+	}
+
+	// Test sellers cannot check out their own listings, and nothing else in
+	// the cart is ordered
+	shade := create(alice, "Shade", usd(1500), 1)
+	for _, id := range []int32{mug.Id, shade.Id} {
+		if _, err := cartService.AddCartItem(alice, &pb.AddCartItemRequest{ListingId: id}); err != nil {
+			t.Fatalf("AddCartItem failed: %v", err)
+		}
+	}
+	_, err = cartService.Checkout(alice, &pb.CheckoutRequest{ShippingAddress: address})
+	if status.Code(err) != codes.PermissionDenied {
+		t.Errorf("Expected PermissionDenied error for buying an own listing, got: %v", err)
+	}
+	if got, _ := store.GetListing(mug.Id); got.Quantity != 3 {
+		t.Errorf("Expected failed checkout to reserve nothing, got %d mugs left", got.Quantity)
+	}
+
+	// Test invalid items
+	_, err = cartService.AddCartItem(buyer, &pb.AddCartItemRequest{ListingId: 999})
+	if status.Code(err) != codes.NotFound {
+		t.Errorf("Expected NotFound error for missing listing, got: %v", err)
+	}
+	auction, err := listingService.CreateListing(bob, &pb.ListingCreate{
+		Title:       "Vase",
+		Description: "Vase",
+		Format:      pb.ListingFormat_LISTING_FORMAT_AUCTION,
+		Auction:     &pb.AuctionCreate{StartPrice: usd(1000)},
+		Duration:    durationpb.New(time.Hour),
+	})
+	if err != nil {
+		t.Fatalf("CreateListing failed: %v", err)
+	}
+	_, err = cartService.AddCartItem(buyer, &pb.AddCartItemRequest{ListingId: auction.Id})
+	if status.Code(err) != codes.FailedPrecondition {
+		t.Errorf("Expected FailedPrecondition error for an auction, got: %v", err)
+	}
+}
@@ -2,10 +2,13 @@ package services
 
 import (
 	"context"
+	"crypto/rand"
+	"strings"
 	"time"
 
 	"github.com/golang-jwt/jwt/v5"
 	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
 	"google.golang.org/grpc/status"
 	"google.golang.org/protobuf/types/known/emptypb"
 
@@ -13,17 +16,31 @@ import (
 	"ebayclone-grpc/src/storage"
 )
 
+// jwtSecret signs and verifies the tokens issued by Login. Until
+// SetJWTSecret is called it is random, so tokens do not outlive the process.
+var jwtSecret = randomSecret()
+
+// SetJWTSecret sets the secret tokens are signed with. Call it before
+// serving requests.
+func SetJWTSecret(secret []byte) {
+	jwtSecret = secret
+}
+
+func randomSecret() []byte {
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		panic(err)
+	}
+	return secret
+}
+
 type SessionService struct {
 	pb.UnimplementedSessionServiceServer
-	storage   storage.Storage
-	jwtSecret []byte
+	storage storage.Storage
 }
 
 func NewSessionService(storage storage.Storage) *SessionService {
-	return &SessionService{
-		storage:   storage,
-		jwtSecret: []byte("your-secret-key"), // In production, use environment variable
-	}
+	return &SessionService{storage: storage}
 }
 
 func (s *SessionService) Login(ctx context.Context, req *pb.UserLogin) (*pb.LoginResponse, error) {
@@ -54,7 +71,7 @@ func (s *SessionService) Login(ctx context.Context, req *pb.UserLogin) (*pb.Logi
 		"exp":     time.Now().Add(time.Hour * 24).Unix(), // 24 hours
 	})
 
-	tokenString, err := token.SignedString(s.jwtSecret)
+	tokenString, err := token.SignedString(jwtSecret)
 	if err != nil {
 		return nil, status.Error(codes.Internal, "Failed to generate token")
 	}
@@ -67,3 +84,30 @@ func (s *SessionService) Logout(ctx context.Context, req *emptypb.Empty) (*empty
 	// For this demo, we just return success
 	return &emptypb.Empty{}, nil
 }
+
+// getUserIDFromContext returns the user a request is made by, from the
+// token issued by Login in its "authorization: Bearer <token>" metadata.
+// Requests without a valid token fail with Unauthenticated.
+func getUserIDFromContext(ctx context.Context) (int32, error) {
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok || len(md.Get("authorization")) == 0 {
+		return 0, status.Error(codes.Unauthenticated, "Authorization token is required")
+	}
+	tokenString := strings.TrimPrefix(md.Get("authorization")[0], "Bearer ")
+
+	token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
+		return jwtSecret, nil
+	}, jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Alg()}))
+	if err != nil || !token.Valid {
+		return 0, status.Error(codes.Unauthenticated, "Invalid authorization token")
+	}
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok {
+		return 0, status.Error(codes.Unauthenticated, "Invalid authorization token")
+	}
+	userID, ok := claims["user_id"].(float64)
+	if !ok || userID <= 0 {
+		return 0, status.Error(codes.Unauthenticated, "Invalid authorization token")
+	}
+	return int32(userID), nil
+}
@@ -64,6 +64,10 @@ func (s *UserService) GetUser(ctx context.Context, req *pb.GetUserRequest) (*pb.
 }
 
 func (s *UserService) UpdateUser(ctx context.Context, req *pb.UpdateUserRequest) (*pb.User, error) {
+	if req.User == nil {
+		return nil, status.Error(codes.InvalidArgument, "User is required")
+	}
+
 	// Get existing user
 	existing, err := s.storage.GetUser(req.Id)
 	if err != nil {
@@ -78,22 +82,44 @@ func (s *UserService) UpdateUser(ctx context.Context, req *pb.UpdateUserRequest)
 		Id:       existing.Id,
 		Username: existing.Username,
 		Email:    existing.Email,
+		Version:  req.ExpectedVersion,
+	}
+
+	mask, err := newFieldMask(req.UpdateMask, "username", "email", "password")
+	if err != nil {
+		return nil, err
 	}
 
-	if req.User.Username != "" {
+	if mask.has("username", req.User.Username != "") {
+		if req.User.Username == "" {
+			return nil, status.Error(codes.InvalidArgument, "Username is required")
+		}
 		updated.Username = req.User.Username
 	}
-	if req.User.Email != "" {
+	if mask.has("email", req.User.Email != "") {
+		if req.User.Email == "" {
+			return nil, status.Error(codes.InvalidArgument, "Email is required")
+		}
 		updated.Email = req.User.Email
 	}
+	setPassword := mask.has("password", req.User.Password != "")
+	if setPassword && req.User.Password == "" {
+		return nil, status.Error(codes.InvalidArgument, "Password is required")
+	}
 
 	err = s.storage.UpdateUser(req.Id, updated)
 	if err != nil {
+		switch err.(type) {
+		case *storage.NotFoundError:
+			return nil, status.Error(codes.NotFound, "User not found")
+		case *storage.VersionConflictError:
+			return nil, status.Error(codes.Aborted, err.Error())
+		}
 		return nil, status.Error(codes.Internal, "Failed to update user")
 	}
 
 	// Update password if provided
-	if req.User.Password != "" {
+	if setPassword {
 		hashedPassword := hashPassword(req.User.Password)
 		if memStorage, ok := s.storage.(*storage.InMemoryStorage); ok {
 			memStorage.SetUserPassword(req.Id, hashedPassword)
@@ -104,6 +130,10 @@ func (s *UserService) UpdateUser(ctx context.Context, req *pb.UpdateUserRequest)
 }
 
 func (s *UserService) ReplaceUser(ctx context.Context, req *pb.UpdateUserRequest) (*pb.User, error) {
+	if req.User == nil {
+		return nil, status.Error(codes.InvalidArgument, "User is required")
+	}
+
 	// Check if user exists
 	_, err := s.storage.GetUser(req.Id)
 	if err != nil {
@@ -122,10 +152,17 @@ func (s *UserService) ReplaceUser(ctx context.Context, req *pb.UpdateUserRequest
 		Id:       req.Id,
 		Username: req.User.Username,
 		Email:    req.User.Email,
+		Version:  req.ExpectedVersion,
 	}
 
 	err = s.storage.UpdateUser(req.Id, user)
 	if err != nil {
+		switch err.(type) {
+		case *storage.NotFoundError:
+			return nil, status.Error(codes.NotFound, "User not found")
+		case *storage.VersionConflictError:
+			return nil, status.Error(codes.Aborted, err.Error())
+		}
 		return nil, status.Error(codes.Internal, "Failed to replace user")
 	}
 
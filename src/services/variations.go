@@ -0,0 +1,115 @@
+package services
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	pb "ebayclone-grpc/proto"
+	"ebayclone-grpc/src/money"
+)
+
+// buildVariations validates requested variations and numbers them from 1.
+// Attributes are checked against the category separately and images are
+// stored once the whole listing is valid. All variations must be priced in
+// the same currency.
+func buildVariations(reqs []*pb.ListingVariationCreate) ([]*pb.ListingVariation, error) {
+	var variations []*pb.ListingVariation
+	for i, req := range reqs {
+		if err := validatePrice(req.Price); err != nil {
+			return nil, status.Error(codes.InvalidArgument, fmt.Sprintf("Variation %d: %s", i+1, status.Convert(err).Message()))
+		}
+		if i > 0 {
+			if err := money.SameCurrency(reqs[0].Price, req.Price); err != nil {
+				return nil, status.Error(codes.InvalidArgument, fmt.Sprintf("Variation %d: %s", i+1, err.Error()))
+			}
+		}
+		if req.Quantity < 0 {
+			return nil, status.Error(codes.InvalidArgument, fmt.Sprintf("Variation %d: quantity must not be negative", i+1))
+		}
+		if err := validateImages(req.Images, req.ImageIds); err != nil {
+			return nil, status.Error(codes.InvalidArgument, fmt.Sprintf("Variation %d: %s", i+1, status.Convert(err).Message()))
+		}
+
+		variations = append(variations, &pb.ListingVariation{
+			Id:         int32(i + 1),
+			Attributes: req.Attributes,
+			Price:      req.Price,
+			Quantity:   req.Quantity,
+		})
+	}
+	return variations, nil
+}
+
+// validateVariations checks that every variation is a distinct combination
+// of the same attributes and that, together with the listing's shared
+// attributes, each one matches the category schema.
+func validateVariations(schemas map[string]*pb.AttributeSchema, shared []*pb.AttributeValue, variations []*pb.ListingVariation) error {
+	var names string
+	combinations := make(map[string]bool)
+	for i, variation := range variations {
+		if len(variation.Attributes) == 0 {
+			return status.Error(codes.InvalidArgument, fmt.Sprintf("Variation %d must have attributes", i+1))
+		}
+
+		attributes := append(append([]*pb.AttributeValue{}, shared...), variation.Attributes...)
+		if err := validateAttributes(schemas, attributes); err != nil {
+			return status.Error(codes.InvalidArgument, fmt.Sprintf("Variation %d: %s", i+1, status.Convert(err).Message()))
+		}
+
+		variationNames, combination := variationKey(variation.Attributes)
+		if i == 0 {
+			names = variationNames
+		} else if variationNames != names {
+			return status.Error(codes.InvalidArgument, "All variations must set the same attributes")
+		}
+		if combinations[combination] {
+			return status.Error(codes.InvalidArgument, fmt.Sprintf("Variation %d duplicates another variation", i+1))
+		}
+		combinations[combination] = true
+	}
+	return nil
+}
+
+// variationKey returns the sorted attribute names of a variation and a key
+// identifying its combination of values.
+func variationKey(attributes []*pb.AttributeValue) (string, string) {
+	pairs := make([]string, 0, len(attributes))
+	for _, attribute := range attributes {
+		var value string
+		switch v := attribute.Value.(type) {
+		case *pb.AttributeValue_StringValue:
+			value = strings.ToLower(v.StringValue)
+		case *pb.AttributeValue_NumberValue:
+			value = fmt.Sprint(v.NumberValue)
+		case *pb.AttributeValue_BoolValue:
+			value = fmt.Sprint(v.BoolValue)
+		}
+		pairs = append(pairs, attribute.Name+"\x00"+value)
+	}
+	sort.Strings(pairs)
+
+	names := make([]string, len(pairs))
+	for i, pair := range pairs {
+		names[i] = pair[:strings.IndexByte(pair, 0)]
+	}
+	return strings.Join(names, "\x01"), strings.Join(pairs, "\x01")
+}
+
+// variationTotals returns the lowest price and the total stock across
+// variations, which the listing itself reports.
+// Variations share a currency, checked by buildVariations.
+func variationTotals(variations []*pb.ListingVariation) (*pb.Money, int32) {
+	var price *pb.Money
+	var quantity int32
+	for _, variation := range variations {
+		if price == nil || variation.Price.MinorUnits < price.MinorUnits {
+			price = variation.Price
+		}
+		quantity += variation.Quantity
+	}
+	return price, quantity
+}
@@ -0,0 +1,260 @@
+package storage
+
+import (
+	"time"
+
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/timestamppb"
+
+	pb "ebayclone-grpc/proto"
+	"ebayclone-grpc/src/auction"
+)
+
+// NotAuctionError is returned when bidding on a fixed-price listing.
+type NotAuctionError struct {
+	ID int32
+}
+
+func (e *NotAuctionError) Error() string {
+	return "Listing is not an auction"
+}
+
+// AuctionListingError is returned when ordering from an auction directly
+// instead of bidding on it.
+type AuctionListingError struct {
+	ID int32
+}
+
+func (e *AuctionListingError) Error() string {
+	return "Listing is an auction; place a bid instead"
+}
+
+// AuctionEndedError is returned for bids that arrive after an auction's end
+// time, even if it has not been closed yet.
+type AuctionEndedError struct {
+	ID int32
+}
+
+func (e *AuctionEndedError) Error() string {
+	return "Auction has ended"
+}
+
+// BuyItNowUnavailableError is returned when buying an auction that has no
+// Buy It Now price or whose bidding has gone past it.
+type BuyItNowUnavailableError struct {
+	ID int32
+}
+
+func (e *BuyItNowUnavailableError) Error() string {
+	return "Buy It Now is not available for this listing"
+}
+
+type SelfBidError struct {
+	ListingID int32
+}
+
+func (e *SelfBidError) Error() string {
+	return "Sellers cannot bid on their own listings"
+}
+
+// PlaceBid resolves a bid on an active auction against the high bidder's
+// maximum and records it, along with any automatic bid it triggered, in the
+// same transaction, so concurrent bids are applied one at a time against the
+// latest price. address is kept privately for the order if the bidder wins.
+// Bids within the auction's extension window that change the price or the
+// high bidder push back its end; a high bidder raising their maximum does
+// not.
+func (s *InMemoryStorage) PlaceBid(bid *pb.Bid, address *pb.Address, now time.Time) (*pb.Listing, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	listing, exists := s.listings[bid.ListingId]
+	if !exists {
+		return nil, &NotFoundError{Resource: "Listing", ID: bid.ListingId}
+	}
+	if err := checkBiddable(listing, bid.UserId, now); err != nil {
+		return nil, err
+	}
+
+	updated := proto.Clone(listing).(*pb.Listing)
+	placed, err := auction.Bid(updated, s.leaderMax(listing), bid)
+	if err != nil {
+		return nil, err
+	}
+	if !proto.Equal(updated.Price, listing.Price) || updated.Auction.HighBidderId != listing.Auction.HighBidderId {
+		auction.Extend(updated, now)
+	}
+	updated.UpdatedAt = timestamppb.New(now)
+	updated.Version++
+	s.listings[listing.Id] = updated
+
+	for _, placedBid := range placed {
+		placedBid.Id = s.bidID
+		placedBid.CreatedAt = timestamppb.New(now)
+		s.bids[listing.Id] = append(s.bids[listing.Id], placedBid)
+		s.bidID++
+	}
+	s.addresses[bid.Id] = address
+	s.publishChange(listing, updated, placed, now)
+	return updated, nil
+}
+
+// BuyItNow sells an auction to userID at its Buy It Now price, ending it
+// immediately through an order created in the same transaction.
+func (s *InMemoryStorage) BuyItNow(id int32, userID int32, address *pb.Address, now time.Time) (*pb.Listing, *pb.Order, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	listing, exists := s.listings[id]
+	if !exists {
+		return nil, nil, &NotFoundError{Resource: "Listing", ID: id}
+	}
+	if err := checkBiddable(listing, userID, now); err != nil {
+		return nil, nil, err
+	}
+	if !listing.Auction.BuyItNowAvailable {
+		return nil, nil, &BuyItNowUnavailableError{ID: id}
+	}
+
+	updated, order := s.sellAuction(listing, userID, listing.Auction.BuyItNowPrice, address, pb.OrderActor_ORDER_ACTOR_BUYER, now)
+	return updated, order, nil
+}
+
+// checkBiddable checks that userID may bid on or buy listing at now.
+func checkBiddable(listing *pb.Listing, userID int32, now time.Time) error {
+	if listing.Auction == nil {
+		return &NotAuctionError{ID: listing.Id}
+	}
+	if listing.Status != pb.ListingStatus_LISTING_STATUS_ACTIVE {
+		return &ListingNotActiveError{ID: listing.Id, Status: listing.Status}
+	}
+	if listing.EndsAt != nil && !now.Before(listing.EndsAt.AsTime()) {
+		return &AuctionEndedError{ID: listing.Id}
+	}
+	if listing.UserId == userID {
+		return &SelfBidError{ListingID: listing.Id}
+	}
+	return nil
+}
+
+// leaderMax returns the maximum of an auction's high bidder, from their
+// latest bid. Callers must hold the lock.
+func (s *InMemoryStorage) leaderMax(listing *pb.Listing) *pb.Money {
+	bids := s.bids[listing.Id]
+	for i := len(bids) - 1; i >= 0; i-- {
+		if bids[i].UserId == listing.Auction.HighBidderId {
+			return bids[i].MaxAmount
+		}
+	}
+	return nil
+}
+
+// GetBids returns the bids on a listing, oldest first. Bids are kept after
+// the listing is deleted.
+func (s *InMemoryStorage) GetBids(listingID int32) ([]*pb.Bid, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	bids := s.bids[listingID]
+	if _, exists := s.listings[listingID]; !exists && len(bids) == 0 {
+		return nil, &NotFoundError{Resource: "Listing", ID: listingID}
+	}
+	return append([]*pb.Bid(nil), bids...), nil
+}
+
+// CloseAuction ends an auction whose run is over at now. If the reserve was
+// met, the listing is sold to the high bidder through an order created in
+// the same transaction, which is returned with the listing. It returns nil
+// if the auction has not ended.
+func (s *InMemoryStorage) CloseAuction(id int32, now time.Time) (*pb.Listing, *pb.Order, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	listing, exists := s.listings[id]
+	if !exists {
+		return nil, nil, &NotFoundError{Resource: "Listing", ID: id}
+	}
+	if listing.Auction == nil || listing.EndsAt == nil || now.Before(listing.EndsAt.AsTime()) ||
+		!CanTransitionListing(listing.Status, pb.ListingStatus_LISTING_STATUS_ENDED) {
+		return nil, nil, nil
+	}
+
+	winner := auction.Winner(listing.Auction)
+	if winner == 0 || listing.Status != pb.ListingStatus_LISTING_STATUS_ACTIVE {
+		return s.setListingStatus(listing, pb.ListingStatus_LISTING_STATUS_ENDED, now), nil, nil
+	}
+
+	updated, order := s.sellAuction(listing, winner, listing.Price, s.winningAddress(listing), pb.OrderActor_ORDER_ACTOR_SYSTEM, now)
+	return updated, order, nil
+}
+
+// sellAuction records an order for an auction's item and marks the listing
+// sold. Callers must hold the write lock.
+func (s *InMemoryStorage) sellAuction(listing *pb.Listing, buyerID int32, price *pb.Money, address *pb.Address, actor pb.OrderActor, now time.Time) (*pb.Listing, *pb.Order) {
+	order := &pb.Order{
+		Id:       s.orderID,
+		UserId:   buyerID,
+		SellerId: listing.UserId,
+		Items: []*pb.OrderItem{{
+			ListingId:       listing.Id,
+			Quantity:        listing.Quantity,
+			UnitPrice:       price,
+			Subtotal:        price,
+			ListingRevision: listing.Revision,
+		}},
+		TotalPrice:      price,
+		ShippingAddress: address,
+		CreatedAt:       timestamppb.New(now),
+		UpdatedAt:       timestamppb.New(now),
+		Version:         1,
+	}
+	startHistory(order, actor, buyerID, now)
+	s.orders[s.orderID] = order
+	s.orderID++
+
+	// Selling the whole quantity marks the listing sold
+	updated := s.adjustStock(listing, 0, -listing.Quantity)
+	updated.Auction.OrderId = order.Id
+	updated.Auction.BuyItNowAvailable = false
+	updated.UpdatedAt = timestamppb.New(now)
+	s.publishChange(listing, updated, nil, now)
+	return updated, order
+}
+
+// unsellAuction takes back the item of an auction whose sale was cancelled.
+// The auction is over, so rather than selling to the same bidder again when
+// it is next closed, it ends unsold without a winner. Callers must hold the
+// write lock.
+func (s *InMemoryStorage) unsellAuction(listing *pb.Listing, quantity int32, now time.Time) {
+	updated := proto.Clone(listing).(*pb.Listing)
+	updated.Quantity += quantity
+	updated.Status = pb.ListingStatus_LISTING_STATUS_ENDED
+	updated.Auction.HighBidderId = 0
+	updated.Auction.OrderId = 0
+	updated.Auction.BuyItNowAvailable = false
+	updated.UpdatedAt = timestamppb.New(now)
+	updated.Version++
+	s.listings[listing.Id] = updated
+	s.publishChange(listing, updated, nil, now)
+}
+
+// winningAddress returns the address the high bidder gave with their latest
+// bid of their own. Callers must hold the lock.
+func (s *InMemoryStorage) winningAddress(listing *pb.Listing) *pb.Address {
+	bids := s.bids[listing.Id]
+	for i := len(bids) - 1; i >= 0; i-- {
+		if bids[i].UserId == listing.Auction.HighBidderId && !bids[i].Automatic {
+			return s.addresses[bids[i].Id]
+		}
+	}
+	return nil
+}
+
+// listingFormat returns the format of a listing, treating unspecified as
+// fixed price.
+func listingFormat(listing *pb.Listing) pb.ListingFormat {
+	if listing.Format == pb.ListingFormat_LISTING_FORMAT_UNSPECIFIED {
+		return pb.ListingFormat_LISTING_FORMAT_FIXED_PRICE
+	}
+	return listing.Format
+}
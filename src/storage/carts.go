@@ -0,0 +1,196 @@
+package storage
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/timestamppb"
+
+	pb "ebayclone-grpc/proto"
+)
+
+// EmptyCartError is returned when checking out a cart without items.
+type EmptyCartError struct {
+	UserID int32
+}
+
+func (e *EmptyCartError) Error() string {
+	return "Cart is empty"
+}
+
+// CartItemUnavailableError is returned when checkout cannot order an item
+// in the cart, e.g. because it sold out after it was added. Err says why.
+type CartItemUnavailableError struct {
+	ListingID   int32
+	VariationID int32
+	Err         error
+}
+
+func (e *CartItemUnavailableError) Error() string {
+	return fmt.Sprintf("Cart item for listing %d is unavailable: %v", e.ListingID, e.Err)
+}
+
+func (e *CartItemUnavailableError) Unwrap() error {
+	return e.Err
+}
+
+// SelfPurchaseError is returned when checking out a cart holding one of the
+// buyer's own listings.
+type SelfPurchaseError struct {
+	ListingID int32
+}
+
+func (e *SelfPurchaseError) Error() string {
+	return "Sellers cannot buy their own listings"
+}
+
+// GetCart returns a user's cart items in the order they were added.
+func (s *InMemoryStorage) GetCart(userID int32) ([]*pb.CartItem, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return append([]*pb.CartItem(nil), s.carts[userID]...), nil
+}
+
+// AddCartItem adds item to a user's cart, or adds its quantity to the item
+// already there for the same listing and variation. Only fixed-price
+// listings can be added; stock is checked at checkout.
+func (s *InMemoryStorage) AddCartItem(userID int32, item *pb.CartItem, now time.Time) ([]*pb.CartItem, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	listing, exists := s.listings[item.ListingId]
+	if !exists {
+		return nil, &NotFoundError{Resource: "Listing", ID: item.ListingId}
+	}
+	if listing.Auction != nil {
+		return nil, &AuctionListingError{ID: listing.Id}
+	}
+	if len(listing.Variations) > 0 && item.VariationId == 0 {
+		return nil, &VariationRequiredError{ListingID: listing.Id}
+	}
+	if item.VariationId != 0 && FindVariation(listing, item.VariationId) == nil {
+		return nil, &NotFoundError{Resource: "Variation", ID: item.VariationId}
+	}
+
+	items := append([]*pb.CartItem(nil), s.carts[userID]...)
+	if i := findCartItem(items, item.ListingId, item.VariationId); i >= 0 {
+		updated := proto.Clone(items[i]).(*pb.CartItem)
+		updated.Quantity += item.Quantity
+		items[i] = updated
+	} else {
+		item.AddedAt = timestamppb.New(now)
+		items = append(items, item)
+	}
+	s.carts[userID] = items
+	return items, nil
+}
+
+// SetCartItemQuantity replaces the quantity of an item in a user's cart,
+// removing it when quantity is 0.
+func (s *InMemoryStorage) SetCartItemQuantity(userID int32, listingID int32, variationID int32, quantity int32) ([]*pb.CartItem, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	items := append([]*pb.CartItem(nil), s.carts[userID]...)
+	i := findCartItem(items, listingID, variationID)
+	if i < 0 {
+		return nil, &NotFoundError{Resource: "Cart item", ID: listingID}
+	}
+
+	if quantity == 0 {
+		items = append(items[:i], items[i+1:]...)
+	} else {
+		updated := proto.Clone(items[i]).(*pb.CartItem)
+		updated.Quantity = quantity
+		items[i] = updated
+	}
+	s.carts[userID] = items
+	return items, nil
+}
+
+// Checkout orders every item in a user's cart at its current price and
+// empties the cart, all in one transaction: if any item cannot be ordered,
+// nothing is. Items from the same seller in the same currency share an
+// order. Orders are returned sorted by seller, each following the order its
+// first item was added in.
+func (s *InMemoryStorage) Checkout(userID int32, address *pb.Address, buyerNotes string, now time.Time) ([]*pb.Order, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	items := s.carts[userID]
+	if len(items) == 0 {
+		return nil, &EmptyCartError{UserID: userID}
+	}
+
+	// Check every item before reserving any
+	var orders []*pb.Order
+	for _, item := range items {
+		price, err := s.checkStock(item.ListingId, item.VariationId, item.Quantity)
+		if err != nil {
+			return nil, &CartItemUnavailableError{ListingID: item.ListingId, VariationID: item.VariationId, Err: err}
+		}
+		sellerID := s.listings[item.ListingId].UserId
+		if sellerID == userID {
+			return nil, &SelfPurchaseError{ListingID: item.ListingId}
+		}
+		orderItem := &pb.OrderItem{
+			ListingId:   item.ListingId,
+			VariationId: item.VariationId,
+			Quantity:    item.Quantity,
+			UnitPrice:   price,
+		}
+
+		if order := findSellerOrder(orders, sellerID, price.CurrencyCode); order != nil {
+			order.Items = append(order.Items, orderItem)
+			continue
+		}
+		orders = append(orders, &pb.Order{
+			UserId:          userID,
+			SellerId:        sellerID,
+			Items:           []*pb.OrderItem{orderItem},
+			ShippingAddress: address,
+			BuyerNotes:      buyerNotes,
+		})
+	}
+	for _, order := range orders {
+		if err := TotalOrder(order); err != nil {
+			return nil, err
+		}
+	}
+
+	// Take back the orders already placed if a later one fails
+	for i, order := range orders {
+		if err := s.placeOrder(order, now); err != nil {
+			for _, placed := range orders[:i] {
+				s.releaseItems(placed.Items)
+				delete(s.orders, placed.Id)
+			}
+			return nil, &CartItemUnavailableError{ListingID: order.Items[0].ListingId, VariationID: order.Items[0].VariationId, Err: err}
+		}
+	}
+	sort.SliceStable(orders, func(i, j int) bool { return orders[i].SellerId < orders[j].SellerId })
+	delete(s.carts, userID)
+	return orders, nil
+}
+
+// findSellerOrder returns the order for a seller's items in a currency.
+func findSellerOrder(orders []*pb.Order, sellerID int32, currency string) *pb.Order {
+	for _, order := range orders {
+		if order.SellerId == sellerID && order.Items[0].UnitPrice.CurrencyCode == currency {
+			return order
+		}
+	}
+	return nil
+}
+
+func findCartItem(items []*pb.CartItem, listingID int32, variationID int32) int {
+	for i, item := range items {
+		if item.ListingId == listingID && item.VariationId == variationID {
+			return i
+		}
+	}
+	return -1
+}
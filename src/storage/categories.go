@@ -0,0 +1,244 @@
+package storage
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+
+	pb "ebayclone-grpc/proto"
+)
+
+type CategoryExistsError struct {
+	Slug string
+}
+
+func (e *CategoryExistsError) Error() string {
+	return "Category with slug " + e.Slug + " already exists"
+}
+
+// CategoryInUseError is returned when deleting a category that still has
+// subcategories or listings.
+type CategoryInUseError struct {
+	Slug   string
+	Reason string
+}
+
+func (e *CategoryInUseError) Error() string {
+	return "Category " + e.Slug + " is in use: " + e.Reason
+}
+
+// InvalidParentError is returned when a category's parent would make the
+// tree cyclic.
+type InvalidParentError struct {
+	ID       int32
+	ParentID int32
+}
+
+func (e *InvalidParentError) Error() string {
+	return "Category cannot be moved under itself or one of its descendants"
+}
+
+// Category methods
+func (s *InMemoryStorage) CreateCategory(category *pb.Category) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.categoryBySlug(category.Slug) != nil {
+		return &CategoryExistsError{Slug: category.Slug}
+	}
+	if category.ParentId != 0 {
+		if _, exists := s.categories[category.ParentId]; !exists {
+			return &NotFoundError{Resource: "Category", ID: category.ParentId}
+		}
+	}
+
+	category.Id = s.categoryID
+	s.categories[s.categoryID] = category
+	s.categoryID++
+	return nil
+}
+
+func (s *InMemoryStorage) GetCategory(id int32) (*pb.Category, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	category, exists := s.categories[id]
+	if !exists {
+		return nil, &NotFoundError{Resource: "Category", ID: id}
+	}
+	return category, nil
+}
+
+func (s *InMemoryStorage) GetCategoryBySlug(slug string) (*pb.Category, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	category := s.categoryBySlug(slug)
+	if category == nil {
+		return nil, &NotFoundError{Resource: "Category"}
+	}
+	return category, nil
+}
+
+// GetCategories returns the children of parentID (0 for top-level categories),
+// or the whole subtree when recursive is set, ordered by id.
+func (s *InMemoryStorage) GetCategories(parentID int32, recursive bool) ([]*pb.Category, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if parentID != 0 {
+		if _, exists := s.categories[parentID]; !exists {
+			return nil, &NotFoundError{Resource: "Category", ID: parentID}
+		}
+	}
+
+	var result []*pb.Category
+	for _, category := range s.categories {
+		if category.ParentId == parentID || (recursive && s.isDescendant(category.Id, parentID)) {
+			result = append(result, category)
+		}
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Id < result[j].Id })
+	return result, nil
+}
+
+func (s *InMemoryStorage) UpdateCategory(id int32, category *pb.Category) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	existing, exists := s.categories[id]
+	if !exists {
+		return &NotFoundError{Resource: "Category", ID: id}
+	}
+	if category.ParentId != 0 {
+		if _, exists := s.categories[category.ParentId]; !exists {
+			return &NotFoundError{Resource: "Category", ID: category.ParentId}
+		}
+		if category.ParentId == id || s.isDescendant(category.ParentId, id) {
+			return &InvalidParentError{ID: id, ParentID: category.ParentId}
+		}
+	}
+
+	// Listings reference categories by slug, so it never changes
+	category.Id = id
+	category.Slug = existing.Slug
+	s.categories[id] = category
+	return nil
+}
+
+func (s *InMemoryStorage) DeleteCategory(id int32) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	category, exists := s.categories[id]
+	if !exists {
+		return &NotFoundError{Resource: "Category", ID: id}
+	}
+	for _, other := range s.categories {
+		if other.ParentId == id {
+			return &CategoryInUseError{Slug: category.Slug, Reason: "it has subcategories"}
+		}
+	}
+	for _, listing := range s.listings {
+		if listing.Category == category.Slug {
+			return &CategoryInUseError{Slug: category.Slug, Reason: "it has listings"}
+		}
+	}
+
+	delete(s.categories, id)
+	return nil
+}
+
+func (s *InMemoryStorage) categoryBySlug(slug string) *pb.Category {
+	for _, category := range s.categories {
+		if category.Slug == slug {
+			return category
+		}
+	}
+	return nil
+}
+
+// isDescendant reports whether id sits anywhere below ancestorID. Every
+// category descends from the virtual root 0.
+func (s *InMemoryStorage) isDescendant(id, ancestorID int32) bool {
+	for category, exists := s.categories[id]; exists; category, exists = s.categories[category.ParentId] {
+		if category.ParentId == ancestorID {
+			return true
+		}
+	}
+	return false
+}
+
+// categorySubtree returns the slugs of the category with the given slug and
+// all of its descendants.
+func (s *InMemoryStorage) categorySubtree(slug string) map[string]bool {
+	root := s.categoryBySlug(slug)
+	if root == nil {
+		return map[string]bool{}
+	}
+
+	slugs := map[string]bool{root.Slug: true}
+	for _, category := range s.categories {
+		if s.isDescendant(category.Id, root.Id) {
+			slugs[category.Slug] = true
+		}
+	}
+	return slugs
+}
+
+// matchesAttributes reports whether the listing, or at least one of its
+// variations, satisfies every filter. Variation attributes are combined with
+// the listing's shared attributes.
+func matchesAttributes(listing *pb.Listing, filters []*pb.AttributeFilter) bool {
+	if len(listing.Variations) == 0 {
+		return matchesAttributeSet(filters, listing.Attributes)
+	}
+	for _, variation := range listing.Variations {
+		if matchesAttributeSet(filters, listing.Attributes, variation.Attributes) {
+			return true
+		}
+	}
+	return false
+}
+
+func matchesAttributeSet(filters []*pb.AttributeFilter, sets ...[]*pb.AttributeValue) bool {
+	for _, filter := range filters {
+		var value *pb.AttributeValue
+		for _, attributes := range sets {
+			for _, attribute := range attributes {
+				if attribute.Name == filter.Name {
+					value = attribute
+					break
+				}
+			}
+		}
+		if value == nil || !matchesAttribute(value, filter) {
+			return false
+		}
+	}
+	return true
+}
+
+func matchesAttribute(value *pb.AttributeValue, filter *pb.AttributeFilter) bool {
+	switch v := value.Value.(type) {
+	case *pb.AttributeValue_StringValue:
+		return len(filter.Values) == 0 || matchesAny(filter.Values, v.StringValue)
+	case *pb.AttributeValue_BoolValue:
+		return len(filter.Values) == 0 || matchesAny(filter.Values, strconv.FormatBool(v.BoolValue))
+	case *pb.AttributeValue_NumberValue:
+		if filter.Min != nil && v.NumberValue < *filter.Min {
+			return false
+		}
+		return filter.Max == nil || v.NumberValue <= *filter.Max
+	}
+	return false
+}
+
+func matchesAny(values []string, value string) bool {
+	for _, v := range values {
+		if strings.EqualFold(v, value) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,133 @@
+package storage
+
+import (
+	"math"
+
+	pb "ebayclone-grpc/proto"
+)
+
+const (
+	earthRadiusKm = 6371.0
+	kmPerDegree   = earthRadiusKm * math.Pi / 180
+	// Listings are bucketed into cells of geoCellDegrees x geoCellDegrees so a
+	// radius query only has to look at the cells overlapping its bounding box.
+	geoCellDegrees = 0.5
+)
+
+type geoCell struct {
+	lat int
+	lng int
+}
+
+// geoIndex is a uniform grid over latitude/longitude used to answer
+// radius queries without scanning every listing.
+type geoIndex struct {
+	cells  map[geoCell]map[int32]*pb.GeoPoint
+	points map[int32]geoCell
+}
+
+func newGeoIndex() *geoIndex {
+	return &geoIndex{
+		cells:  make(map[geoCell]map[int32]*pb.GeoPoint),
+		points: make(map[int32]geoCell),
+	}
+}
+
+// indexCell returns the cell a listing at point is indexed in. Longitude 180
+// is the same meridian as -180, where the cells of a query wrap to.
+func indexCell(point *pb.GeoPoint) geoCell {
+	cell := cellFor(point)
+	if point.Longitude >= 180 {
+		cell.lng = int(math.Floor(-180 / geoCellDegrees))
+	}
+	return cell
+}
+
+func cellFor(point *pb.GeoPoint) geoCell {
+	return geoCell{
+		lat: int(math.Floor(point.Latitude / geoCellDegrees)),
+		lng: int(math.Floor(point.Longitude / geoCellDegrees)),
+	}
+}
+
+// Put indexes (or re-indexes) a listing; a nil point removes it.
+func (g *geoIndex) Put(id int32, point *pb.GeoPoint) {
+	g.Remove(id)
+	if point == nil {
+		return
+	}
+
+	cell := indexCell(point)
+	if g.cells[cell] == nil {
+		g.cells[cell] = make(map[int32]*pb.GeoPoint)
+	}
+	g.cells[cell][id] = point
+	g.points[id] = cell
+}
+
+func (g *geoIndex) Remove(id int32) {
+	cell, exists := g.points[id]
+	if !exists {
+		return
+	}
+
+	delete(g.cells[cell], id)
+	if len(g.cells[cell]) == 0 {
+		delete(g.cells, cell)
+	}
+	delete(g.points, id)
+}
+
+// Within returns the ids of all indexed listings within radiusKm of center.
+func (g *geoIndex) Within(center *pb.GeoPoint, radiusKm float64) map[int32]bool {
+	result := make(map[int32]bool)
+	check := func(points map[int32]*pb.GeoPoint) {
+		for id, point := range points {
+			if DistanceKm(center, point) <= radiusKm {
+				result[id] = true
+			}
+		}
+	}
+
+	latSpan := radiusKm / kmPerDegree
+	minLat := center.Latitude - latSpan
+	maxLat := center.Latitude + latSpan
+	cosLat := math.Min(math.Cos(minLat*math.Pi/180), math.Cos(maxLat*math.Pi/180))
+
+	// Near the poles or for very large radii the bounding box degenerates, so
+	// just check every indexed point.
+	if minLat <= -90 || maxLat >= 90 || cosLat <= 0 || latSpan/cosLat >= 180 {
+		for _, points := range g.cells {
+			check(points)
+		}
+		return result
+	}
+
+	lngSpan := latSpan / cosLat
+	minCell := cellFor(&pb.GeoPoint{Latitude: minLat, Longitude: center.Longitude - lngSpan})
+	maxCell := cellFor(&pb.GeoPoint{Latitude: maxLat, Longitude: center.Longitude + lngSpan})
+	lngCells := int(360 / geoCellDegrees)
+	minLngCell := int(math.Floor(-180 / geoCellDegrees))
+
+	for lat := minCell.lat; lat <= maxCell.lat; lat++ {
+		for lng := minCell.lng; lng <= maxCell.lng; lng++ {
+			// Wrap cells that cross the antimeridian back into [-180, 180)
+			wrapped := ((lng-minLngCell)%lngCells+lngCells)%lngCells + minLngCell
+			check(g.cells[geoCell{lat: lat, lng: wrapped}])
+		}
+	}
+	return result
+}
+
+// DistanceKm returns the great-circle distance between two points using the
+// haversine formula.
+func DistanceKm(a, b *pb.GeoPoint) float64 {
+	lat1 := a.Latitude * math.Pi / 180
+	lat2 := b.Latitude * math.Pi / 180
+	dLat := lat2 - lat1
+	dLng := (b.Longitude - a.Longitude) * math.Pi / 180
+
+	h := math.Sin(dLat/2)*math.Sin(dLat/2) +
+		math.Cos(lat1)*math.Cos(lat2)*math.Sin(dLng/2)*math.Sin(dLng/2)
+	return 2 * earthRadiusKm * math.Asin(math.Min(1, math.Sqrt(h)))
+}
@@ -0,0 +1,202 @@
+package storage
+
+import (
+	"time"
+
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/timestamppb"
+
+	pb "ebayclone-grpc/proto"
+)
+
+// ImageUnavailableError is returned when a listing is given an image its
+// seller did not upload, or one another listing already shows. Each image
+// belongs to one listing so deleting it never breaks another.
+type ImageUnavailableError struct {
+	ID     string
+	Reason string
+}
+
+func (e *ImageUnavailableError) Error() string {
+	return e.Reason + ": " + e.ID
+}
+
+// Image methods. Image records track the processing of uploaded images; the
+// bytes themselves live in the image store.
+func (s *InMemoryStorage) CreateImage(image *pb.ListingImage) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.images[image.Id] = image
+	s.refreshListingImages(image.Id)
+	return nil
+}
+
+func (s *InMemoryStorage) GetImage(id string) (*pb.ListingImage, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	image, exists := s.images[id]
+	if !exists {
+		return nil, &NotFoundError{Resource: "Image"}
+	}
+	return image, nil
+}
+
+// UpdateImage replaces an image record and the copies held by listings that
+// show the image. Images deleted in the meantime are not brought back. The
+// owner and listing of an image are kept.
+func (s *InMemoryStorage) UpdateImage(image *pb.ListingImage) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	existing, exists := s.images[image.Id]
+	if !exists {
+		return &NotFoundError{Resource: "Image"}
+	}
+
+	image.OwnerId = existing.OwnerId
+	image.ListingId = existing.ListingId
+	s.images[image.Id] = image
+	s.refreshListingImages(image.Id)
+	return nil
+}
+
+func (s *InMemoryStorage) DeleteImage(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, exists := s.images[id]; !exists {
+		return &NotFoundError{Resource: "Image"}
+	}
+	delete(s.images, id)
+	s.refreshListingImages(id)
+	return nil
+}
+
+// UpdateListingImages replaces the images of a listing with the result of
+// update, which is given the current images. It runs under the write lock so
+// concurrent image changes cannot overwrite each other; an error from update
+// is returned unchanged. The change is recorded as a revision made by userID.
+func (s *InMemoryStorage) UpdateListingImages(id int32, userID int32, update func(images []string) ([]string, error)) (*pb.Listing, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	listing, exists := s.listings[id]
+	if !exists {
+		return nil, &NotFoundError{Resource: "Listing", ID: id}
+	}
+
+	images, err := update(append([]string(nil), listing.Images...))
+	if err != nil {
+		return nil, err
+	}
+
+	updated := proto.Clone(listing).(*pb.Listing)
+	updated.Images = images
+	if err := s.checkImages(updated); err != nil {
+		return nil, err
+	}
+	s.claimImages(updated)
+	now := time.Now()
+	updated.UpdatedAt = timestamppb.New(now)
+	updated.Version++
+	s.attachImages(updated)
+	s.recordRevision(listing, updated, userID, now)
+	s.listings[id] = updated
+	return updated, nil
+}
+
+// checkImages checks that every image of a listing and its variations was
+// uploaded by its seller and is shown by no other listing. Callers must hold
+// the lock.
+func (s *InMemoryStorage) checkImages(listing *pb.Listing) error {
+	for _, id := range listingImageIDs(listing) {
+		image, exists := s.images[id]
+		if !exists {
+			return &ImageUnavailableError{ID: id, Reason: "Unknown image"}
+		}
+		if image.OwnerId != listing.UserId {
+			return &ImageUnavailableError{ID: id, Reason: "Image was uploaded by another user"}
+		}
+		if image.ListingId != 0 && image.ListingId != listing.Id {
+			return &ImageUnavailableError{ID: id, Reason: "Image is already used by another listing"}
+		}
+	}
+	return nil
+}
+
+// claimImages records a listing as the only user of its images and those of
+// its variations, which checkImages has accepted. Callers must hold the write
+// lock.
+func (s *InMemoryStorage) claimImages(listing *pb.Listing) {
+	for _, id := range listingImageIDs(listing) {
+		if image := s.images[id]; image.ListingId != listing.Id {
+			claimed := proto.Clone(image).(*pb.ListingImage)
+			claimed.ListingId = listing.Id
+			s.images[id] = claimed
+		}
+	}
+}
+
+// listingImageIDs returns the ids of the images of a listing and its
+// variations.
+func listingImageIDs(listing *pb.Listing) []string {
+	ids := append([]string(nil), listing.Images...)
+	for _, variation := range listing.Variations {
+		ids = append(ids, variation.Images...)
+	}
+	return ids
+}
+
+// refreshListingImages updates the image details of every listing showing
+// the image. Callers must hold the write lock.
+func (s *InMemoryStorage) refreshListingImages(id string) {
+	for listingID, listing := range s.listings {
+		if !listingShowsImage(listing, id) {
+			continue
+		}
+		updated := proto.Clone(listing).(*pb.Listing)
+		s.attachImages(updated)
+		s.listings[listingID] = updated
+	}
+}
+
+// attachImages fills in the image details of a listing and its variations
+// from the image records. Callers must hold the lock.
+func (s *InMemoryStorage) attachImages(listing *pb.Listing) {
+	listing.ImageDetails = s.imageDetails(listing.Images)
+	for _, variation := range listing.Variations {
+		variation.ImageDetails = s.imageDetails(variation.Images)
+	}
+}
+
+// imageDetails returns the record of each image, in order. Images without a
+// record get a placeholder so details stay in step with the ids.
+func (s *InMemoryStorage) imageDetails(ids []string) []*pb.ListingImage {
+	var details []*pb.ListingImage
+	for _, id := range ids {
+		image, exists := s.images[id]
+		if !exists {
+			image = &pb.ListingImage{Id: id, Status: pb.ImageStatus_IMAGE_STATUS_MISSING}
+		}
+		details = append(details, image)
+	}
+	return details
+}
+
+func listingShowsImage(listing *pb.Listing, id string) bool {
+	for _, image := range listing.Images {
+		if image == id {
+			return true
+		}
+	}
+	for _, variation := range listing.Variations {
+		for _, image := range variation.Images {
+			if image == id {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,173 @@
+package storage
+
+import (
+	"time"
+
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/timestamppb"
+
+	pb "ebayclone-grpc/proto"
+)
+
+// listingTransitions lists the statuses each listing status may move to.
+// Sold listings return to active when stock comes back.
+var listingTransitions = map[pb.ListingStatus][]pb.ListingStatus{
+	pb.ListingStatus_LISTING_STATUS_DRAFT:     {pb.ListingStatus_LISTING_STATUS_ACTIVE, pb.ListingStatus_LISTING_STATUS_SCHEDULED},
+	pb.ListingStatus_LISTING_STATUS_SCHEDULED: {pb.ListingStatus_LISTING_STATUS_ACTIVE, pb.ListingStatus_LISTING_STATUS_ENDED},
+	pb.ListingStatus_LISTING_STATUS_ACTIVE:    {pb.ListingStatus_LISTING_STATUS_PAUSED, pb.ListingStatus_LISTING_STATUS_SOLD, pb.ListingStatus_LISTING_STATUS_ENDED},
+	pb.ListingStatus_LISTING_STATUS_PAUSED:    {pb.ListingStatus_LISTING_STATUS_ACTIVE, pb.ListingStatus_LISTING_STATUS_ENDED},
+	pb.ListingStatus_LISTING_STATUS_SOLD:      {pb.ListingStatus_LISTING_STATUS_ACTIVE, pb.ListingStatus_LISTING_STATUS_ENDED},
+}
+
+// CanTransitionListing reports whether a listing may move from one status to
+// another.
+func CanTransitionListing(from, to pb.ListingStatus) bool {
+	for _, allowed := range listingTransitions[from] {
+		if allowed == to {
+			return true
+		}
+	}
+	return false
+}
+
+type InvalidTransitionError struct {
+	From string
+	To   string
+}
+
+func (e *InvalidTransitionError) Error() string {
+	return "Cannot change status from " + e.From + " to " + e.To
+}
+
+// ListingNotActiveError is returned when ordering from a listing that is not
+// active.
+type ListingNotActiveError struct {
+	ID     int32
+	Status pb.ListingStatus
+}
+
+func (e *ListingNotActiveError) Error() string {
+	return "Listing is not active (status " + e.Status.String() + ")"
+}
+
+// TransitionListing moves a listing to a new status if the transition is
+// allowed. Activating a listing without stock fails with OutOfStockError.
+// Auctions cannot be paused, and ending one early sells to nobody.
+func (s *InMemoryStorage) TransitionListing(id int32, to pb.ListingStatus) (*pb.Listing, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	listing, exists := s.listings[id]
+	if !exists {
+		return nil, &NotFoundError{Resource: "Listing", ID: id}
+	}
+	if !CanTransitionListing(listing.Status, to) ||
+		(listing.Auction != nil && to == pb.ListingStatus_LISTING_STATUS_PAUSED) {
+		return nil, &InvalidTransitionError{From: listing.Status.String(), To: to.String()}
+	}
+	if to == pb.ListingStatus_LISTING_STATUS_ACTIVE && listing.Quantity == 0 {
+		return nil, &OutOfStockError{ListingID: id}
+	}
+
+	updated := s.setListingStatus(listing, to, time.Now())
+	return updated, nil
+}
+
+// StartScheduledListing publishes a scheduled listing whose start time has
+// passed at now. It returns nil if the listing is not due.
+func (s *InMemoryStorage) StartScheduledListing(id int32, now time.Time) (*pb.Listing, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	listing, exists := s.listings[id]
+	if !exists {
+		return nil, &NotFoundError{Resource: "Listing", ID: id}
+	}
+	if listing.Status != pb.ListingStatus_LISTING_STATUS_SCHEDULED || listing.ScheduledStart == nil ||
+		now.Before(listing.ScheduledStart.AsTime()) {
+		return nil, nil
+	}
+
+	to := pb.ListingStatus_LISTING_STATUS_ACTIVE
+	if listing.Quantity == 0 {
+		to = pb.ListingStatus_LISTING_STATUS_ENDED
+	}
+	return s.setListingStatus(listing, to, now), nil
+}
+
+// RescheduleListing sets when a draft or scheduled listing goes live.
+func (s *InMemoryStorage) RescheduleListing(id int32, start time.Time) (*pb.Listing, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	listing, exists := s.listings[id]
+	if !exists {
+		return nil, &NotFoundError{Resource: "Listing", ID: id}
+	}
+	if listing.Status != pb.ListingStatus_LISTING_STATUS_SCHEDULED &&
+		!CanTransitionListing(listing.Status, pb.ListingStatus_LISTING_STATUS_SCHEDULED) {
+		return nil, &InvalidTransitionError{From: listing.Status.String(), To: pb.ListingStatus_LISTING_STATUS_SCHEDULED.String()}
+	}
+
+	// Like scheduled listings, a draft keeps only the length of its run, which
+	// starts when it goes live
+	updated := proto.Clone(listing).(*pb.Listing)
+	updated.Status = pb.ListingStatus_LISTING_STATUS_SCHEDULED
+	updated.ScheduledStart = timestamppb.New(start)
+	updated.EndsAt = nil
+	updated.UpdatedAt = timestamppb.New(time.Now())
+	updated.Version++
+	s.listings[id] = updated
+	return updated, nil
+}
+
+// setListingStatus stores a copy of listing with the new status. Callers
+// must hold the write lock and have validated the transition.
+func (s *InMemoryStorage) setListingStatus(listing *pb.Listing, to pb.ListingStatus, now time.Time) *pb.Listing {
+	updated := proto.Clone(listing).(*pb.Listing)
+	updated.Status = to
+	updated.UpdatedAt = timestamppb.New(now)
+	updated.Version++
+
+	// Drafts and scheduled listings with a duration start their run when
+	// they go live
+	pending := listing.Status == pb.ListingStatus_LISTING_STATUS_DRAFT || listing.Status == pb.ListingStatus_LISTING_STATUS_SCHEDULED
+	if pending && to == pb.ListingStatus_LISTING_STATUS_ACTIVE && updated.Duration != nil && updated.EndsAt == nil {
+		updated.EndsAt = timestamppb.New(now.Add(updated.Duration.AsDuration()))
+	}
+
+	s.listings[listing.Id] = updated
+	s.publishChange(listing, updated, nil, now)
+	return updated
+}
+
+// ExpireListing ends a listing whose run is over at now, or starts a new run
+// if the seller enabled auto-relist and the listing is still active. It
+// returns nil if the listing has not expired. Auctions are closed by
+// CloseAuction instead.
+func (s *InMemoryStorage) ExpireListing(id int32, now time.Time) (*pb.Listing, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	listing, exists := s.listings[id]
+	if !exists {
+		return nil, &NotFoundError{Resource: "Listing", ID: id}
+	}
+	if listing.Auction != nil || listing.EndsAt == nil || now.Before(listing.EndsAt.AsTime()) ||
+		!CanTransitionListing(listing.Status, pb.ListingStatus_LISTING_STATUS_ENDED) {
+		return nil, nil
+	}
+
+	updated := proto.Clone(listing).(*pb.Listing)
+	updated.UpdatedAt = timestamppb.New(now)
+	updated.Version++
+	if listing.AutoRelist && listing.Duration.AsDuration() > 0 && listing.Status == pb.ListingStatus_LISTING_STATUS_ACTIVE {
+		updated.EndsAt = timestamppb.New(now.Add(listing.Duration.AsDuration()))
+		updated.RelistCount++
+	} else {
+		updated.Status = pb.ListingStatus_LISTING_STATUS_ENDED
+	}
+
+	s.listings[id] = updated
+	return updated, nil
+}
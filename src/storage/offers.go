@@ -0,0 +1,458 @@
+package storage
+
+import (
+	"sort"
+	"time"
+
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/timestamppb"
+
+	pb "ebayclone-grpc/proto"
+	"ebayclone-grpc/src/money"
+)
+
+// OffersNotAcceptedError is returned for offers on a listing without Best
+// Offer, which includes every auction.
+type OffersNotAcceptedError struct {
+	ListingID int32
+}
+
+func (e *OffersNotAcceptedError) Error() string {
+	return "Listing does not accept offers"
+}
+
+// OfferClosedError is returned when acting on an offer that was already
+// accepted, declined, withdrawn or expired.
+type OfferClosedError struct {
+	ID     int32
+	Status pb.OfferStatus
+}
+
+func (e *OfferClosedError) Error() string {
+	return "Offer is already closed (" + e.Status.String() + ")"
+}
+
+// OfferExpiredError is returned when acting on an open offer past its
+// expiry, even if it has not been expired yet.
+type OfferExpiredError struct {
+	ID int32
+}
+
+func (e *OfferExpiredError) Error() string {
+	return "Offer has expired"
+}
+
+// OfferPermissionError is returned when a user may not take an action on an
+// offer: they are not its buyer or seller, or it is not their turn.
+type OfferPermissionError struct {
+	ID     int32
+	Reason string
+}
+
+func (e *OfferPermissionError) Error() string {
+	return e.Reason
+}
+
+// CounterAmountError is returned when a seller counters an offer with an
+// amount that is not above the buyer's or is above the listing's price.
+type CounterAmountError struct {
+	ID     int32
+	Reason string
+}
+
+func (e *CounterAmountError) Error() string {
+	return e.Reason
+}
+
+// OfferFilter narrows down GetOffers results. Zero values disable a filter.
+type OfferFilter struct {
+	// UserID matches offers the user made or received.
+	UserID    int32
+	ListingID int32
+	Statuses  []pb.OfferStatus
+
+	// ExpiresBefore matches offers that expire at or before this time.
+	ExpiresBefore time.Time
+}
+
+// SetBestOffer makes a fixed-price listing accept offers under terms, or
+// stop accepting them if terms is nil. Terms are kept apart from the
+// listing, which only says whether it accepts offers, so buyers cannot see
+// the thresholds.
+func (s *InMemoryStorage) SetBestOffer(listingID int32, terms *pb.BestOffer) (*pb.Listing, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	listing, exists := s.listings[listingID]
+	if !exists {
+		return nil, &NotFoundError{Resource: "Listing", ID: listingID}
+	}
+	if listing.Auction != nil {
+		return nil, &OffersNotAcceptedError{ListingID: listingID}
+	}
+
+	s.setOfferTerms(listingID, terms)
+	updated := proto.Clone(listing).(*pb.Listing)
+	updated.AcceptsOffers = terms != nil
+	updated.UpdatedAt = timestamppb.New(time.Now())
+	updated.Version++
+	s.listings[listingID] = updated
+	return updated, nil
+}
+
+// UpdateListingBestOffer is UpdateListing that also sets the listing's Best
+// Offer terms, or clears them if terms is nil, in the same transaction.
+func (s *InMemoryStorage) UpdateListingBestOffer(id int32, listing *pb.Listing, userID int32, terms *pb.BestOffer) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.updateListing(id, listing, userID, func(existing *pb.Listing) error {
+		if existing.Auction != nil {
+			return &OffersNotAcceptedError{ListingID: id}
+		}
+		s.setOfferTerms(id, terms)
+		listing.AcceptsOffers = terms != nil
+		return nil
+	})
+}
+
+// setOfferTerms keeps a listing's Best Offer terms, or forgets them if terms
+// is nil. Callers must hold the write lock.
+func (s *InMemoryStorage) setOfferTerms(listingID int32, terms *pb.BestOffer) {
+	if terms == nil {
+		delete(s.offerTerms, listingID)
+	} else {
+		s.offerTerms[listingID] = terms
+	}
+}
+
+// CreateOffer records a buyer's offer on a listing that accepts offers,
+// then applies the seller's terms to it: offers below the auto-decline
+// price are declined, and those at or above the auto-accept price are
+// accepted, returning the order created in the same transaction.
+func (s *InMemoryStorage) CreateOffer(offer *pb.Offer, now time.Time) (*pb.Order, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	listing, exists := s.listings[offer.ListingId]
+	if !exists {
+		return nil, &NotFoundError{Resource: "Listing", ID: offer.ListingId}
+	}
+	if listing.UserId == offer.BuyerId {
+		return nil, &OfferPermissionError{Reason: "Sellers cannot make offers on their own listings"}
+	}
+	if _, err := s.checkOfferable(offer); err != nil {
+		return nil, err
+	}
+
+	offer.Id = s.offerID
+	offer.SellerId = listing.UserId
+	offer.Status = pb.OfferStatus_OFFER_STATUS_PENDING
+	offer.CreatedAt = timestamppb.New(now)
+	offer.UpdatedAt = timestamppb.New(now)
+	offer.Version = 1
+	s.offerID++
+
+	order, err := s.autoRespond(offer, now)
+	if err != nil {
+		return nil, err
+	}
+	s.offers[offer.Id] = offer
+	return order, nil
+}
+
+func (s *InMemoryStorage) GetOffer(id int32) (*pb.Offer, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	offer, exists := s.offers[id]
+	if !exists {
+		return nil, &NotFoundError{Resource: "Offer", ID: id}
+	}
+	return offer, nil
+}
+
+// GetOffers returns the offers matching filter, newest first.
+func (s *InMemoryStorage) GetOffers(filter OfferFilter) ([]*pb.Offer, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var offers []*pb.Offer
+	for _, offer := range s.offers {
+		if filter.UserID > 0 && offer.BuyerId != filter.UserID && offer.SellerId != filter.UserID {
+			continue
+		}
+		if filter.ListingID > 0 && offer.ListingId != filter.ListingID {
+			continue
+		}
+		if len(filter.Statuses) > 0 && !hasOfferStatus(filter.Statuses, offer.Status) {
+			continue
+		}
+		if !filter.ExpiresBefore.IsZero() && (offer.ExpiresAt == nil || offer.ExpiresAt.AsTime().After(filter.ExpiresBefore)) {
+			continue
+		}
+		offers = append(offers, offer)
+	}
+	sort.Slice(offers, func(i, j int) bool { return offers[i].Id > offers[j].Id })
+	return offers, nil
+}
+
+// CounterOffer replaces the amount of an open offer on behalf of whoever it
+// is waiting for, handing it to the other party until expiresAt. Sellers
+// must counter between the buyer's amount and the listing's price. Buyers'
+// counters are subject to the seller's terms like new offers, so they may
+// be declined or accepted at once.
+func (s *InMemoryStorage) CounterOffer(id int32, userID int32, amount *pb.Money, message string, expiresAt time.Time, now time.Time) (*pb.Offer, *pb.Order, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	offer, err := s.offerAwaiting(id, userID, now)
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := money.SameCurrency(offer.Amount, amount); err != nil {
+		return nil, nil, err
+	}
+
+	updated := proto.Clone(offer).(*pb.Offer)
+	updated.Amount = amount
+	updated.Message = message
+	updated.ExpiresAt = timestamppb.New(expiresAt)
+	updated.CounterCount++
+	updated.UpdatedAt = timestamppb.New(now)
+	updated.Version++
+
+	var order *pb.Order
+	if userID == offer.SellerId {
+		if err := s.checkCounter(offer, amount); err != nil {
+			return nil, nil, err
+		}
+		updated.Status = pb.OfferStatus_OFFER_STATUS_COUNTERED
+	} else {
+		if _, err := s.checkOfferable(updated); err != nil {
+			return nil, nil, err
+		}
+		updated.Status = pb.OfferStatus_OFFER_STATUS_PENDING
+		if order, err = s.autoRespond(updated, now); err != nil {
+			return nil, nil, err
+		}
+	}
+	s.offers[id] = updated
+	return updated, order, nil
+}
+
+// AcceptOffer accepts an open offer on behalf of whoever it is waiting for
+// and orders its items at the offered amount in the same transaction.
+func (s *InMemoryStorage) AcceptOffer(id int32, userID int32, now time.Time) (*pb.Offer, *pb.Order, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	offer, err := s.offerAwaiting(id, userID, now)
+	if err != nil {
+		return nil, nil, err
+	}
+	updated := proto.Clone(offer).(*pb.Offer)
+	updated.Version++
+	order, err := s.acceptOffer(updated, now)
+	if err != nil {
+		return nil, nil, err
+	}
+	s.offers[id] = updated
+	return updated, order, nil
+}
+
+// DeclineOffer declines an open offer on behalf of whoever it is waiting
+// for.
+func (s *InMemoryStorage) DeclineOffer(id int32, userID int32, now time.Time) (*pb.Offer, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	offer, err := s.offerAwaiting(id, userID, now)
+	if err != nil {
+		return nil, err
+	}
+	return s.closeOffer(offer, pb.OfferStatus_OFFER_STATUS_DECLINED, now), nil
+}
+
+// WithdrawOffer lets the buyer take back an open offer, whoever it is
+// waiting for.
+func (s *InMemoryStorage) WithdrawOffer(id int32, userID int32, now time.Time) (*pb.Offer, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	offer, err := s.openOffer(id, userID, now)
+	if err != nil {
+		return nil, err
+	}
+	if userID != offer.BuyerId {
+		return nil, &OfferPermissionError{ID: id, Reason: "Only the buyer can withdraw an offer"}
+	}
+	return s.closeOffer(offer, pb.OfferStatus_OFFER_STATUS_WITHDRAWN, now), nil
+}
+
+// ExpireOffer expires an open offer that was not answered by now. It
+// returns nil if the offer is closed or not due.
+func (s *InMemoryStorage) ExpireOffer(id int32, now time.Time) (*pb.Offer, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	offer, exists := s.offers[id]
+	if !exists {
+		return nil, &NotFoundError{Resource: "Offer", ID: id}
+	}
+	if !offerOpen(offer) || offer.ExpiresAt == nil || now.Before(offer.ExpiresAt.AsTime()) {
+		return nil, nil
+	}
+	return s.closeOffer(offer, pb.OfferStatus_OFFER_STATUS_EXPIRED, now), nil
+}
+
+// openOffer returns an open, unexpired offer that userID is a party to.
+// Callers must hold the lock.
+func (s *InMemoryStorage) openOffer(id int32, userID int32, now time.Time) (*pb.Offer, error) {
+	offer, exists := s.offers[id]
+	if !exists {
+		return nil, &NotFoundError{Resource: "Offer", ID: id}
+	}
+	if userID != offer.BuyerId && userID != offer.SellerId {
+		return nil, &OfferPermissionError{ID: id, Reason: "Only the buyer and seller can act on an offer"}
+	}
+	if !offerOpen(offer) {
+		return nil, &OfferClosedError{ID: id, Status: offer.Status}
+	}
+	if offer.ExpiresAt != nil && !now.Before(offer.ExpiresAt.AsTime()) {
+		return nil, &OfferExpiredError{ID: id}
+	}
+	return offer, nil
+}
+
+// offerAwaiting is openOffer for the party the offer is waiting for: the
+// seller while it is pending, the buyer once countered.
+func (s *InMemoryStorage) offerAwaiting(id int32, userID int32, now time.Time) (*pb.Offer, error) {
+	offer, err := s.openOffer(id, userID, now)
+	if err != nil {
+		return nil, err
+	}
+	awaiting := offer.SellerId
+	if offer.Status == pb.OfferStatus_OFFER_STATUS_COUNTERED {
+		awaiting = offer.BuyerId
+	}
+	if userID != awaiting {
+		return nil, &OfferPermissionError{ID: id, Reason: "Offer is waiting for the other party"}
+	}
+	return offer, nil
+}
+
+// checkOfferable checks that the listing of an offer still accepts offers
+// and can supply its items, in the listing's currency, and returns their
+// current unit price. Callers must hold the lock.
+func (s *InMemoryStorage) checkOfferable(offer *pb.Offer) (*pb.Money, error) {
+	listing, exists := s.listings[offer.ListingId]
+	if !exists {
+		return nil, &NotFoundError{Resource: "Listing", ID: offer.ListingId}
+	}
+	if listing.Status == pb.ListingStatus_LISTING_STATUS_ACTIVE && !listing.AcceptsOffers {
+		return nil, &OffersNotAcceptedError{ListingID: listing.Id}
+	}
+	price, err := s.checkStock(offer.ListingId, offer.VariationId, offer.Quantity)
+	if err != nil {
+		return nil, err
+	}
+	if err := money.SameCurrency(price, offer.Amount); err != nil {
+		return nil, err
+	}
+	return price, nil
+}
+
+// checkCounter checks that the listing of an offer can still supply it and
+// that a seller's counter amount lies above the buyer's amount and no higher
+// than the listing's price. Callers must hold the lock.
+func (s *InMemoryStorage) checkCounter(offer *pb.Offer, amount *pb.Money) error {
+	price, err := s.checkOfferable(offer)
+	if err != nil {
+		return err
+	}
+	c, err := money.Compare(amount, offer.Amount)
+	if err != nil {
+		return err
+	}
+	if c <= 0 {
+		return &CounterAmountError{ID: offer.Id, Reason: "Counter must be above the buyer's offer"}
+	}
+	if c, err = money.Compare(amount, price); err != nil {
+		return err
+	}
+	if c > 0 {
+		return &CounterAmountError{ID: offer.Id, Reason: "Counter must not be above the listing's price"}
+	}
+	return nil
+}
+
+// autoRespond applies the seller's Best Offer terms to an offer whose
+// amount the buyer just set, updating the caller's copy in place. Callers
+// must hold the write lock.
+func (s *InMemoryStorage) autoRespond(offer *pb.Offer, now time.Time) (*pb.Order, error) {
+	terms := s.offerTerms[offer.ListingId]
+	if terms == nil {
+		return nil, nil
+	}
+	if terms.AutoDeclinePrice != nil {
+		if c, err := money.Compare(offer.Amount, terms.AutoDeclinePrice); err == nil && c < 0 {
+			offer.Status = pb.OfferStatus_OFFER_STATUS_DECLINED
+			return nil, nil
+		}
+	}
+	if terms.AutoAcceptPrice != nil {
+		if c, err := money.Compare(offer.Amount, terms.AutoAcceptPrice); err == nil && c >= 0 {
+			return s.acceptOffer(offer, now)
+		}
+	}
+	return nil, nil
+}
+
+// acceptOffer places the order for an offer and marks the caller's copy
+// accepted. Callers must hold the write lock.
+func (s *InMemoryStorage) acceptOffer(offer *pb.Offer, now time.Time) (*pb.Order, error) {
+	order := &pb.Order{
+		UserId: offer.BuyerId,
+		Items: []*pb.OrderItem{{
+			ListingId:   offer.ListingId,
+			VariationId: offer.VariationId,
+			Quantity:    offer.Quantity,
+			UnitPrice:   offer.Amount,
+		}},
+		ShippingAddress: offer.ShippingAddress,
+		OfferId:         offer.Id,
+	}
+	if err := s.placeOrder(order, now); err != nil {
+		return nil, err
+	}
+
+	offer.Status = pb.OfferStatus_OFFER_STATUS_ACCEPTED
+	offer.OrderId = order.Id
+	offer.UpdatedAt = timestamppb.New(now)
+	return order, nil
+}
+
+// closeOffer stores a copy of an open offer in a closed status. Callers
+// must hold the write lock.
+func (s *InMemoryStorage) closeOffer(offer *pb.Offer, status pb.OfferStatus, now time.Time) *pb.Offer {
+	closed := proto.Clone(offer).(*pb.Offer)
+	closed.Status = status
+	closed.UpdatedAt = timestamppb.New(now)
+	closed.Version++
+	s.offers[offer.Id] = closed
+	return closed
+}
+
+func offerOpen(offer *pb.Offer) bool {
+	return offer.Status == pb.OfferStatus_OFFER_STATUS_PENDING || offer.Status == pb.OfferStatus_OFFER_STATUS_COUNTERED
+}
+
+func hasOfferStatus(statuses []pb.OfferStatus, status pb.OfferStatus) bool {
+	for _, s := range statuses {
+		if s == status {
+			return true
+		}
+	}
+	return false
+}
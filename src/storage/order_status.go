@@ -0,0 +1,146 @@
+package storage
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/timestamppb"
+
+	pb "ebayclone-grpc/proto"
+)
+
+// orderTransitions lists the statuses each order status may move to, and
+// who may make each move. Delivered and cancelled orders are final.
+var orderTransitions = map[pb.OrderStatus]map[pb.OrderStatus][]pb.OrderActor{
+	pb.OrderStatus_ORDER_STATUS_PENDING: {
+		pb.OrderStatus_ORDER_STATUS_CONFIRMED: {pb.OrderActor_ORDER_ACTOR_SELLER},
+		pb.OrderStatus_ORDER_STATUS_CANCELLED: {pb.OrderActor_ORDER_ACTOR_BUYER, pb.OrderActor_ORDER_ACTOR_SELLER, pb.OrderActor_ORDER_ACTOR_SYSTEM},
+	},
+	pb.OrderStatus_ORDER_STATUS_CONFIRMED: {
+		pb.OrderStatus_ORDER_STATUS_SHIPPED:   {pb.OrderActor_ORDER_ACTOR_SELLER},
+		pb.OrderStatus_ORDER_STATUS_CANCELLED: {pb.OrderActor_ORDER_ACTOR_SELLER, pb.OrderActor_ORDER_ACTOR_SYSTEM},
+	},
+	pb.OrderStatus_ORDER_STATUS_SHIPPED: {
+		pb.OrderStatus_ORDER_STATUS_DELIVERED: {pb.OrderActor_ORDER_ACTOR_BUYER, pb.OrderActor_ORDER_ACTOR_SYSTEM},
+	},
+}
+
+// CanTransitionOrder reports whether an order may move from one status to
+// another.
+func CanTransitionOrder(from, to pb.OrderStatus) bool {
+	_, ok := orderTransitions[from][to]
+	return ok
+}
+
+// MayTransitionOrder reports whether actor may move an order from one status
+// to another.
+func MayTransitionOrder(from, to pb.OrderStatus, actor pb.OrderActor) bool {
+	for _, allowed := range orderTransitions[from][to] {
+		if allowed == actor {
+			return true
+		}
+	}
+	return false
+}
+
+// OrderPermissionError is returned when a user may not change the status of
+// an order: they are not its buyer or seller, or the move is not theirs to
+// make.
+type OrderPermissionError struct {
+	ID     int32
+	Reason string
+}
+
+func (e *OrderPermissionError) Error() string {
+	return e.Reason
+}
+
+// OrderShippedError is returned when deleting an order that has shipped, or
+// changing its items. Its items have left the seller for good, so they
+// cannot go back to stock.
+type OrderShippedError struct {
+	ID     int32
+	Status pb.OrderStatus
+}
+
+func (e *OrderShippedError) Error() string {
+	return "Order has already shipped (" + e.Status.String() + ")"
+}
+
+// TransitionOrder moves an order to a new status if the transition is
+// allowed for actor, and records the change in the order's history. Buyers
+// and sellers act as userID, which must be the order's buyer or seller.
+// Cancelling returns the order's items to stock in the same transaction and
+// keeps reason.
+func (s *InMemoryStorage) TransitionOrder(id int32, to pb.OrderStatus, actor pb.OrderActor, userID int32, reason string, now time.Time) (*pb.Order, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	order, exists := s.orders[id]
+	if !exists {
+		return nil, &NotFoundError{Resource: "Order", ID: id}
+	}
+	if !CanTransitionOrder(order.Status, to) {
+		return nil, &InvalidTransitionError{From: order.Status.String(), To: to.String()}
+	}
+
+	switch actor {
+	case pb.OrderActor_ORDER_ACTOR_BUYER:
+		if order.UserId != userID {
+			return nil, &OrderPermissionError{ID: id, Reason: "Only the buyer and seller can change an order"}
+		}
+	case pb.OrderActor_ORDER_ACTOR_SELLER:
+		if order.SellerId != userID {
+			return nil, &OrderPermissionError{ID: id, Reason: "Only the buyer and seller can change an order"}
+		}
+	case pb.OrderActor_ORDER_ACTOR_SYSTEM:
+		userID = 0
+	}
+	if !MayTransitionOrder(order.Status, to, actor) {
+		return nil, &OrderPermissionError{
+			ID:     id,
+			Reason: fmt.Sprintf("The %s cannot move an order from %s to %s", actorName(actor), order.Status, to),
+		}
+	}
+
+	updated := proto.Clone(order).(*pb.Order)
+	updated.Status = to
+	updated.StatusHistory = append(updated.StatusHistory, &pb.OrderStatusChange{
+		Status:    to,
+		Actor:     actor,
+		UserId:    userID,
+		ChangedAt: timestamppb.New(now),
+	})
+	updated.UpdatedAt = timestamppb.New(now)
+	updated.Version++
+
+	if to == pb.OrderStatus_ORDER_STATUS_CANCELLED {
+		s.releaseItems(order.Items)
+		updated.CancelReason = reason
+		updated.CancelledAt = timestamppb.New(now)
+	}
+
+	s.orders[id] = updated
+	return updated, nil
+}
+
+// startHistory marks a new order pending and records it being placed by
+// actor, as userID unless the system placed it.
+func startHistory(order *pb.Order, actor pb.OrderActor, userID int32, now time.Time) {
+	if actor == pb.OrderActor_ORDER_ACTOR_SYSTEM {
+		userID = 0
+	}
+	order.Status = pb.OrderStatus_ORDER_STATUS_PENDING
+	order.StatusHistory = []*pb.OrderStatusChange{{
+		Status:    pb.OrderStatus_ORDER_STATUS_PENDING,
+		Actor:     actor,
+		UserId:    userID,
+		ChangedAt: timestamppb.New(now),
+	}}
+}
+
+func actorName(actor pb.OrderActor) string {
+	return strings.ToLower(strings.TrimPrefix(actor.String(), "ORDER_ACTOR_"))
+}
@@ -0,0 +1,155 @@
+package storage
+
+import (
+	"fmt"
+
+	pb "ebayclone-grpc/proto"
+	"ebayclone-grpc/src/money"
+)
+
+// MixedSellersError is returned when the items of an order are sold by
+// different sellers.
+type MixedSellersError struct {
+	ListingID int32
+}
+
+func (e *MixedSellersError) Error() string {
+	return fmt.Sprintf("Listing %d is sold by another seller; an order holds items from one seller", e.ListingID)
+}
+
+// OrderFilter narrows down GetOrders results. Zero values disable a filter.
+type OrderFilter struct {
+	UserID    int32
+	ListingID int32 // Orders with an item from this listing
+	Status    pb.OrderStatus
+}
+
+func (f OrderFilter) matches(order *pb.Order) bool {
+	if f.UserID > 0 && order.UserId != f.UserID {
+		return false
+	}
+	if f.Status != pb.OrderStatus_ORDER_STATUS_UNSPECIFIED && order.Status != f.Status {
+		return false
+	}
+	if f.ListingID > 0 {
+		for _, item := range order.Items {
+			if item.ListingId == f.ListingID {
+				return true
+			}
+		}
+		return false
+	}
+	return true
+}
+
+// TotalOrder sets the subtotal of every item of an order from its unit price
+// and quantity, and the order's total price from the subtotals, shipping
+// cost and tax, which must all be in one currency.
+func TotalOrder(order *pb.Order) error {
+	var total *pb.Money
+	add := func(amount *pb.Money) error {
+		if amount == nil {
+			return nil
+		}
+		if total == nil {
+			total = amount
+			return nil
+		}
+		sum, err := money.Add(total, amount)
+		if err != nil {
+			return err
+		}
+		total = sum
+		return nil
+	}
+
+	for _, item := range order.Items {
+		subtotal, err := money.Mul(item.UnitPrice, int64(item.Quantity))
+		if err != nil {
+			return err
+		}
+		item.Subtotal = subtotal
+		if err := add(subtotal); err != nil {
+			return err
+		}
+	}
+	if err := add(order.ShippingCost); err != nil {
+		return err
+	}
+	if err := add(order.Tax); err != nil {
+		return err
+	}
+	order.TotalPrice = total
+	return nil
+}
+
+// orderSeller returns the seller of every item of an order. Callers must
+// hold the lock.
+func (s *InMemoryStorage) orderSeller(items []*pb.OrderItem) (int32, error) {
+	var sellerID int32
+	for i, item := range items {
+		listing, exists := s.listings[item.ListingId]
+		if !exists {
+			return 0, &NotFoundError{Resource: "Listing", ID: item.ListingId}
+		}
+		if i > 0 && listing.UserId != sellerID {
+			return 0, &MixedSellersError{ListingID: listing.Id}
+		}
+		sellerID = listing.UserId
+	}
+	return sellerID, nil
+}
+
+// reserveItems takes the stock of every item of an order, or of none if one
+// is not available. Items without a unit price or listing revision are
+// given the listing's current ones. Callers must hold the write lock.
+func (s *InMemoryStorage) reserveItems(items []*pb.OrderItem) error {
+	for i, item := range items {
+		price, err := s.checkStock(item.ListingId, item.VariationId, item.Quantity)
+		if err != nil {
+			s.releaseItems(items[:i])
+			return err
+		}
+		listing := s.adjustStock(s.listings[item.ListingId], item.VariationId, -item.Quantity)
+		if item.UnitPrice == nil {
+			item.UnitPrice = price
+		}
+		if item.ListingRevision == 0 {
+			item.ListingRevision = listing.Revision
+		}
+	}
+	return nil
+}
+
+// releaseItems returns the stock of every item of an order. Callers must
+// hold the write lock.
+func (s *InMemoryStorage) releaseItems(items []*pb.OrderItem) {
+	for _, item := range items {
+		s.releaseStock(item.ListingId, item.VariationId, item.Quantity)
+	}
+}
+
+// restoreItems takes back the stock of items just released by
+// releaseItems, without checking it is still available. Callers must hold
+// the write lock.
+func (s *InMemoryStorage) restoreItems(items []*pb.OrderItem) {
+	for _, item := range items {
+		if listing, exists := s.listings[item.ListingId]; exists {
+			s.adjustStock(listing, item.VariationId, -item.Quantity)
+		}
+	}
+}
+
+// sameItems reports whether two orders hold the same quantities of the same
+// listings and variations, in the same order.
+func sameItems(a, b []*pb.OrderItem) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i].ListingId != b[i].ListingId || a[i].VariationId != b[i].VariationId || a[i].Quantity != b[i].Quantity {
+			return false
+		}
+	}
+	return true
+}
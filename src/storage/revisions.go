@@ -0,0 +1,95 @@
+package storage
+
+import (
+	"time"
+
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/timestamppb"
+
+	pb "ebayclone-grpc/proto"
+)
+
+// GetListingRevisions returns the recorded revisions of a listing, oldest
+// first. History outlives the listing, so orders placed against a deleted
+// listing can still be traced back to what it said.
+func (s *InMemoryStorage) GetListingRevisions(id int32) ([]*pb.ListingRevision, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	revisions, exists := s.revisions[id]
+	if !exists {
+		return nil, &NotFoundError{Resource: "Listing", ID: id}
+	}
+	return append([]*pb.ListingRevision(nil), revisions...), nil
+}
+
+// recordRevision appends a revision with a snapshot of listing if it differs
+// from before in any field the seller describes the item with. before is nil
+// for a new listing. Callers must hold the write lock and store listing after.
+func (s *InMemoryStorage) recordRevision(before, listing *pb.Listing, userID int32, now time.Time) {
+	var changed []string
+	if before != nil {
+		changed = changedFields(before, listing)
+		if len(changed) == 0 {
+			listing.Revision = before.Revision
+			return
+		}
+	}
+
+	listing.Revision = int32(len(s.revisions[listing.Id]) + 1)
+	s.revisions[listing.Id] = append(s.revisions[listing.Id], &pb.ListingRevision{
+		ListingId:     listing.Id,
+		Revision:      listing.Revision,
+		UserId:        userID,
+		CreatedAt:     timestamppb.New(now),
+		ChangedFields: changed,
+		Listing:       proto.Clone(listing).(*pb.Listing),
+	})
+}
+
+// changedFields lists the descriptive fields that differ between two copies
+// of a listing. Stock, status and timestamps are not part of the history.
+func changedFields(before, after *pb.Listing) []string {
+	var changed []string
+	add := func(field string, equal bool) {
+		if !equal {
+			changed = append(changed, field)
+		}
+	}
+	add("title", before.Title == after.Title)
+	add("description", before.Description == after.Description)
+	add("price", proto.Equal(before.Price, after.Price))
+	add("category", before.Category == after.Category)
+	add("condition", before.Condition == after.Condition)
+	add("location", before.Location == after.Location)
+	add("coordinates", proto.Equal(before.Coordinates, after.Coordinates))
+	add("attributes", equalAttributes(before.Attributes, after.Attributes))
+	add("images", equalStrings(before.Images, after.Images))
+	add("duration", proto.Equal(before.Duration, after.Duration))
+	add("auto_relist", before.AutoRelist == after.AutoRelist)
+	return changed
+}
+
+func equalAttributes(a, b []*pb.AttributeValue) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if !proto.Equal(a[i], b[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+func equalStrings(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
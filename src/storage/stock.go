@@ -0,0 +1,166 @@
+package storage
+
+import (
+	"fmt"
+	"time"
+
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/timestamppb"
+
+	pb "ebayclone-grpc/proto"
+)
+
+// OutOfStockError is returned when an order asks for more units than a
+// listing has available.
+type OutOfStockError struct {
+	ListingID int32
+	Available int32
+}
+
+func (e *OutOfStockError) Error() string {
+	return fmt.Sprintf("Listing %d has only %d item(s) available", e.ListingID, e.Available)
+}
+
+// VariationRequiredError is returned when stock of a listing with variations
+// is changed without saying which variation.
+type VariationRequiredError struct {
+	ListingID int32
+}
+
+func (e *VariationRequiredError) Error() string {
+	return fmt.Sprintf("Listing %d has variations; a variation must be specified", e.ListingID)
+}
+
+// OrderCancelledError is returned when changing an order that was already
+// cancelled.
+type OrderCancelledError struct {
+	ID int32
+}
+
+func (e *OrderCancelledError) Error() string {
+	return "Order is already cancelled"
+}
+
+// SetListingQuantity sets the available stock of a listing. Active listings
+// without stock become sold and sold listings that are restocked go active
+// again. Listings with variations are restocked per variation.
+func (s *InMemoryStorage) SetListingQuantity(id int32, quantity int32) (*pb.Listing, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	listing, exists := s.listings[id]
+	if !exists {
+		return nil, &NotFoundError{Resource: "Listing", ID: id}
+	}
+	if len(listing.Variations) > 0 {
+		return nil, &VariationRequiredError{ListingID: id}
+	}
+
+	updated := s.adjustStock(listing, 0, quantity-listing.Quantity)
+	updated.UpdatedAt = timestamppb.New(time.Now())
+	return updated, nil
+}
+
+// SetVariationQuantity sets the available stock of one variation. The
+// listing's quantity stays the total across its variations.
+func (s *InMemoryStorage) SetVariationQuantity(id int32, variationID int32, quantity int32) (*pb.Listing, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	listing, exists := s.listings[id]
+	if !exists {
+		return nil, &NotFoundError{Resource: "Listing", ID: id}
+	}
+	variation := FindVariation(listing, variationID)
+	if variation == nil {
+		return nil, &NotFoundError{Resource: "Variation", ID: variationID}
+	}
+
+	updated := s.adjustStock(listing, variationID, quantity-variation.Quantity)
+	updated.UpdatedAt = timestamppb.New(time.Now())
+	return updated, nil
+}
+
+// checkStock checks that quantity units of an active fixed-price listing,
+// or of one of its variations, can be reserved and returns their current
+// unit price. Callers must hold the lock.
+func (s *InMemoryStorage) checkStock(listingID int32, variationID int32, quantity int32) (*pb.Money, error) {
+	listing, exists := s.listings[listingID]
+	if !exists {
+		return nil, &NotFoundError{Resource: "Listing", ID: listingID}
+	}
+	if listing.Status != pb.ListingStatus_LISTING_STATUS_ACTIVE {
+		return nil, &ListingNotActiveError{ID: listingID, Status: listing.Status}
+	}
+	if listing.Auction != nil {
+		return nil, &AuctionListingError{ID: listingID}
+	}
+
+	price, available := listing.Price, listing.Quantity
+	if len(listing.Variations) > 0 {
+		if variationID == 0 {
+			return nil, &VariationRequiredError{ListingID: listingID}
+		}
+		variation := FindVariation(listing, variationID)
+		if variation == nil {
+			return nil, &NotFoundError{Resource: "Variation", ID: variationID}
+		}
+		price, available = variation.Price, variation.Quantity
+	}
+	if available < quantity {
+		return nil, &OutOfStockError{ListingID: listingID, Available: available}
+	}
+	return price, nil
+}
+
+// releaseStock returns quantity units to a listing or variation. Stock for
+// deleted listings is dropped, and auctions end unsold instead of going back
+// on sale. Callers must hold the write lock.
+func (s *InMemoryStorage) releaseStock(listingID int32, variationID int32, quantity int32) {
+	listing, exists := s.listings[listingID]
+	if !exists {
+		return
+	}
+	if listing.Auction != nil {
+		s.unsellAuction(listing, quantity, time.Now())
+		return
+	}
+	s.adjustStock(listing, variationID, quantity)
+}
+
+// adjustStock changes a listing's quantity, and that of the given variation,
+// by delta and moves the listing between active and sold accordingly.
+// Listings are replaced rather than modified so callers holding an earlier
+// copy never race with the write. Callers must hold the write lock.
+func (s *InMemoryStorage) adjustStock(listing *pb.Listing, variationID int32, delta int32) *pb.Listing {
+	updated := proto.Clone(listing).(*pb.Listing)
+	updated.Version++
+	updated.Quantity += delta
+	if variation := FindVariation(updated, variationID); variation != nil {
+		variation.Quantity += delta
+	}
+
+	switch {
+	case updated.Quantity == 0 && updated.Status == pb.ListingStatus_LISTING_STATUS_ACTIVE:
+		updated.Status = pb.ListingStatus_LISTING_STATUS_SOLD
+	case updated.Quantity > 0 && updated.Status == pb.ListingStatus_LISTING_STATUS_SOLD:
+		updated.Status = pb.ListingStatus_LISTING_STATUS_ACTIVE
+	}
+
+	s.listings[listing.Id] = updated
+	return updated
+}
+
+// FindVariation returns the variation of listing with id, or nil if it has
+// none. Id 0 never names a variation.
+func FindVariation(listing *pb.Listing, id int32) *pb.ListingVariation {
+	if id == 0 {
+		return nil
+	}
+	for _, variation := range listing.Variations {
+		if variation.Id == id {
+			return variation
+		}
+	}
+	return nil
+}
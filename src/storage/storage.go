@@ -1,12 +1,16 @@
 package storage
 
 import (
+	"sort"
 	"strings"
 	"sync"
 	"time"
 
+	"google.golang.org/protobuf/proto"
 	"google.golang.org/protobuf/types/known/timestamppb"
 	pb "ebayclone-grpc/proto"
+	"ebayclone-grpc/src/events"
+	"ebayclone-grpc/src/money"
 )
 
 type Storage interface {
@@ -20,38 +24,151 @@ type Storage interface {
 	// Listings
 	CreateListing(listing *pb.Listing) error
 	GetListing(id int32) (*pb.Listing, error)
-	GetListings(search string, priceMin, priceMax float64) ([]*pb.Listing, error)
-	UpdateListing(id int32, listing *pb.Listing) error
+	GetListings(filter ListingFilter) ([]*pb.Listing, error)
+	UpdateListing(id int32, listing *pb.Listing, userID int32) error
+	SetListingQuantity(id int32, quantity int32) (*pb.Listing, error)
+	SetVariationQuantity(id int32, variationID int32, quantity int32) (*pb.Listing, error)
+	UpdateListingImages(id int32, userID int32, update func(images []string) ([]string, error)) (*pb.Listing, error)
+	GetListingRevisions(id int32) ([]*pb.ListingRevision, error)
+	TransitionListing(id int32, to pb.ListingStatus) (*pb.Listing, error)
+	ExpireListing(id int32, now time.Time) (*pb.Listing, error)
+	StartScheduledListing(id int32, now time.Time) (*pb.Listing, error)
+	RescheduleListing(id int32, start time.Time) (*pb.Listing, error)
 	DeleteListing(id int32) error
 
+	// Categories
+	CreateCategory(category *pb.Category) error
+	GetCategory(id int32) (*pb.Category, error)
+	GetCategoryBySlug(slug string) (*pb.Category, error)
+	GetCategories(parentID int32, recursive bool) ([]*pb.Category, error)
+	UpdateCategory(id int32, category *pb.Category) error
+	DeleteCategory(id int32) error
+
+	// Images
+	CreateImage(image *pb.ListingImage) error
+	GetImage(id string) (*pb.ListingImage, error)
+	UpdateImage(image *pb.ListingImage) error
+	DeleteImage(id string) error
+
 	// Orders
 	CreateOrder(order *pb.Order) error
 	GetOrder(id int32) (*pb.Order, error)
-	GetOrders(userID int32, status string, page, limit int32) ([]*pb.Order, int32, error)
+	GetOrders(filter OrderFilter, page, limit int32) ([]*pb.Order, int32, error)
 	UpdateOrder(id int32, order *pb.Order) error
+	TransitionOrder(id int32, to pb.OrderStatus, actor pb.OrderActor, userID int32, reason string, now time.Time) (*pb.Order, error)
 	DeleteOrder(id int32) error
+
+	// Bids
+	PlaceBid(bid *pb.Bid, address *pb.Address, now time.Time) (*pb.Listing, error)
+	GetBids(listingID int32) ([]*pb.Bid, error)
+	CloseAuction(id int32, now time.Time) (*pb.Listing, *pb.Order, error)
+	BuyItNow(id int32, userID int32, address *pb.Address, now time.Time) (*pb.Listing, *pb.Order, error)
+	WatchListing(id int32) (*pb.Listing, *events.Subscription, error)
+
+	// Carts
+	GetCart(userID int32) ([]*pb.CartItem, error)
+	AddCartItem(userID int32, item *pb.CartItem, now time.Time) ([]*pb.CartItem, error)
+	SetCartItemQuantity(userID int32, listingID int32, variationID int32, quantity int32) ([]*pb.CartItem, error)
+	Checkout(userID int32, address *pb.Address, buyerNotes string, now time.Time) ([]*pb.Order, error)
+
+	// Offers
+	SetBestOffer(listingID int32, terms *pb.BestOffer) (*pb.Listing, error)
+	UpdateListingBestOffer(id int32, listing *pb.Listing, userID int32, terms *pb.BestOffer) error
+	CreateOffer(offer *pb.Offer, now time.Time) (*pb.Order, error)
+	GetOffer(id int32) (*pb.Offer, error)
+	GetOffers(filter OfferFilter) ([]*pb.Offer, error)
+	CounterOffer(id int32, userID int32, amount *pb.Money, message string, expiresAt time.Time, now time.Time) (*pb.Offer, *pb.Order, error)
+	AcceptOffer(id int32, userID int32, now time.Time) (*pb.Offer, *pb.Order, error)
+	DeclineOffer(id int32, userID int32, now time.Time) (*pb.Offer, error)
+	WithdrawOffer(id int32, userID int32, now time.Time) (*pb.Offer, error)
+	ExpireOffer(id int32, now time.Time) (*pb.Offer, error)
+}
+
+// ListingFilter narrows down GetListings results. Zero values disable a filter.
+type ListingFilter struct {
+	Search string
+
+	// PriceMin and PriceMax share a currency. Listings priced in another
+	// currency are converted with Rates, and never match without it.
+	PriceMin *pb.Money
+	PriceMax *pb.Money
+	Rates    money.RateProvider
+
+	// Category matches listings in the category with this slug or any of its
+	// descendants.
+	Category string
+
+	// Attributes must all match the listing's item specifics.
+	Attributes []*pb.AttributeFilter
+
+	// Statuses restricts results to listings in one of these statuses.
+	Statuses []pb.ListingStatus
+
+	// EndsBefore matches listings whose run ends at or before this time.
+	EndsBefore time.Time
+
+	// StartsBefore matches listings scheduled to start at or before this time.
+	StartsBefore time.Time
+
+	// UserID restricts results to one seller's listings.
+	UserID int32
+
+	// Format restricts results to fixed-price listings or auctions.
+	Format pb.ListingFormat
+
+	// Near and RadiusKm restrict results to listings with coordinates within
+	// RadiusKm of Near. SortByDistance orders results nearest first.
+	Near           *pb.GeoPoint
+	RadiusKm       float64
+	SortByDistance bool
 }
 
 type InMemoryStorage struct {
-	mu       sync.RWMutex
-	users    map[int32]*pb.User
-	listings map[int32]*pb.Listing
-	orders   map[int32]*pb.Order
-	userID   int32
-	listingID int32
-	orderID  int32
-	passwords map[int32]string // Store passwords separately for security
+	mu         sync.RWMutex
+	users      map[int32]*pb.User
+	listings   map[int32]*pb.Listing
+	orders     map[int32]*pb.Order
+	categories map[int32]*pb.Category
+	images     map[string]*pb.ListingImage
+	revisions  map[int32][]*pb.ListingRevision
+	bids       map[int32][]*pb.Bid   // By listing, oldest first
+	addresses  map[int32]*pb.Address // Shipping address given with each bid
+	carts      map[int32][]*pb.CartItem // By user, in the order items were added
+	offers     map[int32]*pb.Offer
+	offerTerms map[int32]*pb.BestOffer // By listing; private to the seller
+	geo        *geoIndex
+	watchers   *events.Hub
+	userID     int32
+	listingID  int32
+	orderID    int32
+	categoryID int32
+	bidID      int32
+	offerID    int32
+	passwords  map[int32]string // Store passwords separately for security
 }
 
 func NewInMemoryStorage() *InMemoryStorage {
 	return &InMemoryStorage{
-		users:     make(map[int32]*pb.User),
-		listings:  make(map[int32]*pb.Listing),
-		orders:    make(map[int32]*pb.Order),
-		passwords: make(map[int32]string),
-		userID:    1,
-		listingID: 1,
-		orderID:   1,
+		users:      make(map[int32]*pb.User),
+		listings:   make(map[int32]*pb.Listing),
+		orders:     make(map[int32]*pb.Order),
+		categories: make(map[int32]*pb.Category),
+		images:     make(map[string]*pb.ListingImage),
+		revisions:  make(map[int32][]*pb.ListingRevision),
+		bids:       make(map[int32][]*pb.Bid),
+		addresses:  make(map[int32]*pb.Address),
+		carts:      make(map[int32][]*pb.CartItem),
+		offers:     make(map[int32]*pb.Offer),
+		offerTerms: make(map[int32]*pb.BestOffer),
+		geo:        newGeoIndex(),
+		watchers:   events.NewHub(watchBuffer),
+		passwords:  make(map[int32]string),
+		userID:     1,
+		listingID:  1,
+		orderID:    1,
+		categoryID: 1,
+		bidID:      1,
+		offerID:    1,
 	}
 }
 
@@ -68,6 +185,7 @@ func (s *InMemoryStorage) CreateUser(user *pb.User) error {
 	}
 
 	user.Id = s.userID
+	user.Version = 1
 	s.users[s.userID] = user
 	s.userID++
 	return nil
@@ -100,11 +218,16 @@ func (s *InMemoryStorage) UpdateUser(id int32, user *pb.User) error {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
-	if _, exists := s.users[id]; !exists {
+	existing, exists := s.users[id]
+	if !exists {
 		return &NotFoundError{Resource: "User", ID: id}
 	}
+	if err := checkVersion("User", id, user.Version, existing.Version); err != nil {
+		return err
+	}
 
 	user.Id = id
+	user.Version = existing.Version + 1
 	s.users[id] = user
 	return nil
 }
@@ -163,10 +286,18 @@ func (s *InMemoryStorage) CreateListing(listing *pb.Listing) error {
 	defer s.mu.Unlock()
 
 	listing.Id = s.listingID
+	if err := s.checkImages(listing); err != nil {
+		return err
+	}
+	s.claimImages(listing)
 	now := time.Now()
 	listing.CreatedAt = timestamppb.New(now)
 	listing.UpdatedAt = timestamppb.New(now)
+	listing.Version = 1
+	s.attachImages(listing)
+	s.recordRevision(nil, listing, listing.UserId, now)
 	s.listings[s.listingID] = listing
+	s.geo.Put(listing.Id, listing.Coordinates)
 	s.listingID++
 	return nil
 }
@@ -182,45 +313,134 @@ func (s *InMemoryStorage) GetListing(id int32) (*pb.Listing, error) {
 	return listing, nil
 }
 
-func (s *InMemoryStorage) GetListings(search string, priceMin, priceMax float64) ([]*pb.Listing, error) {
+func (s *InMemoryStorage) GetListings(filter ListingFilter) ([]*pb.Listing, error) {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
 
+	// Narrow the candidates through the spatial index when searching by radius
+	candidates := s.listings
+	if filter.Near != nil && filter.RadiusKm > 0 {
+		candidates = make(map[int32]*pb.Listing)
+		for id := range s.geo.Within(filter.Near, filter.RadiusKm) {
+			candidates[id] = s.listings[id]
+		}
+	}
+
+	var categories map[string]bool
+	if filter.Category != "" {
+		categories = s.categorySubtree(filter.Category)
+	}
+
 	var result []*pb.Listing
-	for _, listing := range s.listings {
+	for _, listing := range candidates {
 		// Apply search filter
-		if search != "" {
-			if !contains(listing.Title, search) && !contains(listing.Description, search) {
+		if filter.Search != "" {
+			if !contains(listing.Title, filter.Search) && !contains(listing.Description, filter.Search) {
 				continue
 			}
 		}
 
-		// Apply price filters
-		if priceMin > 0 && listing.Price < priceMin {
+		if len(filter.Statuses) > 0 && !hasStatus(filter.Statuses, listing.Status) {
 			continue
 		}
-		if priceMax > 0 && listing.Price > priceMax {
+		if filter.UserID > 0 && listing.UserId != filter.UserID {
+			continue
+		}
+		if filter.Format != pb.ListingFormat_LISTING_FORMAT_UNSPECIFIED && listingFormat(listing) != filter.Format {
+			continue
+		}
+		if !filter.StartsBefore.IsZero() && (listing.ScheduledStart == nil || listing.ScheduledStart.AsTime().After(filter.StartsBefore)) {
+			continue
+		}
+		if !filter.EndsBefore.IsZero() && (listing.EndsAt == nil || listing.EndsAt.AsTime().After(filter.EndsBefore)) {
+			continue
+		}
+		if categories != nil && !categories[listing.Category] {
+			continue
+		}
+		if !matchesAttributes(listing, filter.Attributes) {
+			continue
+		}
+
+		// Apply price filters
+		if !filter.priceInRange(listing.Price) {
 			continue
 		}
 
 		result = append(result, listing)
 	}
+
+	// Listings without coordinates sort after every located listing
+	if filter.Near != nil && filter.SortByDistance {
+		sort.SliceStable(result, func(i, j int) bool {
+			a, b := result[i].Coordinates, result[j].Coordinates
+			if a == nil || b == nil {
+				return a != nil
+			}
+			return DistanceKm(filter.Near, a) < DistanceKm(filter.Near, b)
+		})
+	}
 	return result, nil
 }
 
-func (s *InMemoryStorage) UpdateListing(id int32, listing *pb.Listing) error {
+// UpdateListing replaces a listing's details and records a revision made by
+// userID if any of them changed.
+func (s *InMemoryStorage) UpdateListing(id int32, listing *pb.Listing, userID int32) error {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
+	return s.updateListing(id, listing, userID, nil)
+}
+
+// updateListing is UpdateListing for callers holding the write lock. apply,
+// if given, runs against the stored listing before the update is saved and
+// may change the new copy or fail the update.
+func (s *InMemoryStorage) updateListing(id int32, listing *pb.Listing, userID int32, apply func(existing *pb.Listing) error) error {
 	existing, exists := s.listings[id]
 	if !exists {
 		return &NotFoundError{Resource: "Listing", ID: id}
 	}
+	// A non-zero version is the version the caller's copy was based on
+	if err := checkVersion("Listing", id, listing.Version, existing.Version); err != nil {
+		return err
+	}
 
+	// Stock, status, run times, bidding and Best Offer only change through
+	// their own transactions, so a stale copy can never undo a reservation,
+	// a transition, a relist, a bid or the seller's offer terms
 	listing.Id = id
+	listing.Quantity = existing.Quantity
+	listing.Variations = nil
+	for _, variation := range existing.Variations {
+		listing.Variations = append(listing.Variations, proto.Clone(variation).(*pb.ListingVariation))
+	}
+	listing.Status = existing.Status
+	listing.EndsAt = existing.EndsAt
+	listing.RelistCount = existing.RelistCount
+	listing.Format = existing.Format
+	listing.Auction = existing.Auction
+	listing.AcceptsOffers = existing.AcceptsOffers
+	if existing.Auction != nil {
+		listing.Price = existing.Price
+	}
+	// Images are checked up front but claimed only once nothing can fail
+	if err := s.checkImages(listing); err != nil {
+		return err
+	}
+	if apply != nil {
+		if err := apply(existing); err != nil {
+			return err
+		}
+	}
+	s.claimImages(listing)
 	listing.CreatedAt = existing.CreatedAt
-	listing.UpdatedAt = timestamppb.New(time.Now())
+	now := time.Now()
+	listing.UpdatedAt = timestamppb.New(now)
+	listing.Version = existing.Version + 1
+	s.attachImages(listing)
+	s.recordRevision(existing, listing, userID, now)
 	s.listings[id] = listing
+	s.geo.Put(id, listing.Coordinates)
 	return nil
 }
 
@@ -233,6 +453,9 @@ func (s *InMemoryStorage) DeleteListing(id int32) error {
 	}
 
 	delete(s.listings, id)
+	delete(s.offerTerms, id)
+	s.geo.Remove(id)
+	s.watchers.CloseListing(id)
 	return nil
 }
 
@@ -241,11 +464,32 @@ func (s *InMemoryStorage) CreateOrder(order *pb.Order) error {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
+	return s.placeOrder(order, time.Now())
+}
+
+// placeOrder reserves stock for an order's items and records it as pending,
+// totalled from the items' prices. Callers must hold the write lock.
+func (s *InMemoryStorage) placeOrder(order *pb.Order, now time.Time) error {
+	sellerID, err := s.orderSeller(order.Items)
+	if err != nil {
+		return err
+	}
+
+	// Reserve stock in the same transaction that records the order
+	if err := s.reserveItems(order.Items); err != nil {
+		return err
+	}
+	if err := TotalOrder(order); err != nil {
+		s.releaseItems(order.Items)
+		return err
+	}
+
 	order.Id = s.orderID
-	now := time.Now()
+	order.SellerId = sellerID
 	order.CreatedAt = timestamppb.New(now)
 	order.UpdatedAt = timestamppb.New(now)
-	order.Status = "pending"
+	order.Version = 1
+	startHistory(order, pb.OrderActor_ORDER_ACTOR_BUYER, order.UserId, now)
 	s.orders[s.orderID] = order
 	s.orderID++
 	return nil
@@ -262,20 +506,15 @@ func (s *InMemoryStorage) GetOrder(id int32) (*pb.Order, error) {
 	return order, nil
 }
 
-func (s *InMemoryStorage) GetOrders(userID int32, status string, page, limit int32) ([]*pb.Order, int32, error) {
+func (s *InMemoryStorage) GetOrders(filter OrderFilter, page, limit int32) ([]*pb.Order, int32, error) {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
 
 	var filtered []*pb.Order
 	for _, order := range s.orders {
-		// Apply filters
-		if userID > 0 && order.UserId != userID {
-			continue
-		}
-		if status != "" && order.Status != status {
-			continue
+		if filter.matches(order) {
+			filtered = append(filtered, order)
 		}
-		filtered = append(filtered, order)
 	}
 
 	total := int32(len(filtered))
@@ -309,10 +548,50 @@ func (s *InMemoryStorage) UpdateOrder(id int32, order *pb.Order) error {
 	if !exists {
 		return &NotFoundError{Resource: "Order", ID: id}
 	}
+	if err := checkVersion("Order", id, order.Version, existing.Version); err != nil {
+		return err
+	}
+
+	// Cancelled orders no longer hold stock, so they cannot be revived
+	if existing.Status == pb.OrderStatus_ORDER_STATUS_CANCELLED {
+		return &OrderCancelledError{ID: id}
+	}
 
+	// Move the reservation when the items change. New items are ordered
+	// against their listing's current revision. Auction sales are settled by
+	// the auction, so their items stay as sold, and shipped items cannot be
+	// taken back.
+	sellerID := existing.SellerId
+	if !sameItems(order.Items, existing.Items) {
+		if !CanTransitionOrder(existing.Status, pb.OrderStatus_ORDER_STATUS_CANCELLED) {
+			return &OrderShippedError{ID: id, Status: existing.Status}
+		}
+		for _, item := range existing.Items {
+			if listing, exists := s.listings[item.ListingId]; exists && listing.Auction != nil {
+				return &AuctionListingError{ID: listing.Id}
+			}
+		}
+		var err error
+		if sellerID, err = s.orderSeller(order.Items); err != nil {
+			return err
+		}
+		s.releaseItems(existing.Items)
+		if err := s.reserveItems(order.Items); err != nil {
+			s.restoreItems(existing.Items)
+			return err
+		}
+	}
+
+	// Statuses only change through TransitionOrder
 	order.Id = id
+	order.SellerId = sellerID
+	order.Status = existing.Status
+	order.StatusHistory = existing.StatusHistory
+	order.CancelledAt = existing.CancelledAt
+	order.CancelReason = existing.CancelReason
 	order.CreatedAt = existing.CreatedAt
 	order.UpdatedAt = timestamppb.New(time.Now())
+	order.Version = existing.Version + 1
 	s.orders[id] = order
 	return nil
 }
@@ -321,15 +600,70 @@ func (s *InMemoryStorage) DeleteOrder(id int32) error {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
-	if _, exists := s.orders[id]; !exists {
+	order, exists := s.orders[id]
+	if !exists {
 		return &NotFoundError{Resource: "Order", ID: id}
 	}
 
+	// Only orders that could still be cancelled hold stock to give back, and
+	// shipped ones must stay on record
+	if order.Status != pb.OrderStatus_ORDER_STATUS_CANCELLED {
+		if !CanTransitionOrder(order.Status, pb.OrderStatus_ORDER_STATUS_CANCELLED) {
+			return &OrderShippedError{ID: id, Status: order.Status}
+		}
+		s.releaseItems(order.Items)
+	}
 	delete(s.orders, id)
 	return nil
 }
 
-// Helper function
+// Helper functions
 func contains(s, substr string) bool {
 	return strings.Contains(strings.ToLower(s), strings.ToLower(substr))
 }
+
+func hasStatus(statuses []pb.ListingStatus, status pb.ListingStatus) bool {
+	for _, s := range statuses {
+		if s == status {
+			return true
+		}
+	}
+	return false
+}
+
+// priceInRange reports whether price lies within the filter's price bounds,
+// converted into their currency if needed.
+func (f ListingFilter) priceInRange(price *pb.Money) bool {
+	bound := f.PriceMin
+	if bound == nil {
+		bound = f.PriceMax
+	}
+	if bound == nil {
+		return true
+	}
+
+	if price.GetCurrencyCode() != bound.CurrencyCode {
+		if f.Rates == nil {
+			return false
+		}
+		rate, err := f.Rates.Rate(price.GetCurrencyCode(), bound.CurrencyCode)
+		if err != nil {
+			return false
+		}
+		if price, err = money.Convert(price, bound.CurrencyCode, rate); err != nil {
+			return false
+		}
+	}
+
+	if f.PriceMin != nil {
+		if c, err := money.Compare(price, f.PriceMin); err != nil || c < 0 {
+			return false
+		}
+	}
+	if f.PriceMax != nil {
+		if c, err := money.Compare(price, f.PriceMax); err != nil || c > 0 {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,27 @@
+package storage
+
+import "strconv"
+
+// VersionConflictError is returned when an update names an expected version
+// that is no longer the stored one, because someone else changed the record
+// in the meantime.
+type VersionConflictError struct {
+	Resource string
+	ID       int32
+	Expected int64
+	Actual   int64
+}
+
+func (e *VersionConflictError) Error() string {
+	return e.Resource + " was modified concurrently (version " + strconv.FormatInt(e.Actual, 10) +
+		", expected " + strconv.FormatInt(e.Expected, 10) + ")"
+}
+
+// checkVersion verifies an expected version against the stored one. An
+// expected version of zero skips the check.
+func checkVersion(resource string, id int32, expected, actual int64) error {
+	if expected != 0 && expected != actual {
+		return &VersionConflictError{Resource: resource, ID: id, Expected: expected, Actual: actual}
+	}
+	return nil
+}
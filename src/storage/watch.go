@@ -0,0 +1,36 @@
+package storage
+
+import (
+	"time"
+
+	pb "ebayclone-grpc/proto"
+	"ebayclone-grpc/src/events"
+)
+
+// watchBuffer is how many events a watcher may fall behind by before it is
+// dropped. A bid produces at most a handful.
+const watchBuffer = 64
+
+// WatchListing subscribes to the changes of a listing and returns it as of
+// the subscription, so no change is missed or seen twice between the two.
+// Only auctions publish changes. The caller must close the subscription.
+func (s *InMemoryStorage) WatchListing(id int32) (*pb.Listing, *events.Subscription, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	listing, exists := s.listings[id]
+	if !exists {
+		return nil, nil, &NotFoundError{Resource: "Listing", ID: id}
+	}
+	return listing, s.watchers.Subscribe(id), nil
+}
+
+// publishChange tells the watchers of an auction what changed between
+// before and after. Callers must hold the write lock, so events go out in
+// the order changes were made; publishing never blocks on watchers.
+func (s *InMemoryStorage) publishChange(before, after *pb.Listing, bids []*pb.Bid, now time.Time) {
+	if after.Auction == nil || !s.watchers.Watched(after.Id) {
+		return
+	}
+	s.watchers.Publish(after.Id, events.Changes(before, after, bids, now)...)
+}
@@ -75,9 +75,14 @@ grpcurl -plaintext -d "{\"id\":1}" \
 localhost:50051 ebayclone.UserService/GetUser | grep -q "testuser"
 '
 
-# Test 4: Create Listing
+# Test 4: Create Category and Listing
+run_test "Create Category" '
+grpcurl -plaintext -d "{\"slug\":\"electronics\",\"name\":\"Electronics\"}" \
+localhost:50051 ebayclone.CategoryService/CreateCategory | grep -q "electronics"
+'
+
 run_test "Create Listing" '
-grpcurl -plaintext -d "{\"title\":\"iPhone 13\",\"description\":\"Great phone\",\"price\":999.99,\"category\":\"electronics\",\"condition\":\"new\"}" \
+grpcurl -plaintext -d "{\"title\":\"iPhone 13\",\"description\":\"Great phone\",\"price\":{\"currencyCode\":\"USD\",\"minorUnits\":99999},\"category\":\"electronics\",\"condition\":\"new\"}" \
 localhost:50051 ebayclone.ListingService/CreateListing | grep -q "iPhone 13"
 '
 
@@ -96,7 +101,7 @@ localhost:50051 ebayclone.ListingService/GetListing | grep -q "iPhone 13"
 # Test 7: Create Order
 run_test "Create Order" '
 grpcurl -plaintext -d "{\"listingId\":1,\"quantity\":1,\"shippingAddress\":{\"street\":\"123 Main St\",\"city\":\"New York\",\"country\":\"USA\"}}" \
-localhost:50051 ebayclone.OrderService/CreateOrder | grep -q "pending"
+localhost:50051 ebayclone.OrderService/CreateOrder | grep -q "ORDER_STATUS_PENDING"
 '
 
 # Test 8: Get Orders
@@ -107,14 +112,14 @@ localhost:50051 ebayclone.OrderService/GetOrders | grep -q "orders"
 
 # Test 9: Update Order Status
 run_test "Update Order Status" '
-grpcurl -plaintext -d "{\"id\":1,\"status\":\"confirmed\"}" \
-localhost:50051 ebayclone.OrderService/UpdateOrderStatus | grep -q "confirmed"
+grpcurl -plaintext -d "{\"id\":1,\"status\":\"ORDER_STATUS_CONFIRMED\"}" \
+localhost:50051 ebayclone.OrderService/UpdateOrderStatus | grep -q "ORDER_STATUS_CONFIRMED"
 '
 
 # Test 10: Cancel Order
 run_test "Cancel Order" '
 grpcurl -plaintext -d "{\"id\":1,\"cancelReason\":\"Changed my mind\"}" \
-localhost:50051 ebayclone.OrderService/CancelOrder | grep -q "cancelled"
+localhost:50051 ebayclone.OrderService/CancelOrder | grep -q "ORDER_STATUS_CANCELLED"
 '
 
 # Test 11: Error Handling - Invalid User ID